@@ -3,25 +3,96 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/morozoffnor/go-url-shortener/internal/audit"
 	"github.com/morozoffnor/go-url-shortener/internal/auth"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
 	"github.com/morozoffnor/go-url-shortener/internal/handlers"
+	"github.com/morozoffnor/go-url-shortener/internal/routing"
+	"github.com/morozoffnor/go-url-shortener/internal/rpc"
 	"github.com/morozoffnor/go-url-shortener/internal/server"
 	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
 	"golang.org/x/sync/errgroup"
+	"log"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
+	// shortener migrate ... управляет схемой базы и не запускает сервер
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:], os.Stdout))
+	}
+	os.Exit(run())
+}
+
+// run запускает сервер и возвращает код выхода. os.Exit вызывается только в main,
+// чтобы отложенные Close (журнал аудита, GeoIP) успели выполниться
+func run() int {
+	cfg, err := config.New()
+	if config.IsHelp(err) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: %s\n", err)
+		return 2
+	}
+	log.Printf("effective config:\n%s", cfg)
+	if err := logger.SetLevel(cfg.LogLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: %s\n", err)
+		return 2
+	}
+
 	// Создаём бесконечный контекст
 	ctx, cancel := context.WithCancel(context.Background())
-	cfg := config.New()
-	strg := storage.NewStorage(cfg, ctx)
-	authHelper := auth.New(cfg)
-	h := handlers.New(cfg, strg, authHelper)
-	s := server.New(cfg, h)
+	defer cancel()
+	strg, err := storage.NewStorage(cfg, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: storage: %s\n", err)
+		return 1
+	}
+	// в режиме DegradedStart база подключается в фоне, ошибка подключения завершает сервер
+	connector, _ := strg.(storage.Connector)
+	authHelper, err := auth.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: %s\n", err)
+		return 1
+	}
+	auditor, err := audit.FromConfig(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: %s\n", err)
+		return 1
+	}
+	// события дописываются до выхода, webhook успевает отправить очередь
+	defer auditor.Close()
+	strg = audit.Wrap(strg, auditor)
+	// без базы GeoIP правила по стране просто не срабатывают
+	var geo routing.CountryResolver
+	if cfg.GeoIPFile != "" {
+		g, err := routing.OpenGeoIP(cfg.GeoIPFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "exit reason: geoip: %s\n", err)
+			return 1
+		}
+		defer g.Close()
+		geo = g
+	}
+	h := handlers.New(cfg, strg, authHelper, auditor, geo)
+	s, err := server.New(cfg, h)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: %s\n", err)
+		return 1
+	}
+
+	// перечитываем конфиг по SIGHUP и при изменении файла
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reloader := config.NewReloader(cfg, os.Args[1:], os.LookupEnv)
+	go reloader.Watch(ctx, hup, 5*time.Second)
+
 	// ожидаем завершение в горутине, отправляем в канал
 	go func() {
 		c := make(chan os.Signal, 1)
@@ -45,7 +116,39 @@ func main() {
 		return s.Shutdown(context.Background())
 	})
 
+	if connector != nil {
+		g.Go(func() error {
+			select {
+			case <-connector.Connected():
+				if err := connector.Err(); err != nil && gCtx.Err() == nil {
+					return fmt.Errorf("storage: %w", err)
+				}
+			case <-gCtx.Done():
+			}
+			return nil
+		})
+	}
+
+	// gRPC-сервер работает рядом с http и делит с ним хранилище и авторизацию
+	if cfg.GRPCAddr != "" {
+		gs := rpc.New(cfg, strg, authHelper, s.TLSConfig())
+		g.Go(func() error {
+			lis, err := net.Listen("tcp", cfg.GRPCAddr)
+			if err != nil {
+				return err
+			}
+			return gs.Serve(lis)
+		})
+		g.Go(func() error {
+			<-gCtx.Done()
+			gs.GracefulStop()
+			return nil
+		})
+	}
+
 	if err := g.Wait(); err != nil {
 		fmt.Printf("exit reason: %s \n", err)
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage: shortener migrate [flags] <command>
+
+commands:
+  up         apply all pending migrations
+  down N     roll back the last N migrations
+  version    print the current schema version
+  force V    set the schema version without running migrations and clear the dirty flag
+  status     list migrations and whether they are applied
+
+flags are the same as for the server, the database is taken from -d, DATABASE_DSN or the config file
+`
+
+var errUsage = errors.New("invalid arguments")
+
+type migrateCommand struct {
+	name string
+	n    int
+}
+
+func parseMigrateCommand(args []string) (migrateCommand, error) {
+	if len(args) == 0 {
+		return migrateCommand{}, errUsage
+	}
+	cmd := migrateCommand{name: args[0]}
+	switch cmd.name {
+	case "up", "version", "status":
+		if len(args) != 1 {
+			return cmd, errUsage
+		}
+	case "down", "force":
+		if len(args) != 2 {
+			return cmd, errUsage
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return cmd, errUsage
+		}
+		cmd.n = n
+	default:
+		return cmd, errUsage
+	}
+	return cmd, nil
+}
+
+// runMigrate выполняет shortener migrate и возвращает код выхода
+func runMigrate(args []string, out io.Writer) int {
+	cfg, rest, err := config.LoadWithArgs(args, os.LookupEnv)
+	if config.IsHelp(err) {
+		fmt.Fprint(out, migrateUsage)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: config: %s\n", err)
+		return 2
+	}
+	cmd, err := parseMigrateCommand(rest)
+	if err != nil {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	if cfg.DatabaseDSN == "" {
+		fmt.Fprintln(os.Stderr, "exit reason: database_dsn is not set")
+		return 2
+	}
+
+	m, err := storage.NewMigrator(cfg.DatabaseDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: %s\n", err)
+		return 1
+	}
+	defer m.Close()
+	if err := cmd.run(m, out); err != nil {
+		fmt.Fprintf(os.Stderr, "exit reason: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd migrateCommand) run(m *storage.Migrator, out io.Writer) error {
+	before, err := m.State()
+	if err != nil {
+		return err
+	}
+	switch cmd.name {
+	case "version":
+		printVersion(out, before)
+		return nil
+	case "status":
+		printStatus(out, before)
+		return nil
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Down(cmd.n)
+	case "force":
+		err = m.Force(cmd.n)
+	}
+	if err != nil {
+		return err
+	}
+	after, err := m.State()
+	if err != nil {
+		return err
+	}
+	if after.Version == before.Version && after.Dirty == before.Dirty {
+		fmt.Fprintf(out, "no change, ")
+	} else {
+		fmt.Fprintf(out, "%d -> ", before.Version)
+	}
+	printVersion(out, after)
+	return nil
+}
+
+func printVersion(out io.Writer, st storage.MigrationState) {
+	name := "no migrations applied"
+	for _, mg := range st.Migrations {
+		if mg.Version == st.Version {
+			name = mg.Name
+		}
+	}
+	fmt.Fprintf(out, "version %d (%s)\n", st.Version, name)
+	if st.Dirty {
+		fmt.Fprintf(out, "migration %d failed halfway: fix the schema by hand, then run `shortener migrate force N` with the version it is actually at\n", st.Version)
+	}
+}
+
+func printStatus(out io.Writer, st storage.MigrationState) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+	for _, mg := range st.Migrations {
+		status := "pending"
+		switch {
+		case st.Dirty && mg.Version == st.Version:
+			status = "dirty"
+		case mg.Applied:
+			status = "applied"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", mg.Version, mg.Name, status)
+	}
+	tw.Flush()
+	printVersion(out, st)
+}
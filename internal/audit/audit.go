@@ -0,0 +1,186 @@
+// Package audit записывает, кто, когда и что менял: создание, изменение
+// и удаление ссылок, доменов, пользователей и ключей. События уходят
+// в один или несколько приёмников (Sink): файл с цепочкой хэшей,
+// таблицу в Postgres, вебхук
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/pkg/requestid"
+	"time"
+)
+
+type Event struct {
+	ID        string         `json:"id"`
+	Time      time.Time      `json:"time"`
+	Action    string         `json:"action"`
+	UserID    string         `json:"user_id,omitempty"`
+	Role      string         `json:"role,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	ClientIP  string         `json:"client_ip,omitempty"`
+	Target    string         `json:"target,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+
+	// PrevHash и Hash заполняет FileSink, см. Verify
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Filter — условия выборки, пустые поля не учитываются
+type Filter struct {
+	UserID string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+func (f Filter) match(e Event) bool {
+	switch {
+	case f.UserID != "" && e.UserID != f.UserID:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.Target != "" && e.Target != f.Target:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+func (f Filter) normalize() Filter {
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+	return f
+}
+
+type Sink interface {
+	Write(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Querier — приёмник, из которого события можно прочитать обратно
+type Querier interface {
+	// Query возвращает события от новых к старым
+	Query(ctx context.Context, f Filter) ([]Event, error)
+}
+
+var ErrNotQueryable = errors.New("no audit sink supports queries")
+
+type Auditor struct {
+	sinks []Sink
+}
+
+func New(sinks ...Sink) *Auditor {
+	return &Auditor{sinks: sinks}
+}
+
+// Emit дополняет событие данными о пользователе и запросе из контекста
+// и пишет его во все приёмники. Ошибка приёмника не должна ломать
+// саму операцию, поэтому она только логируется
+func (a *Auditor) Emit(ctx context.Context, action, target string, details map[string]any) {
+	e := Event{
+		ID:      uuid.NewString(),
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+	if id, ok := auth.IdentityFromContext(ctx); ok {
+		e.UserID = id.UserID.String()
+		e.Role = id.Role
+	}
+	req := requestid.FromContext(ctx)
+	e.RequestID = req.ID
+	e.ClientIP = req.ClientIP
+
+	// запись не должна зависеть от отмены запроса, который её вызвал
+	ctx = context.WithoutCancel(ctx)
+	for _, s := range a.sinks {
+		if err := s.Write(ctx, e); err != nil {
+			logger.Logger.Errorw("audit sink failed", "action", action, "error", err)
+		}
+	}
+}
+
+// Query читает события из первого приёмника, который это умеет
+func (a *Auditor) Query(ctx context.Context, f Filter) ([]Event, error) {
+	for _, s := range a.sinks {
+		if q, ok := s.(Querier); ok {
+			return q.Query(ctx, f.normalize())
+		}
+	}
+	return nil, ErrNotQueryable
+}
+
+func (a *Auditor) Close() error {
+	var errs []error
+	for _, s := range a.sinks {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// LogSink пишет события в общий лог, используется, если другие приёмники не настроены
+type LogSink struct{}
+
+func (LogSink) Write(_ context.Context, e Event) error {
+	logger.Logger.Infow("audit",
+		"action", e.Action,
+		"target", e.Target,
+		"user_id", e.UserID,
+		"request_id", e.RequestID,
+		"client_ip", e.ClientIP,
+		"details", e.Details,
+	)
+	return nil
+}
+
+func (LogSink) Close() error {
+	return nil
+}
+
+// FromConfig собирает приёмники по настройкам audit_*
+func FromConfig(ctx context.Context, cfg *config.Config) (*Auditor, error) {
+	var sinks []Sink
+	if cfg.AuditFile != "" {
+		s, err := NewFileSink(cfg.AuditFile)
+		if err != nil {
+			return nil, fmt.Errorf("audit file: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.AuditPostgres {
+		s, err := NewPostgresSink(ctx, cfg.DatabaseDSN)
+		if err != nil {
+			return nil, fmt.Errorf("audit postgres: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.AuditWebhookURL != "" {
+		sinks = append(sinks, NewWebhookSink(cfg.AuditWebhookURL))
+	}
+	if len(sinks) == 0 {
+		sinks = append(sinks, LogSink{})
+	}
+	return New(sinks...), nil
+}
@@ -0,0 +1,219 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySink запоминает события для проверок
+type memorySink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *memorySink) Write(_ context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestFileSinkChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	a := New(sink)
+	ctx := requestid.WithInfo(context.Background(), requestid.Info{ID: "r1", ClientIP: "10.0.0.1"})
+	a.Emit(ctx, ActionLinkCreate, "abc", map[string]any{"original_url": "http://a.com/"})
+	a.Emit(ctx, ActionLinkDelete, "abc", nil)
+	require.NoError(t, a.Close())
+
+	// после перезапуска цепочка продолжается с последнего хэша
+	sink, err = NewFileSink(path)
+	require.NoError(t, err)
+	a = New(sink)
+	a.Emit(context.Background(), ActionDomainAdd, "go.brand.com", nil)
+	require.NoError(t, Verify(path))
+
+	events, err := a.Query(context.Background(), Filter{Target: "abc"})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, ActionLinkDelete, events[0].Action)
+	assert.Equal(t, "r1", events[1].RequestID)
+	assert.Equal(t, "10.0.0.1", events[1].ClientIP)
+	events, err = a.Query(context.Background(), Filter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, ActionDomainAdd, events[0].Action)
+	require.NoError(t, a.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{name: "edited record", lines: []string{lines[0], strings.Replace(lines[1], "link.delete", "link.create", 1), lines[2]}, wantErr: "line 2: record was modified"},
+		{name: "removed record", lines: []string{lines[0], lines[2]}, wantErr: "line 2: chain broken"},
+		{name: "reordered records", lines: []string{lines[1], lines[0], lines[2]}, wantErr: "line 1: chain broken"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "audit.log")
+			require.NoError(t, os.WriteFile(tampered, []byte(strings.Join(test.lines, "\n")+"\n"), 0600))
+			assert.ErrorContains(t, Verify(tampered), test.wantErr)
+		})
+	}
+}
+
+// события хранилища с типизированными Details проверяются после записи в файл
+func TestFileSinkVerifiesStorageEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	s := Wrap(storage.NewMemoryStorage(&config.Config{ResultAddr: "http://localhost:8080"}), New(sink))
+
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+	code, err := s.AddNewURL(ctx, "http://a.com/")
+	require.NoError(t, err)
+	link, err := s.GetLink(ctx, code)
+	require.NoError(t, err)
+	notBefore := time.Now().Add(time.Hour).Round(0)
+	notAfter := notBefore.Add(time.Hour)
+	require.NoError(t, s.SetLinkOptions(ctx, link.ID, storage.LinkOptions{MaxClicks: 3, NotBefore: &notBefore, NotAfter: &notAfter}))
+	require.NoError(t, s.UpdateLink(ctx, link.ID, storage.LinkUpdate{Metadata: map[string]string{"team": "growth"}}))
+	require.NoError(t, sink.Close())
+
+	require.NoError(t, Verify(path))
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// первая попытка неудачная, событие должно прийти повторно
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		received = append(received, e)
+	}))
+	defer srv.Close()
+
+	a := New(NewWebhookSink(srv.URL))
+	a.Emit(context.Background(), ActionUserCreate, "alice", nil)
+	require.NoError(t, a.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 1)
+	assert.Equal(t, ActionUserCreate, received[0].Action)
+	_, err := a.Query(context.Background(), Filter{})
+	assert.ErrorIs(t, err, ErrNotQueryable)
+}
+
+func TestStorageEmitsEvents(t *testing.T) {
+	cfg := &config.Config{ResultAddr: "http://localhost:8080"}
+	sink := &memorySink{}
+	s := Wrap(storage.NewMemoryStorage(cfg), New(sink))
+
+	userID := uuid.New()
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: userID})
+	code, err := s.AddNewURL(ctx, "http://a.com/")
+	require.NoError(t, err)
+	// уже сокращённые адреса новых ссылок не создают
+	_, err = s.AddNewURL(ctx, "http://a.com/")
+	require.ErrorIs(t, err, storage.ErrURLExists)
+	batch, err := s.AddBatch(ctx, []storage.BatchInput{
+		{CorrelationID: "1", OriginalURL: "http://b.com/"},
+		{CorrelationID: "2", OriginalURL: "http://a.com/"},
+		{CorrelationID: "3", OriginalURL: "http://b.com/"},
+	})
+	require.NoError(t, err)
+	s.DeleteURLs(ctx, userID, storage.URLsForDeletion{code})
+	link, err := s.GetLink(ctx, code)
+	require.NoError(t, err)
+	require.NoError(t, s.DisableLink(ctx, link.ID, http.StatusGone, "spam"))
+	require.NoError(t, s.DisableLink(ctx, link.ID, 0, ""))
+	// неудачная операция в журнал не попадает
+	assert.Error(t, s.TransferLink(ctx, "missing", uuid.New()))
+
+	batched, err := s.GetLink(ctx, strings.TrimPrefix(batch[0].ShortURL, cfg.BaseURL()+"/"))
+	require.NoError(t, err)
+
+	var actions, targets []string
+	for _, e := range sink.events {
+		actions = append(actions, e.Action)
+		targets = append(targets, e.Target)
+		assert.Equal(t, userID.String(), e.UserID)
+		assert.WithinDuration(t, time.Now(), e.Time, time.Minute)
+	}
+	assert.Equal(t, []string{ActionLinkCreate, ActionLinkCreate, ActionLinkDelete, ActionLinkDisable, ActionLinkEnable}, actions)
+	// ссылка записывается под своим id, каким бы путём её ни меняли
+	assert.Equal(t, []string{link.ID, batched.ID, link.ID, link.ID, link.ID}, targets)
+	assert.True(t, s.Ping(ctx))
+}
+
+func TestStorageLogsOnlyDeletedLinks(t *testing.T) {
+	cfg := &config.Config{ResultAddr: "http://localhost:8080"}
+	sink := &memorySink{}
+	s := Wrap(storage.NewMemoryStorage(cfg), New(sink))
+
+	owner := uuid.New()
+	ownerCtx := auth.WithIdentity(context.Background(), auth.Identity{UserID: owner})
+	code, err := s.AddNewURL(ownerCtx, "http://a.com/")
+	require.NoError(t, err)
+	mine, err := s.AddNewURL(ownerCtx, "http://b.com/")
+	require.NoError(t, err)
+
+	// чужой и несуществующий коды не удаляются и в журнал не попадают
+	other := uuid.New()
+	otherCtx := auth.WithIdentity(context.Background(), auth.Identity{UserID: other})
+	deleted := s.DeleteURLs(otherCtx, other, storage.URLsForDeletion{code, "missing"})
+	assert.Empty(t, deleted)
+
+	deleted = s.DeleteURLs(ownerCtx, owner, storage.URLsForDeletion{mine, "missing"})
+	assert.Equal(t, storage.URLsForDeletion{mine}, deleted)
+	// повторное удаление уже ничего не меняет
+	assert.Empty(t, s.DeleteURLs(ownerCtx, owner, storage.URLsForDeletion{mine}))
+
+	var targets []string
+	for _, e := range sink.events {
+		if e.Action == ActionLinkDelete {
+			targets = append(targets, e.Target)
+			assert.Equal(t, owner.String(), e.UserID)
+		}
+	}
+	link, err := s.GetLink(ownerCtx, mine)
+	require.NoError(t, err)
+	assert.Equal(t, []string{link.ID}, targets)
+}
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// FileSink дописывает события в JSON-lines файл. Каждая запись содержит хэш
+// предыдущей, поэтому правка или удаление строки в середине файла ломает
+// цепочку и обнаруживается Verify
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	last string
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	last, err := lastHash(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{path: path, file: f, last: last}, nil
+}
+
+// chainHash считает хэш события вместе с хэшем предыдущего. Событие сначала
+// проходит через json так же, как при чтении файла: структуры в Details после
+// чтения становятся map[string]any и сериализуются с другим порядком полей
+func chainHash(e Event) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	var read Event
+	if err := json.Unmarshal(data, &read); err != nil {
+		return "", err
+	}
+	if data, err = json.Marshal(read); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (s *FileSink) Write(_ context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.PrevHash = s.last
+	hash, err := chainHash(e)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.last = hash
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// readEvents вызывает fn для каждой записи файла по порядку
+func readEvents(path string, fn func(line int, e Event) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("audit line %d: %w", line, err)
+		}
+		if err := fn(line, e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func lastHash(path string) (string, error) {
+	var last string
+	err := readEvents(path, func(_ int, e Event) error {
+		last = e.Hash
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return last, err
+}
+
+// Verify проходит по файлу и проверяет цепочку хэшей
+func Verify(path string) error {
+	var prev string
+	return readEvents(path, func(line int, e Event) error {
+		if e.PrevHash != prev {
+			return fmt.Errorf("audit line %d: chain broken, previous record is missing or changed", line)
+		}
+		hash, err := chainHash(e)
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("audit line %d: record was modified", line)
+		}
+		prev = e.Hash
+		return nil
+	})
+}
+
+func (s *FileSink) Query(_ context.Context, f Filter) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []Event
+	err := readEvents(s.path, func(_ int, e Event) error {
+		if f.match(e) {
+			result = append(result, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(result)
+	if len(result) > f.Limit {
+		result = result[:f.Limit]
+	}
+	return result, nil
+}
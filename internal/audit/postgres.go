@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// PostgresSink пишет события в таблицу audit_events (см. миграции storage)
+type PostgresSink struct {
+	conn *pgxpool.Pool
+}
+
+func NewPostgresSink(ctx context.Context, dsn string) (*PostgresSink, error) {
+	conn, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresSink{conn: conn}, nil
+}
+
+func (s *PostgresSink) Write(ctx context.Context, e Event) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err := s.conn.Exec(ctx, `INSERT INTO audit_events
+		(id, time, action, user_id, role, request_id, client_ip, target, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		e.ID, e.Time, e.Action, e.UserID, e.Role, e.RequestID, e.ClientIP, e.Target, e.Details)
+	return err
+}
+
+func (s *PostgresSink) Query(ctx context.Context, f Filter) ([]Event, error) {
+	var since, until *time.Time
+	if !f.Since.IsZero() {
+		since = &f.Since
+	}
+	if !f.Until.IsZero() {
+		until = &f.Until
+	}
+	rows, err := s.conn.Query(ctx, `SELECT id, time, action, user_id, role, request_id, client_ip, target, details
+		FROM audit_events
+		WHERE ($1 = '' OR user_id = $1)
+		  AND ($2 = '' OR action = $2)
+		  AND ($3 = '' OR target = $3)
+		  AND ($4::timestamptz IS NULL OR time >= $4)
+		  AND ($5::timestamptz IS NULL OR time < $5)
+		ORDER BY time DESC LIMIT $6`,
+		f.UserID, f.Action, f.Target, since, until, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Event, error) {
+		var e Event
+		err := row.Scan(&e.ID, &e.Time, &e.Action, &e.UserID, &e.Role, &e.RequestID, &e.ClientIP, &e.Target, &e.Details)
+		return e, err
+	})
+}
+
+func (s *PostgresSink) Close() error {
+	s.conn.Close()
+	return nil
+}
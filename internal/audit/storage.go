@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+)
+
+// Действия, которые попадают в журнал
+const (
+	ActionLinkCreate      = "link.create"
+	ActionLinkDelete      = "link.delete"
+	ActionLinkDisable     = "link.disable"
+	ActionLinkEnable      = "link.enable"
+	ActionLinkTransfer    = "link.transfer"
+	ActionLinkUpdate      = "link.update"
+	ActionLinksClaim      = "links.claim"
+	ActionDomainAdd       = "domain.add"
+	ActionDomainDelete    = "domain.delete"
+	ActionDomainLinkPurge = "domain.delete_links"
+	ActionUserCreate      = "user.create"
+	ActionAPIKeyCreate    = "api_key.create"
+	ActionAPIKeyDelete    = "api_key.delete"
+)
+
+// Storage оборачивает хранилище и пишет событие после каждой успешной
+// изменяющей операции. Новые изменяющие методы хранилища нужно
+// переопределить здесь же, иначе они пройдут мимо журнала
+type Storage struct {
+	storage.Storage
+	a *Auditor
+}
+
+func Wrap(s storage.Storage, a *Auditor) *Storage {
+	return &Storage{Storage: s, a: a}
+}
+
+// Ping пробрасывается явно: обёртка не должна прятать storage.Pingable
+func (s *Storage) Ping(ctx context.Context) bool {
+	if p, ok := s.Storage.(storage.Pingable); ok {
+		return p.Ping(ctx)
+	}
+	return true
+}
+
+func domainOf(ctx context.Context) string {
+	return storage.DomainFromContext(ctx).Name
+}
+
+// linkID находит id ссылки по коду. Ссылка в журнале всегда записывается под
+// своим id: код уникален только в домене, а изменяющие методы получают id
+func (s *Storage) linkID(ctx context.Context, code string) string {
+	link, err := s.Storage.GetLink(ctx, code)
+	if err != nil {
+		logger.Logger.Errorw("audit: link lookup failed", "code", code, "error", err)
+		return ""
+	}
+	return link.ID
+}
+
+// AddNewURL пишет событие только для новой ссылки: уже сокращённый адрес
+// возвращается с ErrURLExists
+func (s *Storage) AddNewURL(ctx context.Context, full string) (string, error) {
+	code, err := s.Storage.AddNewURL(ctx, full)
+	if err == nil {
+		s.a.Emit(ctx, ActionLinkCreate, s.linkID(ctx, code), map[string]any{
+			"domain":       domainOf(ctx),
+			"code":         code,
+			"original_url": full,
+		})
+	}
+	return code, err
+}
+
+func (s *Storage) AddNewURLWithOptions(ctx context.Context, full string, opts storage.LinkOptions) (string, error) {
+	code, err := s.Storage.AddNewURLWithOptions(ctx, full, opts)
+	if err == nil {
+		s.a.Emit(ctx, ActionLinkCreate, s.linkID(ctx, code), map[string]any{
+			"domain":       domainOf(ctx),
+			"code":         code,
+			"original_url": full,
+			"protected":    opts.PasswordHash != "",
+			"max_clicks":   opts.MaxClicks,
+		})
+	}
+	return code, err
+}
+
+func (s *Storage) AddBatch(ctx context.Context, urls []storage.BatchInput) ([]storage.BatchOutput, error) {
+	out, err := s.Storage.AddBatch(ctx, urls)
+	if err != nil {
+		return out, err
+	}
+	for i, o := range out {
+		if !o.Created {
+			continue
+		}
+		details := map[string]any{"domain": domainOf(ctx), "short_url": o.ShortURL, "batch": true}
+		if i < len(urls) {
+			details["original_url"] = urls[i].OriginalURL
+		}
+		s.a.Emit(ctx, ActionLinkCreate, o.ID, details)
+	}
+	return out, nil
+}
+
+// DeleteURLs пишет в журнал только коды, которые хранилище действительно удалило
+func (s *Storage) DeleteURLs(ctx context.Context, userID uuid.UUID, urls storage.URLsForDeletion) storage.URLsForDeletion {
+	deleted := s.Storage.DeleteURLs(ctx, userID, urls)
+	for _, code := range deleted {
+		// удалённая ссылка только помечается, поэтому её id ещё можно найти
+		s.a.Emit(ctx, ActionLinkDelete, s.linkID(ctx, code), map[string]any{
+			"owner":  userID.String(),
+			"domain": domainOf(ctx),
+			"code":   code,
+		})
+	}
+	return deleted
+}
+
+func (s *Storage) SetLinkOptions(ctx context.Context, id string, opts storage.LinkOptions) error {
+	err := s.Storage.SetLinkOptions(ctx, id, opts)
+	if err == nil {
+		// хэш пароля в журнал не попадает
+		opts.PasswordHash = ""
+		s.a.Emit(ctx, ActionLinkUpdate, id, map[string]any{"options": opts})
+	}
+	return err
+}
+
+func (s *Storage) UpdateLink(ctx context.Context, id string, upd storage.LinkUpdate) error {
+	err := s.Storage.UpdateLink(ctx, id, upd)
+	if err == nil {
+		details := map[string]any{}
+		if upd.OriginalURL != nil {
+			details["original_url"] = *upd.OriginalURL
+		}
+		if upd.Metadata != nil {
+			details["metadata"] = upd.Metadata
+		}
+		s.a.Emit(ctx, ActionLinkUpdate, id, details)
+	}
+	return err
+}
+
+// ConsumeClick — это переход, а не изменение ссылки, в журнал он не пишется
+func (s *Storage) ConsumeClick(ctx context.Context, id string) error {
+	return s.Storage.ConsumeClick(ctx, id)
+}
+
+func (s *Storage) AddDomain(ctx context.Context, d storage.Domain) error {
+	err := s.Storage.AddDomain(ctx, d)
+	if err == nil {
+		s.a.Emit(ctx, ActionDomainAdd, d.Name, map[string]any{"base_url": d.BaseURL})
+	}
+	return err
+}
+
+func (s *Storage) DeleteDomain(ctx context.Context, name string) error {
+	err := s.Storage.DeleteDomain(ctx, name)
+	if err == nil {
+		s.a.Emit(ctx, ActionDomainDelete, name, nil)
+	}
+	return err
+}
+
+func (s *Storage) CreateUser(ctx context.Context, u storage.User) error {
+	err := s.Storage.CreateUser(ctx, u)
+	if err == nil {
+		s.a.Emit(ctx, ActionUserCreate, u.ID.String(), map[string]any{"login": u.Login})
+	}
+	return err
+}
+
+func (s *Storage) CreateAPIKey(ctx context.Context, k storage.APIKey) error {
+	err := s.Storage.CreateAPIKey(ctx, k)
+	if err == nil {
+		s.a.Emit(ctx, ActionAPIKeyCreate, k.ID, map[string]any{"owner": k.UserID.String(), "name": k.Name})
+	}
+	return err
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, userID uuid.UUID, id string) error {
+	err := s.Storage.DeleteAPIKey(ctx, userID, id)
+	if err == nil {
+		s.a.Emit(ctx, ActionAPIKeyDelete, id, map[string]any{"owner": userID.String()})
+	}
+	return err
+}
+
+func (s *Storage) TransferURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	n, err := s.Storage.TransferURLs(ctx, from, to)
+	if err == nil && n > 0 {
+		s.a.Emit(ctx, ActionLinksClaim, to.String(), map[string]any{"from": from.String(), "count": n})
+	}
+	return n, err
+}
+
+func (s *Storage) DisableLink(ctx context.Context, id string, status int, reason string) error {
+	err := s.Storage.DisableLink(ctx, id, status, reason)
+	if err != nil {
+		return err
+	}
+	if status == 0 {
+		s.a.Emit(ctx, ActionLinkEnable, id, nil)
+	} else {
+		s.a.Emit(ctx, ActionLinkDisable, id, map[string]any{"status": status, "reason": reason})
+	}
+	return nil
+}
+
+func (s *Storage) TransferLink(ctx context.Context, id string, to uuid.UUID) error {
+	err := s.Storage.TransferLink(ctx, id, to)
+	if err == nil {
+		s.a.Emit(ctx, ActionLinkTransfer, id, map[string]any{"to": to.String()})
+	}
+	return err
+}
+
+func (s *Storage) DeleteDomainLinks(ctx context.Context, domain string) (int, error) {
+	n, err := s.Storage.DeleteDomainLinks(ctx, domain)
+	if err == nil {
+		s.a.Emit(ctx, ActionDomainLinkPurge, domain, map[string]any{"count": n})
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const webhookQueueSize = 1024
+
+// WebhookSink отправляет события POST-запросом в фоне, чтобы медленный
+// получатель не тормозил запросы пользователей. Если очередь переполнена,
+// событие пропускается с ошибкой в логе
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	queue  chan Event
+	wg     sync.WaitGroup
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	s := &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan Event, webhookQueueSize),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *WebhookSink) Write(_ context.Context, e Event) error {
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full, event %s dropped", e.ID)
+	}
+}
+
+func (s *WebhookSink) run() {
+	defer s.wg.Done()
+	for e := range s.queue {
+		if err := s.send(e); err != nil {
+			logger.Logger.Errorw("audit webhook failed", "event", e.ID, "error", err)
+		}
+	}
+}
+
+// send делает до трёх попыток с растущей паузой
+func (s *WebhookSink) send(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		err = s.post(data)
+		if err == nil || attempt == 2 {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * 200 * time.Millisecond)
+	}
+}
+
+func (s *WebhookSink) post(data []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Close дожидается отправки накопившихся событий
+func (s *WebhookSink) Close() error {
+	close(s.queue)
+	s.wg.Wait()
+	return nil
+}
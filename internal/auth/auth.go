@@ -1,42 +1,61 @@
 package auth
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
 	"net/http"
+	"strings"
 	"time"
 )
 
-type ContextUserIDKey string
-
-var ContextUserID ContextUserIDKey = "user_id"
-
 type JWT struct {
 	config *config.Config
+	keys   *keySet
 }
 
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID uuid.UUID `json:"user_id"`
+	// Registered отличает учётную запись от анонимной личности из куки
+	Registered bool `json:"registered,omitempty"`
+	// Role выдаётся только зарегистрированным пользователям, см. config.AdminLogins
+	Role string `json:"role,omitempty"`
 }
 
-func New(config *config.Config) *JWT {
-	return &JWT{config: config}
+// RoleAdmin открывает /api/admin
+const RoleAdmin = "admin"
+
+const (
+	// AnonymousTokenTTL — время жизни анонимной личности
+	AnonymousTokenTTL = 5 * time.Hour
+	// AccessTokenTTL — время жизни токена зарегистрированного пользователя,
+	// дальше его нужно обновить через refresh-токен
+	AccessTokenTTL = time.Hour
+	// RefreshTokenTTL — время жизни refresh-токена, при каждом обновлении выдаётся новый
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// New загружает ключи подписи из конфига, ошибка означает битый или недоступный файл ключа
+func New(config *config.Config) (*JWT, error) {
+	keys, err := loadKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	return &JWT{config: config, keys: keys}, nil
 }
 
-func (h *JWT) GenerateToken() (string, error) {
-	userID := uuid.New()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+func (h *JWT) GenerateTokenFor(id Identity, ttl time.Duration) (string, error) {
+	tokenString, err := h.sign(&Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 		},
-		UserID: userID,
+		UserID:     id.UserID,
+		Registered: id.Registered,
+		Role:       id.Role,
 	})
-
-	tokenString, err := token.SignedString([]byte(h.config.JWTSecret))
 	if err != nil {
 		return "", err
 	}
@@ -44,12 +63,28 @@ func (h *JWT) GenerateToken() (string, error) {
 	return "Bearer " + tokenString, nil
 }
 
+// sign подписывает claims текущим ключом и проставляет его kid
+func (h *JWT) sign(claims jwt.Claims) (string, error) {
+	key := h.keys.signing
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.sign)
+}
+
+// ParseToken принимает токен как с префиксом "Bearer ", так и без него
 func (h *JWT) ParseToken(token string) (*Claims, error) {
-	token = token[7:]
+	token = strings.TrimSpace(token)
+	if raw, ok := BearerToken(token); ok {
+		token = raw
+	}
+	if token == "" {
+		return nil, errors.New("empty token")
+	}
 	claims := &Claims{}
-	t, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(h.config.JWTSecret), nil
-	})
+	t, err := jwt.ParseWithClaims(token, claims, h.keys.keyFunc,
+		jwt.WithValidMethods(h.keys.methods),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -60,42 +95,49 @@ func (h *JWT) ParseToken(token string) (*Claims, error) {
 	return claims, nil
 }
 
-func (h *JWT) CheckToken(r *http.Request) bool {
-	cookie, err := r.Cookie("Authorization")
+// BearerToken достаёт токен из значения вида "Bearer <jwt>"
+func BearerToken(value string) (string, bool) {
+	const scheme = "Bearer "
+	if len(value) <= len(scheme) || !strings.EqualFold(value[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(value[len(scheme):]), true
+}
 
+// NewIdentity выпускает токен для новой анонимной личности
+func (h *JWT) NewIdentity() (Identity, string, error) {
+	id := Identity{UserID: uuid.New()}
+	token, err := h.GenerateTokenFor(id, AnonymousTokenTTL)
 	if err != nil {
-		return false
+		return Identity{}, "", err
 	}
+	return id, token, nil
+}
 
-	claims, err := h.ParseToken(cookie.Value)
+// Identity проверяет токен и возвращает его владельца
+func (h *JWT) Identity(token string) (Identity, error) {
+	claims, err := h.ParseToken(token)
 	if err != nil {
-		return false
+		return Identity{}, err
 	}
 	if claims.UserID == uuid.Nil {
-		return false
+		return Identity{}, errors.New("token has no user")
 	}
-	return true
+	return Identity{UserID: claims.UserID, Registered: claims.Registered, Role: claims.Role}, nil
 }
 
-func (h *JWT) AddTokenToCookies(w *http.ResponseWriter, r *http.Request) (context.Context, error) {
-	token, err := h.GenerateToken()
-
-	if err != nil {
-		return nil, err
-	}
-
-	http.SetCookie(*w, &http.Cookie{
+func (h *JWT) SetTokenCookie(w http.ResponseWriter, token string, ttl time.Duration) {
+	cookie := &http.Cookie{
 		Name:    "Authorization",
 		Value:   token,
-		Expires: time.Now().Add(5 * time.Hour),
-	})
-
-	claims, err := h.ParseToken(token)
-	if err != nil {
-		return nil, err
+		Path:    "/",
+		Expires: time.Now().Add(ttl),
 	}
-
-	ctx := context.WithValue(r.Context(), ContextUserID, claims.UserID)
-
-	return ctx, nil
+	// по https куку не должно быть видно ни по http, ни из js
+	if h.config.EnableHTTPS {
+		cookie.Secure = true
+		cookie.HttpOnly = true
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, cookie)
 }
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return path
+}
+
+func newJWT(t *testing.T, cfg *config.Config) *JWT {
+	j, err := New(cfg)
+	require.NoError(t, err)
+	return j
+}
+
+func TestParseTokenMalformed(t *testing.T) {
+	j := newJWT(t, &config.Config{JWTSecret: "secret"})
+
+	expired, err := j.GenerateTokenFor(Identity{UserID: uuid.New()}, -time.Minute)
+	require.NoError(t, err)
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, &Claims{UserID: uuid.New()}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "short", token: "B"},
+		{name: "scheme only", token: "Bearer "},
+		{name: "garbage", token: "Bearer not-a-jwt"},
+		{name: "three dots", token: "a.b.c"},
+		{name: "expired", token: expired},
+		{name: "alg none", token: none},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NotPanics(t, func() {
+				_, err := j.ParseToken(test.token)
+				assert.Error(t, err)
+			})
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edFile := writePEM(t, "ed.pem", "PRIVATE KEY", der)
+
+	oldKey := config.JWTKey{ID: "old", Algorithm: "HS256", Secret: "old-secret"}
+	newKey := config.JWTKey{ID: "new", Algorithm: "EdDSA", PrivateKeyFile: edFile}
+
+	before := newJWT(t, &config.Config{JWTKeys: []config.JWTKey{oldKey}})
+	during := newJWT(t, &config.Config{JWTKeys: []config.JWTKey{newKey, oldKey}})
+	after := newJWT(t, &config.Config{JWTKeys: []config.JWTKey{newKey}})
+
+	userID := uuid.New()
+	oldToken, err := before.GenerateTokenFor(Identity{UserID: userID}, time.Hour)
+	require.NoError(t, err)
+	newToken, err := during.GenerateTokenFor(Identity{UserID: userID}, time.Hour)
+	require.NoError(t, err)
+
+	// пока старый ключ в списке, старые токены принимаются
+	claims, err := during.ParseToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+
+	claims, err = after.ParseToken(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+
+	_, err = after.ParseToken(oldToken)
+	assert.Error(t, err)
+}
+
+func TestRS256VerifyOnly(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privFile := writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	pubFile := writePEM(t, "rsa.pub", "PUBLIC KEY", pubDER)
+
+	signer := newJWT(t, &config.Config{JWTKeys: []config.JWTKey{{ID: "rsa", Algorithm: "RS256", PrivateKeyFile: privFile}}})
+	token, err := signer.GenerateTokenFor(Identity{UserID: uuid.New()}, time.Hour)
+	require.NoError(t, err)
+
+	// ключ выведен из оборота: подписывает новый, старый только проверяет
+	verifier := newJWT(t, &config.Config{JWTKeys: []config.JWTKey{
+		{ID: "fresh", Algorithm: "HS256", Secret: "fresh"},
+		{ID: "rsa", Algorithm: "RS256", PublicKeyFile: pubFile},
+	}})
+	_, err = verifier.ParseToken(token)
+	assert.NoError(t, err)
+
+	_, err = New(&config.Config{JWTKeys: []config.JWTKey{{ID: "rsa", Algorithm: "RS256", PublicKeyFile: pubFile}}})
+	assert.ErrorContains(t, err, "has no private key")
+}
+
+func TestRandomKeyOnlyOnRequest(t *testing.T) {
+	_, err := New(&config.Config{})
+	assert.ErrorContains(t, err, "no jwt_secret or jwt_keys configured")
+
+	// случайный ключ у каждого процесса свой
+	first, second := newJWT(t, &config.Config{JWTRandomKey: true}), newJWT(t, &config.Config{JWTRandomKey: true})
+	token, err := first.GenerateTokenFor(Identity{UserID: uuid.New()}, time.Minute)
+	require.NoError(t, err)
+	_, err = first.ParseToken(token)
+	assert.NoError(t, err)
+	_, err = second.ParseToken(token)
+	assert.Error(t, err)
+}
+
+func TestLegacyTokenWithoutKid(t *testing.T) {
+	userID := uuid.New()
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		UserID:           userID,
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	j := newJWT(t, &config.Config{JWTSecret: "secret"})
+	claims, err := j.ParseToken("Bearer " + legacy)
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+}
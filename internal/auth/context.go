@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+// Identity — пользователь запроса, его кладёт middlewares.Auth
+type Identity struct {
+	UserID uuid.UUID
+	// Registered — учётная запись, а не анонимная личность из куки
+	Registered bool
+	Role       string
+}
+
+func (id Identity) IsAdmin() bool {
+	return id.Registered && id.Role == RoleAdmin
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext возвращает false, если пользователя в запросе нет
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	if !ok || id.UserID == uuid.Nil {
+		return Identity{}, false
+	}
+	return id, true
+}
+
+func UserIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := IdentityFromContext(ctx)
+	return id.UserID, ok
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"os"
+)
+
+// DefaultKeyID — kid ключа из jwt_secret. Им же проверяются токены без kid,
+// выданные до появления ротации ключей
+const DefaultKeyID = "default"
+
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	// sign пустой у ключей, которые только проверяют подпись
+	sign   any
+	verify any
+}
+
+type keySet struct {
+	signing *signingKey
+	byID    map[string]*signingKey
+	methods []string
+}
+
+func loadKeys(cfg *config.Config) (*keySet, error) {
+	ks := &keySet{byID: map[string]*signingKey{}}
+	add := func(k *signingKey) {
+		ks.byID[k.id] = k
+		for _, m := range ks.methods {
+			if m == k.method.Alg() {
+				return
+			}
+		}
+		ks.methods = append(ks.methods, k.method.Alg())
+	}
+
+	secret := cfg.JWTSecret
+	if secret == "" && len(cfg.JWTKeys) == 0 {
+		if !cfg.JWTRandomKey {
+			return nil, errors.New("no jwt_secret or jwt_keys configured")
+		}
+		logger.Logger.Warn("jwt_random_key is set, using a random key; sessions will not survive a restart")
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = string(b)
+	}
+	if secret != "" {
+		add(&signingKey{id: DefaultKeyID, method: jwt.SigningMethodHS256, sign: []byte(secret), verify: []byte(secret)})
+	}
+
+	for _, kc := range cfg.JWTKeys {
+		k, err := parseKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kc.ID, err)
+		}
+		add(k)
+	}
+
+	signID := cfg.JWTSigningKey
+	if signID == "" {
+		signID = DefaultKeyID
+		if len(cfg.JWTKeys) > 0 {
+			signID = cfg.JWTKeys[0].ID
+		}
+	}
+	ks.signing = ks.byID[signID]
+	if ks.signing == nil || ks.signing.sign == nil {
+		return nil, fmt.Errorf("jwt signing key %q has no private key", signID)
+	}
+	return ks, nil
+}
+
+func parseKey(kc config.JWTKey) (*signingKey, error) {
+	k := &signingKey{id: kc.ID}
+	switch kc.Algorithm {
+	case "HS256":
+		k.method = jwt.SigningMethodHS256
+		k.sign, k.verify = []byte(kc.Secret), []byte(kc.Secret)
+		return k, nil
+	case "RS256":
+		k.method = jwt.SigningMethodRS256
+	case "EdDSA":
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Algorithm)
+	}
+
+	if kc.PrivateKeyFile != "" {
+		data, err := os.ReadFile(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		var priv crypto.Signer
+		if kc.Algorithm == "RS256" {
+			priv, err = jwt.ParseRSAPrivateKeyFromPEM(data)
+		} else {
+			var p crypto.PrivateKey
+			p, err = jwt.ParseEdPrivateKeyFromPEM(data)
+			if err == nil {
+				priv = p.(ed25519.PrivateKey)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		k.sign, k.verify = priv, priv.Public()
+	}
+	if kc.PublicKeyFile != "" {
+		data, err := os.ReadFile(kc.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if kc.Algorithm == "RS256" {
+			var pub *rsa.PublicKey
+			pub, err = jwt.ParseRSAPublicKeyFromPEM(data)
+			k.verify = pub
+		} else {
+			k.verify, err = jwt.ParseEdPublicKeyFromPEM(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if k.verify == nil {
+		return nil, errors.New("no key material")
+	}
+	return k, nil
+}
+
+// keyFunc выбирает ключ проверки по kid и не даёт подменить алгоритм
+func (ks *keySet) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		kid = DefaultKeyID
+	}
+	k, ok := ks.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected alg %q for kid %q", t.Method.Alg(), kid)
+	}
+	return k.verify, nil
+}
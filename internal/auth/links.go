@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// LinkAccessTTL — сколько действует доступ к защищённой паролем ссылке после ввода пароля
+const LinkAccessTTL = 15 * time.Minute
+
+// linkAudience отличает токен доступа к ссылке от токенов пользователей
+const linkAudience = "link"
+
+// GenerateLinkToken подписывает доступ к ссылке с идентификатором linkID
+func (h *JWT) GenerateLinkToken(linkID string, ttl time.Duration) (string, error) {
+	return h.sign(&jwt.RegisteredClaims{
+		Subject:   linkID,
+		Audience:  jwt.ClaimStrings{linkAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+	})
+}
+
+// CheckLinkToken проверяет, что токен выдан для ссылки linkID и ещё не истёк
+func (h *JWT) CheckLinkToken(token, linkID string) bool {
+	t, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, h.keys.keyFunc,
+		jwt.WithValidMethods(h.keys.methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(linkAudience),
+		jwt.WithSubject(linkID),
+	)
+	return err == nil && t.Valid
+}
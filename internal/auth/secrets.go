@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const (
+	// APIKeyPrefix помогает отличить API-ключ от JWT и найти утёкший ключ в логах
+	APIKeyPrefix = "sk_"
+	// APIKeyScheme — схема заголовка Authorization для API-ключей
+	APIKeyScheme = "ApiKey "
+)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewRefreshToken возвращает токен для клиента и его хэш для хранилища
+func NewRefreshToken() (token, hash string, err error) {
+	token, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashSecret(token), nil
+}
+
+// NewAPIKey возвращает ключ для клиента и его хэш для хранилища
+func NewAPIKey() (key, hash string, err error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + token
+	return key, HashSecret(key), nil
+}
+
+// HashSecret — sha256 от случайных секретов. Для них bcrypt не нужен:
+// энтропии достаточно, а искать по хэшу нужно быстро
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyFromHeader достаёт ключ из "Authorization: ApiKey sk_..."
+func APIKeyFromHeader(header string) (string, bool) {
+	if len(header) <= len(APIKeyScheme) || !strings.EqualFold(header[:len(APIKeyScheme)], APIKeyScheme) {
+		return "", false
+	}
+	key := strings.TrimSpace(header[len(APIKeyScheme):])
+	return key, strings.HasPrefix(key, APIKeyPrefix)
+}
@@ -1,59 +1,366 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Порядок применения настроек (каждый следующий источник перекрывает предыдущий):
+//  1. значения по умолчанию;
+//  2. файл конфигурации (-c/-config или CONFIG), json или yaml;
+//  3. переменные окружения;
+//  4. явно указанные флаги командной строки.
+
+const (
+	defaultServerAddr = "localhost:8080"
+	defaultResultAddr = "http://localhost:8080"
+	defaultLogLevel   = "info"
+
+	defaultDBConnectRetries = 10
+	defaultDBConnectBackoff = Duration(500 * time.Millisecond)
+	defaultDBMaxConns       = 20
+	defaultDBMinConns       = 2
+	defaultDBReadTimeout    = Duration(3 * time.Second)
+	defaultDBWriteTimeout   = Duration(5 * time.Second)
+	defaultDBBatchTimeout   = Duration(30 * time.Second)
+
+	defaultMaxRequestBytes        = 1 << 20
+	defaultMaxDecodedRequestBytes = 8 << 20
+	defaultMaxDecompressionRatio  = 100
+	defaultReadTimeout            = Duration(10 * time.Second)
+	defaultReadHeaderTimeout      = Duration(5 * time.Second)
+	defaultWriteTimeout           = Duration(30 * time.Second)
+	defaultIdleTimeout            = Duration(2 * time.Minute)
+	defaultHandlerTimeout         = Duration(10 * time.Second)
+	defaultBatchHandlerTimeout    = Duration(25 * time.Second)
+
+	defaultOpenAPIValidation = "off"
 )
 
 type Config struct {
-	ResultAddr      string
-	ServerAddr      string
-	FileStoragePath string
-	DatabaseDSN     string
-	JWTSecret       string
+	ResultAddr      string `json:"base_url" yaml:"base_url"`
+	ServerAddr      string `json:"server_address" yaml:"server_address"`
+	FileStoragePath string `json:"file_storage_path" yaml:"file_storage_path"`
+	DatabaseDSN     string `json:"database_dsn" yaml:"database_dsn"`
+	JWTSecret       string `json:"jwt_secret" yaml:"jwt_secret"`
+	LogLevel        string `json:"log_level" yaml:"log_level"`
+
+	// AutoMigrate применяет миграции при старте сервера, иначе схемой управляет
+	// shortener migrate. Подключение к базе при старте повторяется DBConnectRetries раз
+	// с экспоненциально растущей задержкой от DBConnectBackoff. С DegradedStart сервер
+	// стартует сразу и подключается в фоне без ограничения попыток, а /readyz до этого отвечает 503
+	AutoMigrate      bool     `json:"auto_migrate" yaml:"auto_migrate"`
+	DBConnectRetries int      `json:"db_connect_retries" yaml:"db_connect_retries"`
+	DBConnectBackoff Duration `json:"db_connect_backoff" yaml:"db_connect_backoff"`
+	DegradedStart    bool     `json:"degraded_start" yaml:"degraded_start"`
+
+	// Пул соединений pgx, нулевые значения — настройки pgx по умолчанию
+	DBMaxConns          int      `json:"db_max_conns" yaml:"db_max_conns"`
+	DBMinConns          int      `json:"db_min_conns" yaml:"db_min_conns"`
+	DBMaxConnLifetime   Duration `json:"db_max_conn_lifetime" yaml:"db_max_conn_lifetime"`
+	DBMaxConnIdleTime   Duration `json:"db_max_conn_idle_time" yaml:"db_max_conn_idle_time"`
+	DBHealthCheckPeriod Duration `json:"db_health_check_period" yaml:"db_health_check_period"`
+	// DBReplicaDSNs — реплики только для чтения, на них по очереди уходят
+	// GetLink (все редиректы) и GetUserURLs. Если реплика не ответила, запрос повторяется на основной базе
+	DBReplicaDSNs []string `json:"db_replica_dsns" yaml:"db_replica_dsns"`
+	// Таймауты запросов к базе: чтение, запись одной ссылки и пакетные операции.
+	// 0 отключает таймаут
+	DBReadTimeout  Duration `json:"db_read_timeout" yaml:"db_read_timeout"`
+	DBWriteTimeout Duration `json:"db_write_timeout" yaml:"db_write_timeout"`
+	DBBatchTimeout Duration `json:"db_batch_timeout" yaml:"db_batch_timeout"`
+
+	// Ограничения тела запроса: MaxRequestBytes — как пришло по сети,
+	// MaxDecodedRequestBytes — после снятия Content-Encoding. Декодирование
+	// прерывается и раньше, если тело разжимается сильнее MaxDecompressionRatio раз.
+	// 0 отключает ограничение, ответ на превышение — 413
+	MaxRequestBytes        int `json:"max_request_bytes" yaml:"max_request_bytes"`
+	MaxDecodedRequestBytes int `json:"max_decoded_request_bytes" yaml:"max_decoded_request_bytes"`
+	MaxDecompressionRatio  int `json:"max_decompression_ratio" yaml:"max_decompression_ratio"`
+
+	// Таймауты http.Server и обработчиков. Обработчику, не уложившемуся
+	// в HandlerTimeout (пакетное создание ссылок — в BatchHandlerTimeout), отвечаем 503.
+	// 0 отключает таймаут
+	ReadTimeout         Duration `json:"read_timeout" yaml:"read_timeout"`
+	ReadHeaderTimeout   Duration `json:"read_header_timeout" yaml:"read_header_timeout"`
+	WriteTimeout        Duration `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout         Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	HandlerTimeout      Duration `json:"handler_timeout" yaml:"handler_timeout"`
+	BatchHandlerTimeout Duration `json:"batch_handler_timeout" yaml:"batch_handler_timeout"`
+
+	// OpenAPIValidation сверяет запросы и ответы со спецификацией /openapi.json:
+	// off, requests (запрос не по спецификации получает 400), responses
+	// (ответ не по спецификации заменяется на 500, для тестов и стендов) или strict — и то и другое
+	OpenAPIValidation string `json:"openapi_validation" yaml:"openapi_validation"`
+
+	// JWTKeys — ключи подписи с kid, чтобы их можно было менять, не разлогинивая всех.
+	// Токены подписывает ключ JWTSigningKey (по умолчанию первый из списка),
+	// остальные только проверяют выданные раньше
+	JWTKeys       []JWTKey `json:"jwt_keys" yaml:"jwt_keys"`
+	JWTSigningKey string   `json:"jwt_signing_key" yaml:"jwt_signing_key"`
+	// JWTRandomKey разрешает стартовать без ключей со случайным ключом на процесс —
+	// только для разработки: токены не переживают рестарт и не подходят другим репликам
+	JWTRandomKey bool `json:"jwt_random_key" yaml:"jwt_random_key"`
+
+	// HTTPS: сертификат и ключ читаются из файлов и перечитываются при изменении.
+	// TLSSelfSigned генерирует самоподписанный сертификат для разработки
+	EnableHTTPS      bool   `json:"enable_https" yaml:"enable_https"`
+	TLSCertFile      string `json:"tls_cert_file" yaml:"tls_cert_file"`
+	TLSKeyFile       string `json:"tls_key_file" yaml:"tls_key_file"`
+	TLSSelfSigned    bool   `json:"tls_self_signed" yaml:"tls_self_signed"`
+	HTTPRedirectAddr string `json:"http_redirect_address" yaml:"http_redirect_address"`
+
+	// GRPCAddr — адрес gRPC-сервера, пустое значение его отключает
+	GRPCAddr string `json:"grpc_address" yaml:"grpc_address"`
+
+	// AdminLogins — логины, которые получают роль admin в токене и доступ к /api/admin
+	AdminLogins []string `json:"admin_logins" yaml:"admin_logins"`
+
+	// Журнал аудита: файл с цепочкой хэшей, таблица audit_events в DatabaseDSN
+	// и вебхук. Можно включить несколько сразу, без них события идут в лог
+	AuditFile       string `json:"audit_file" yaml:"audit_file"`
+	AuditPostgres   bool   `json:"audit_postgres" yaml:"audit_postgres"`
+	AuditWebhookURL string `json:"audit_webhook_url" yaml:"audit_webhook_url"`
+
+	// ComingSoonFile — html/template страницы для ссылок, окно которых ещё не началось.
+	// В шаблон передаётся .NotBefore; пустое значение — встроенная страница
+	ComingSoonFile string `json:"coming_soon_file" yaml:"coming_soon_file"`
+
+	// GeoIPFile — база MaxMind (GeoLite2-Country) для правил маршрутизации по стране
+	GeoIPFile string `json:"geoip_file" yaml:"geoip_file"`
+
+	ConfigPath string `json:"-" yaml:"-"`
+
+	// live хранит настройки, которые можно перечитать без рестарта.
+	// Если конфиг собран вручную (например, в тестах), используются поля выше
+	live *atomic.Pointer[Live]
+}
+
+// JWTKey — ключ подписи токенов. Для HS256 нужен Secret, для RS256 и EdDSA — PEM-файлы:
+// закрытый ключ для подписи или только открытый, если ключ выведен из оборота
+type JWTKey struct {
+	ID             string `json:"kid" yaml:"kid"`
+	Algorithm      string `json:"alg" yaml:"alg"`
+	Secret         string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty" yaml:"private_key_file,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty" yaml:"public_key_file,omitempty"`
+}
+
+// Live — подмножество настроек, которое применяется на лету (см. Reloader)
+type Live struct {
+	ResultAddr string
+	LogLevel   string
+}
+
+// LookupEnv совпадает по сигнатуре с os.LookupEnv
+type LookupEnv func(key string) (string, bool)
+
+func Default() *Config {
+	return &Config{
+		ServerAddr:       defaultServerAddr,
+		ResultAddr:       defaultResultAddr,
+		LogLevel:         defaultLogLevel,
+		AutoMigrate:      true,
+		DBConnectRetries: defaultDBConnectRetries,
+		DBConnectBackoff: defaultDBConnectBackoff,
+		DBMaxConns:       defaultDBMaxConns,
+		DBMinConns:       defaultDBMinConns,
+		DBReadTimeout:    defaultDBReadTimeout,
+		DBWriteTimeout:   defaultDBWriteTimeout,
+		DBBatchTimeout:   defaultDBBatchTimeout,
+
+		MaxRequestBytes:        defaultMaxRequestBytes,
+		MaxDecodedRequestBytes: defaultMaxDecodedRequestBytes,
+		MaxDecompressionRatio:  defaultMaxDecompressionRatio,
+		ReadTimeout:            defaultReadTimeout,
+		ReadHeaderTimeout:      defaultReadHeaderTimeout,
+		WriteTimeout:           defaultWriteTimeout,
+		IdleTimeout:            defaultIdleTimeout,
+		HandlerTimeout:         defaultHandlerTimeout,
+		BatchHandlerTimeout:    defaultBatchHandlerTimeout,
+		OpenAPIValidation:      defaultOpenAPIValidation,
+	}
+}
+
+func (c *Config) liveFromFields() *Live {
+	return &Live{
+		ResultAddr: c.ResultAddr,
+		LogLevel:   c.LogLevel,
+	}
+}
+
+// Live возвращает согласованный снимок перечитываемых настроек
+func (c *Config) Live() Live {
+	if c.live == nil {
+		return *c.liveFromFields()
+	}
+	return *c.live.Load()
 }
 
-func (c *Config) UpdateByOptions(o *ServerConfigFlags) {
-	flag.Parse()
-	c.ServerAddr = o.ServerAddr
-	c.ResultAddr = strings.Trim(o.ResultAddr, "/")
-	c.FileStoragePath = o.FileStoragePath
-	c.DatabaseDSN = o.DatabaseDSN
+// BaseURL — адрес, который подставляется перед коротким кодом
+func (c *Config) BaseURL() string {
+	return c.Live().ResultAddr
 }
 
-func (c *Config) PopulateConfigFromEnv() {
+func (c *Config) PopulateConfigFromEnv(lookup LookupEnv) error {
+	envString(lookup, "SERVER_ADDRESS", &c.ServerAddr)
+	envString(lookup, "BASE_URL", &c.ResultAddr)
+	envString(lookup, "FILE_STORAGE_PATH", &c.FileStoragePath)
+	envString(lookup, "DATABASE_DSN", &c.DatabaseDSN)
+	envString(lookup, "JWT_SECRET", &c.JWTSecret)
+	envString(lookup, "JWT_SIGNING_KEY", &c.JWTSigningKey)
+	envString(lookup, "LOG_LEVEL", &c.LogLevel)
+	envString(lookup, "TLS_CERT_FILE", &c.TLSCertFile)
+	envString(lookup, "TLS_KEY_FILE", &c.TLSKeyFile)
+	envString(lookup, "HTTP_REDIRECT_ADDRESS", &c.HTTPRedirectAddr)
+	envString(lookup, "GRPC_ADDRESS", &c.GRPCAddr)
+	envList(lookup, "ADMIN_LOGINS", &c.AdminLogins)
+	envList(lookup, "DB_REPLICA_DSNS", &c.DBReplicaDSNs)
+	envString(lookup, "AUDIT_FILE", &c.AuditFile)
+	envString(lookup, "AUDIT_WEBHOOK_URL", &c.AuditWebhookURL)
+	envString(lookup, "COMING_SOON_FILE", &c.ComingSoonFile)
+	envString(lookup, "GEOIP_FILE", &c.GeoIPFile)
+	envString(lookup, "OPENAPI_VALIDATION", &c.OpenAPIValidation)
+	return errors.Join(
+		envBool(lookup, "ENABLE_HTTPS", &c.EnableHTTPS),
+		envBool(lookup, "JWT_RANDOM_KEY", &c.JWTRandomKey),
+		envBool(lookup, "TLS_SELF_SIGNED", &c.TLSSelfSigned),
+		envBool(lookup, "AUDIT_POSTGRES", &c.AuditPostgres),
+		envBool(lookup, "AUTO_MIGRATE", &c.AutoMigrate),
+		envInt(lookup, "DB_CONNECT_RETRIES", &c.DBConnectRetries),
+		envDuration(lookup, "DB_CONNECT_BACKOFF", &c.DBConnectBackoff),
+		envBool(lookup, "DEGRADED_START", &c.DegradedStart),
+		envInt(lookup, "DB_MAX_CONNS", &c.DBMaxConns),
+		envInt(lookup, "DB_MIN_CONNS", &c.DBMinConns),
+		envDuration(lookup, "DB_MAX_CONN_LIFETIME", &c.DBMaxConnLifetime),
+		envDuration(lookup, "DB_MAX_CONN_IDLE_TIME", &c.DBMaxConnIdleTime),
+		envDuration(lookup, "DB_HEALTH_CHECK_PERIOD", &c.DBHealthCheckPeriod),
+		envDuration(lookup, "DB_READ_TIMEOUT", &c.DBReadTimeout),
+		envDuration(lookup, "DB_WRITE_TIMEOUT", &c.DBWriteTimeout),
+		envDuration(lookup, "DB_BATCH_TIMEOUT", &c.DBBatchTimeout),
+		envInt(lookup, "MAX_REQUEST_BYTES", &c.MaxRequestBytes),
+		envInt(lookup, "MAX_DECODED_REQUEST_BYTES", &c.MaxDecodedRequestBytes),
+		envInt(lookup, "MAX_DECOMPRESSION_RATIO", &c.MaxDecompressionRatio),
+		envDuration(lookup, "READ_TIMEOUT", &c.ReadTimeout),
+		envDuration(lookup, "READ_HEADER_TIMEOUT", &c.ReadHeaderTimeout),
+		envDuration(lookup, "WRITE_TIMEOUT", &c.WriteTimeout),
+		envDuration(lookup, "IDLE_TIMEOUT", &c.IdleTimeout),
+		envDuration(lookup, "HANDLER_TIMEOUT", &c.HandlerTimeout),
+		envDuration(lookup, "BATCH_HANDLER_TIMEOUT", &c.BatchHandlerTimeout),
+	)
+}
+
+func envString(lookup LookupEnv, key string, dst *string) {
+	if v, ok := lookup(key); ok && v != "" {
+		*dst = v
+	}
+}
 
-	sa := os.Getenv("SERVER_ADDRESS")
-	if sa != "" {
-		c.ServerAddr = sa
+// envList читает список через запятую
+func envList(lookup LookupEnv, key string, dst *[]string) {
+	if v, ok := lookup(key); ok && v != "" {
+		*dst = splitList(v)
 	}
-	ra := os.Getenv("BASE_URL")
-	if ra != "" {
-		c.ResultAddr = ra
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
-	fsp := os.Getenv("FILE_STORAGE_PATH")
-	if fsp != "" {
-		c.FileStoragePath = fsp
+	return list
+}
+
+// ValidatesOpenAPI сообщает, какую часть обмена сверять со спецификацией
+func (c *Config) ValidatesOpenAPI() (requests, responses bool) {
+	switch c.OpenAPIValidation {
+	case "requests":
+		return true, false
+	case "responses":
+		return false, true
+	case "strict":
+		return true, true
 	}
-	ddsn := os.Getenv("DATABASE_DSN")
-	if ddsn != "" {
-		c.DatabaseDSN = ddsn
+	return false, false
+}
+
+// IsAdmin сообщает, что пользователю с этим логином положена роль admin
+func (c *Config) IsAdmin(login string) bool {
+	return slices.Contains(c.AdminLogins, login)
+}
+
+func envBool(lookup LookupEnv, key string, dst *bool) error {
+	v, ok := lookup(key)
+	if !ok || v == "" {
+		return nil
 	}
-	jwt := os.Getenv("JWT_SECRET")
-	if jwt != "" {
-		c.JWTSecret = jwt
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: expected a boolean", key, v)
 	}
+	*dst = b
+	return nil
 }
 
-func New() *Config {
-	c := &Config{
-		ServerAddr:      ":8080",
-		ResultAddr:      "http://localhost:8080",
-		FileStoragePath: "",
-		JWTSecret:       "secret",
+// Load собирает конфиг из файла, окружения и аргументов командной строки.
+// Глобальный flag.CommandLine не используется.
+func Load(args []string, lookup LookupEnv) (*Config, error) {
+	c, _, err := LoadWithArgs(args, lookup)
+	return c, err
+}
+
+// LoadWithArgs работает как Load и возвращает аргументы, оставшиеся после флагов
+// (например, подкоманду shortener migrate)
+func LoadWithArgs(args []string, lookup LookupEnv) (*Config, []string, error) {
+	scf := &ServerConfigFlags{}
+	fs := newFlagSet(scf)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	c := Default()
+
+	c.ConfigPath = scf.ConfigPath
+	if c.ConfigPath == "" {
+		c.ConfigPath, _ = lookup("CONFIG")
+	}
+	if c.ConfigPath != "" {
+		if err := c.loadFile(c.ConfigPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := c.PopulateConfigFromEnv(lookup); err != nil {
+		return nil, nil, err
 	}
-	c.UpdateByOptions(Flags)
-	c.PopulateConfigFromEnv()
-	return c
+	scf.apply(fs, c)
+	c.ResultAddr = strings.TrimRight(c.ResultAddr, "/")
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	c.live = &atomic.Pointer[Live]{}
+	c.live.Store(c.liveFromFields())
+	return c, fs.Args(), nil
+}
+
+func New() (*Config, error) {
+	c, err := Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return c, nil
+}
+
+// IsHelp сообщает, что пользователь запросил -h и продолжать запуск не нужно
+func IsHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
 }
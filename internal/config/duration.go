@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration — time.Duration, который в json, yaml, окружении и флагах
+// записывается строкой вида "500ms" или "1m30s"
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+// Set реализует flag.Value
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func envDuration(lookup LookupEnv, key string, dst *Duration) error {
+	v, ok := lookup(key)
+	if !ok || v == "" {
+		return nil
+	}
+	if err := dst.Set(v); err != nil {
+		return fmt.Errorf("invalid %s %q: expected a duration like 500ms or 2s", key, v)
+	}
+	return nil
+}
+
+func envInt(lookup LookupEnv, key string, dst *int) error {
+	v, ok := lookup(key)
+	if !ok || v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: expected an integer", key, v)
+	}
+	*dst = n
+	return nil
+}
@@ -3,26 +3,197 @@ package config
 import "flag"
 
 type ServerConfigFlags struct {
+	ConfigPath      string
 	ServerAddr      string
 	ResultAddr      string
 	FileStoragePath string
 	DatabaseDSN     string
 	JWTSecret       string
-}
+	JWTRandomKey    bool
+	LogLevel        string
+
+	AutoMigrate         bool
+	DBConnectRetries    int
+	DBConnectBackoff    Duration
+	DegradedStart       bool
+	DBMaxConns          int
+	DBMinConns          int
+	DBMaxConnLifetime   Duration
+	DBMaxConnIdleTime   Duration
+	DBHealthCheckPeriod Duration
+	DBReplicaDSNs       string
+	DBReadTimeout       Duration
+	DBWriteTimeout      Duration
+	DBBatchTimeout      Duration
 
-var Flags = NewServerConfigFlags()
+	MaxRequestBytes        int
+	MaxDecodedRequestBytes int
+	MaxDecompressionRatio  int
+	ReadTimeout            Duration
+	ReadHeaderTimeout      Duration
+	WriteTimeout           Duration
+	IdleTimeout            Duration
+	HandlerTimeout         Duration
+	BatchHandlerTimeout    Duration
+	OpenAPIValidation      string
 
-func (scf *ServerConfigFlags) initFlags() {
-	flag.StringVar(&scf.ServerAddr, "a", "localhost:8080", "server address")
-	flag.StringVar(&scf.ResultAddr, "b", "http://localhost:8080", "result base url")
-	flag.StringVar(&scf.FileStoragePath, "f", "", "file storage path")
-	flag.StringVar(&scf.DatabaseDSN, "d", "", "postgres connection string")
-	flag.StringVar(&scf.JWTSecret, "j", "secret", "jwt secret")
+	EnableHTTPS      bool
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSSelfSigned    bool
+	HTTPRedirectAddr string
+	GRPCAddr         string
+	AdminLogins      string
+	AuditFile        string
+	AuditPostgres    bool
+	AuditWebhookURL  string
+	ComingSoonFile   string
+	GeoIPFile        string
 }
 
-func NewServerConfigFlags() *ServerConfigFlags {
-	scf := &ServerConfigFlags{}
-	scf.initFlags()
+// newFlagSet регистрирует флаги в собственном FlagSet, а не в глобальном,
+// чтобы конфиг можно было собирать в тестах
+func newFlagSet(scf *ServerConfigFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("shortener", flag.ContinueOnError)
+	fs.StringVar(&scf.ConfigPath, "c", "", "config file path (json or yaml)")
+	fs.StringVar(&scf.ConfigPath, "config", "", "config file path (json or yaml)")
+	fs.StringVar(&scf.ServerAddr, "a", defaultServerAddr, "server address")
+	fs.StringVar(&scf.ResultAddr, "b", defaultResultAddr, "result base url")
+	fs.StringVar(&scf.FileStoragePath, "f", "", "file storage path")
+	fs.StringVar(&scf.DatabaseDSN, "d", "", "postgres connection string")
+	fs.BoolVar(&scf.AutoMigrate, "auto-migrate", true, "apply database migrations at startup")
+	fs.IntVar(&scf.DBConnectRetries, "db-connect-retries", defaultDBConnectRetries, "database connection retries at startup")
+	scf.DBConnectBackoff = defaultDBConnectBackoff
+	fs.Var(&scf.DBConnectBackoff, "db-connect-backoff", "initial delay between database connection retries, doubles each time")
+	fs.BoolVar(&scf.DegradedStart, "degraded-start", false, "start without the database and connect in the background")
+	fs.IntVar(&scf.DBMaxConns, "db-max-conns", defaultDBMaxConns, "maximum database pool size")
+	fs.IntVar(&scf.DBMinConns, "db-min-conns", defaultDBMinConns, "minimum number of idle database connections")
+	fs.Var(&scf.DBMaxConnLifetime, "db-max-conn-lifetime", "close database connections older than this (0 is the pgx default)")
+	fs.Var(&scf.DBMaxConnIdleTime, "db-max-conn-idle-time", "close database connections idle for longer than this (0 is the pgx default)")
+	fs.Var(&scf.DBHealthCheckPeriod, "db-health-check-period", "how often idle database connections are checked (0 is the pgx default)")
+	fs.StringVar(&scf.DBReplicaDSNs, "db-replicas", "", "comma-separated read replica connection strings")
+	scf.DBReadTimeout, scf.DBWriteTimeout, scf.DBBatchTimeout = defaultDBReadTimeout, defaultDBWriteTimeout, defaultDBBatchTimeout
+	fs.Var(&scf.DBReadTimeout, "db-read-timeout", "database read timeout (0 disables it)")
+	fs.Var(&scf.DBWriteTimeout, "db-write-timeout", "database write timeout (0 disables it)")
+	fs.Var(&scf.DBBatchTimeout, "db-batch-timeout", "database batch operation timeout (0 disables it)")
+	fs.IntVar(&scf.MaxRequestBytes, "max-request-bytes", defaultMaxRequestBytes, "maximum request body size as sent (0 disables the limit)")
+	fs.IntVar(&scf.MaxDecodedRequestBytes, "max-decoded-request-bytes", defaultMaxDecodedRequestBytes, "maximum request body size after decompression (0 disables the limit)")
+	fs.IntVar(&scf.MaxDecompressionRatio, "max-decompression-ratio", defaultMaxDecompressionRatio, "reject request bodies that decompress more than this many times (0 disables the check)")
+	scf.ReadTimeout, scf.ReadHeaderTimeout, scf.WriteTimeout, scf.IdleTimeout = defaultReadTimeout, defaultReadHeaderTimeout, defaultWriteTimeout, defaultIdleTimeout
+	scf.HandlerTimeout, scf.BatchHandlerTimeout = defaultHandlerTimeout, defaultBatchHandlerTimeout
+	fs.Var(&scf.ReadTimeout, "read-timeout", "time to read the whole request (0 disables it)")
+	fs.Var(&scf.ReadHeaderTimeout, "read-header-timeout", "time to read request headers (0 disables it)")
+	fs.Var(&scf.WriteTimeout, "write-timeout", "time to write the response (0 disables it)")
+	fs.Var(&scf.IdleTimeout, "idle-timeout", "keep-alive connection idle timeout (0 disables it)")
+	fs.Var(&scf.HandlerTimeout, "handler-timeout", "request handler timeout (0 disables it)")
+	fs.Var(&scf.BatchHandlerTimeout, "batch-handler-timeout", "batch shortening handler timeout (0 disables it)")
+	fs.StringVar(&scf.OpenAPIValidation, "openapi-validation", defaultOpenAPIValidation, "check requests and responses against the openapi spec (off, requests, responses, strict)")
+	fs.StringVar(&scf.JWTSecret, "j", "", "jwt hs256 secret")
+	fs.BoolVar(&scf.JWTRandomKey, "jwt-random-key", false, "sign tokens with a random per-process key if no jwt keys are set (development only)")
+	fs.StringVar(&scf.LogLevel, "l", defaultLogLevel, "log level (debug, info, warn, error)")
+	fs.BoolVar(&scf.EnableHTTPS, "s", false, "enable https")
+	fs.StringVar(&scf.TLSCertFile, "tls-cert", "", "tls certificate file")
+	fs.StringVar(&scf.TLSKeyFile, "tls-key", "", "tls private key file")
+	fs.BoolVar(&scf.TLSSelfSigned, "tls-self-signed", false, "generate a self-signed certificate if none is found")
+	fs.StringVar(&scf.HTTPRedirectAddr, "redirect-addr", "", "plain http address that redirects to https")
+	fs.StringVar(&scf.GRPCAddr, "g", "", "grpc server address (disabled if empty)")
+	fs.StringVar(&scf.AdminLogins, "admin-logins", "", "comma-separated logins with the admin role")
+	fs.StringVar(&scf.AuditFile, "audit-file", "", "audit log file (json lines with hash chain)")
+	fs.BoolVar(&scf.AuditPostgres, "audit-postgres", false, "write audit events to the database")
+	fs.StringVar(&scf.AuditWebhookURL, "audit-webhook", "", "url that receives audit events")
+	fs.StringVar(&scf.ComingSoonFile, "coming-soon-file", "", "html template shown before a link's activation window")
+	fs.StringVar(&scf.GeoIPFile, "geoip-file", "", "maxmind country database for routing rules")
+	return fs
+}
 
-	return scf
+// apply переносит в конфиг только те флаги, которые были явно указаны
+func (scf *ServerConfigFlags) apply(fs *flag.FlagSet, c *Config) {
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "a":
+			c.ServerAddr = scf.ServerAddr
+		case "b":
+			c.ResultAddr = scf.ResultAddr
+		case "f":
+			c.FileStoragePath = scf.FileStoragePath
+		case "d":
+			c.DatabaseDSN = scf.DatabaseDSN
+		case "auto-migrate":
+			c.AutoMigrate = scf.AutoMigrate
+		case "db-connect-retries":
+			c.DBConnectRetries = scf.DBConnectRetries
+		case "db-connect-backoff":
+			c.DBConnectBackoff = scf.DBConnectBackoff
+		case "degraded-start":
+			c.DegradedStart = scf.DegradedStart
+		case "db-max-conns":
+			c.DBMaxConns = scf.DBMaxConns
+		case "db-min-conns":
+			c.DBMinConns = scf.DBMinConns
+		case "db-max-conn-lifetime":
+			c.DBMaxConnLifetime = scf.DBMaxConnLifetime
+		case "db-max-conn-idle-time":
+			c.DBMaxConnIdleTime = scf.DBMaxConnIdleTime
+		case "db-health-check-period":
+			c.DBHealthCheckPeriod = scf.DBHealthCheckPeriod
+		case "db-replicas":
+			c.DBReplicaDSNs = splitList(scf.DBReplicaDSNs)
+		case "db-read-timeout":
+			c.DBReadTimeout = scf.DBReadTimeout
+		case "db-write-timeout":
+			c.DBWriteTimeout = scf.DBWriteTimeout
+		case "db-batch-timeout":
+			c.DBBatchTimeout = scf.DBBatchTimeout
+		case "max-request-bytes":
+			c.MaxRequestBytes = scf.MaxRequestBytes
+		case "max-decoded-request-bytes":
+			c.MaxDecodedRequestBytes = scf.MaxDecodedRequestBytes
+		case "max-decompression-ratio":
+			c.MaxDecompressionRatio = scf.MaxDecompressionRatio
+		case "read-timeout":
+			c.ReadTimeout = scf.ReadTimeout
+		case "read-header-timeout":
+			c.ReadHeaderTimeout = scf.ReadHeaderTimeout
+		case "write-timeout":
+			c.WriteTimeout = scf.WriteTimeout
+		case "idle-timeout":
+			c.IdleTimeout = scf.IdleTimeout
+		case "handler-timeout":
+			c.HandlerTimeout = scf.HandlerTimeout
+		case "batch-handler-timeout":
+			c.BatchHandlerTimeout = scf.BatchHandlerTimeout
+		case "openapi-validation":
+			c.OpenAPIValidation = scf.OpenAPIValidation
+		case "j":
+			c.JWTSecret = scf.JWTSecret
+		case "jwt-random-key":
+			c.JWTRandomKey = scf.JWTRandomKey
+		case "l":
+			c.LogLevel = scf.LogLevel
+		case "s":
+			c.EnableHTTPS = scf.EnableHTTPS
+		case "tls-cert":
+			c.TLSCertFile = scf.TLSCertFile
+		case "tls-key":
+			c.TLSKeyFile = scf.TLSKeyFile
+		case "tls-self-signed":
+			c.TLSSelfSigned = scf.TLSSelfSigned
+		case "redirect-addr":
+			c.HTTPRedirectAddr = scf.HTTPRedirectAddr
+		case "g":
+			c.GRPCAddr = scf.GRPCAddr
+		case "admin-logins":
+			c.AdminLogins = splitList(scf.AdminLogins)
+		case "audit-file":
+			c.AuditFile = scf.AuditFile
+		case "audit-postgres":
+			c.AuditPostgres = scf.AuditPostgres
+		case "audit-webhook":
+			c.AuditWebhookURL = scf.AuditWebhookURL
+		case "coming-soon-file":
+			c.ComingSoonFile = scf.ComingSoonFile
+		case "geoip-file":
+			c.GeoIPFile = scf.GeoIPFile
+		}
+	})
 }
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"context"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Reloader перечитывает конфиг по SIGHUP или при изменении файла конфигурации.
+// Применяются только настройки из Live, остальные требуют рестарта
+type Reloader struct {
+	mu      sync.Mutex
+	cfg     *Config
+	args    []string
+	lookup  LookupEnv
+	modTime time.Time
+}
+
+func NewReloader(cfg *Config, args []string, lookup LookupEnv) *Reloader {
+	r := &Reloader{
+		cfg:    cfg,
+		args:   args,
+		lookup: lookup,
+	}
+	r.modTime = r.fileModTime()
+	return r
+}
+
+// Reload собирает конфиг заново из тех же источников и атомарно подменяет Live.
+// При ошибке валидации старые значения остаются в силе
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := Load(r.args, r.lookup)
+	if err != nil {
+		logger.Logger.Errorw("config reload failed, keeping current config", "error", err)
+		return err
+	}
+
+	for _, field := range r.cfg.restartOnlyChanges(next) {
+		logger.Logger.Warnw("config change requires restart, ignored", "field", field)
+	}
+
+	prev := r.cfg.Live()
+	live := next.Live()
+	if prev == live {
+		logger.Logger.Info("config reloaded, nothing changed")
+		return nil
+	}
+	if err := logger.SetLevel(live.LogLevel); err != nil {
+		return err
+	}
+	r.cfg.live.Store(&live)
+	logger.Logger.Infow("config reloaded",
+		"base_url", live.ResultAddr,
+		"log_level", live.LogLevel,
+	)
+	return nil
+}
+
+// restartOnlyChanges возвращает поля вне Live, которые отличаются в next
+func (c *Config) restartOnlyChanges(next *Config) []string {
+	var fields []string
+	if c.ServerAddr != next.ServerAddr {
+		fields = append(fields, "server_address")
+	}
+	if !slices.Equal(c.AdminLogins, next.AdminLogins) {
+		fields = append(fields, "admin_logins")
+	}
+	if c.GRPCAddr != next.GRPCAddr {
+		fields = append(fields, "grpc_address")
+	}
+	if c.AuditFile != next.AuditFile || c.AuditPostgres != next.AuditPostgres || c.AuditWebhookURL != next.AuditWebhookURL {
+		fields = append(fields, "audit")
+	}
+	if c.ComingSoonFile != next.ComingSoonFile {
+		fields = append(fields, "coming_soon_file")
+	}
+	if c.GeoIPFile != next.GeoIPFile {
+		fields = append(fields, "geoip_file")
+	}
+	if c.FileStoragePath != next.FileStoragePath {
+		fields = append(fields, "file_storage_path")
+	}
+	if c.DatabaseDSN != next.DatabaseDSN {
+		fields = append(fields, "database_dsn")
+	}
+	if c.AutoMigrate != next.AutoMigrate {
+		fields = append(fields, "auto_migrate")
+	}
+	if c.DBConnectRetries != next.DBConnectRetries || c.DBConnectBackoff != next.DBConnectBackoff || c.DegradedStart != next.DegradedStart {
+		fields = append(fields, "db_connect")
+	}
+	if c.DBMaxConns != next.DBMaxConns || c.DBMinConns != next.DBMinConns || c.DBMaxConnLifetime != next.DBMaxConnLifetime ||
+		c.DBMaxConnIdleTime != next.DBMaxConnIdleTime || c.DBHealthCheckPeriod != next.DBHealthCheckPeriod {
+		fields = append(fields, "db_pool")
+	}
+	if !slices.Equal(c.DBReplicaDSNs, next.DBReplicaDSNs) {
+		fields = append(fields, "db_replica_dsns")
+	}
+	if c.DBReadTimeout != next.DBReadTimeout || c.DBWriteTimeout != next.DBWriteTimeout || c.DBBatchTimeout != next.DBBatchTimeout {
+		fields = append(fields, "db_timeouts")
+	}
+	if c.MaxRequestBytes != next.MaxRequestBytes || c.MaxDecodedRequestBytes != next.MaxDecodedRequestBytes ||
+		c.MaxDecompressionRatio != next.MaxDecompressionRatio {
+		fields = append(fields, "request_limits")
+	}
+	if c.ReadTimeout != next.ReadTimeout || c.ReadHeaderTimeout != next.ReadHeaderTimeout || c.WriteTimeout != next.WriteTimeout ||
+		c.IdleTimeout != next.IdleTimeout || c.HandlerTimeout != next.HandlerTimeout || c.BatchHandlerTimeout != next.BatchHandlerTimeout {
+		fields = append(fields, "http_timeouts")
+	}
+	if c.OpenAPIValidation != next.OpenAPIValidation {
+		fields = append(fields, "openapi_validation")
+	}
+	if c.JWTSecret != next.JWTSecret || c.JWTSigningKey != next.JWTSigningKey || c.JWTRandomKey != next.JWTRandomKey || !slices.Equal(c.JWTKeys, next.JWTKeys) {
+		fields = append(fields, "jwt_secret")
+	}
+	if c.EnableHTTPS != next.EnableHTTPS || c.TLSCertFile != next.TLSCertFile || c.TLSKeyFile != next.TLSKeyFile ||
+		c.TLSSelfSigned != next.TLSSelfSigned || c.HTTPRedirectAddr != next.HTTPRedirectAddr {
+		fields = append(fields, "tls")
+	}
+	return fields
+}
+
+func (r *Reloader) fileModTime() time.Time {
+	if r.cfg.ConfigPath == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(r.cfg.ConfigPath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Watch ждёт сигналов из hup и раз в interval проверяет время изменения файла конфигурации
+func (r *Reloader) Watch(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Logger.Info("SIGHUP received, reloading config")
+			_ = r.Reload()
+		case <-ticker.C:
+			mt := r.fileModTime()
+			if mt.Equal(r.modTime) {
+				continue
+			}
+			r.modTime = mt
+			logger.Logger.Infow("config file changed, reloading", "path", r.cfg.ConfigPath)
+			_ = r.Reload()
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap/zapcore"
+	"net"
+	urlLib "net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const redacted = "******"
+
+// Validate проверяет конфиг целиком и возвращает все найденные ошибки разом
+func (c *Config) Validate() error {
+	var errs []error
+
+	if err := validateAddr(c.ServerAddr); err != nil {
+		errs = append(errs, fmt.Errorf("invalid server_address %q: %w", c.ServerAddr, err))
+	}
+	if err := validateBaseURL(c.ResultAddr); err != nil {
+		errs = append(errs, fmt.Errorf("invalid base_url %q: %w", c.ResultAddr, err))
+	}
+	if c.DatabaseDSN != "" {
+		if _, err := pgconn.ParseConfig(c.DatabaseDSN); err != nil {
+			errs = append(errs, fmt.Errorf("invalid database_dsn: %w", err))
+		}
+	}
+	if c.DBConnectRetries < 0 {
+		errs = append(errs, errors.New("db_connect_retries must not be negative"))
+	}
+	if c.DBConnectBackoff <= 0 {
+		errs = append(errs, errors.New("db_connect_backoff must be positive"))
+	}
+	errs = append(errs, c.validateDBPool())
+	errs = append(errs, c.validateRequestLimits())
+	errs = append(errs, c.validateJWTKeys())
+	errs = append(errs, c.validateTLS())
+	if c.GRPCAddr != "" {
+		if err := validateAddr(c.GRPCAddr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid grpc_address %q: %w", c.GRPCAddr, err))
+		}
+	}
+	if c.AuditPostgres && c.DatabaseDSN == "" {
+		errs = append(errs, errors.New("audit_postgres requires database_dsn"))
+	}
+	if c.AuditWebhookURL != "" {
+		if u, err := urlLib.Parse(c.AuditWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid audit_webhook_url %q", c.AuditWebhookURL))
+		}
+	}
+	if !slices.Contains([]string{"off", "requests", "responses", "strict"}, c.OpenAPIValidation) {
+		errs = append(errs, fmt.Errorf("invalid openapi_validation %q: expected off, requests, responses or strict", c.OpenAPIValidation))
+	}
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log_level: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateDBPool() error {
+	var errs []error
+	if c.DBMaxConns < 0 || c.DBMinConns < 0 {
+		errs = append(errs, errors.New("db_max_conns and db_min_conns must not be negative"))
+	}
+	if c.DBMaxConns > 0 && c.DBMinConns > c.DBMaxConns {
+		errs = append(errs, fmt.Errorf("db_min_conns %d is greater than db_max_conns %d", c.DBMinConns, c.DBMaxConns))
+	}
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"db_max_conn_lifetime", c.DBMaxConnLifetime},
+		{"db_max_conn_idle_time", c.DBMaxConnIdleTime},
+		{"db_health_check_period", c.DBHealthCheckPeriod},
+		{"db_read_timeout", c.DBReadTimeout},
+		{"db_write_timeout", c.DBWriteTimeout},
+		{"db_batch_timeout", c.DBBatchTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+	if len(c.DBReplicaDSNs) > 0 && c.DatabaseDSN == "" {
+		errs = append(errs, errors.New("db_replica_dsns requires database_dsn"))
+	}
+	for i, dsn := range c.DBReplicaDSNs {
+		if _, err := pgconn.ParseConfig(dsn); err != nil {
+			errs = append(errs, fmt.Errorf("invalid db_replica_dsns[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateRequestLimits() error {
+	var errs []error
+	if c.MaxRequestBytes < 0 || c.MaxDecodedRequestBytes < 0 || c.MaxDecompressionRatio < 0 {
+		errs = append(errs, errors.New("max_request_bytes, max_decoded_request_bytes and max_decompression_ratio must not be negative"))
+	}
+	timeouts := []struct {
+		name  string
+		value Duration
+	}{
+		{"read_timeout", c.ReadTimeout},
+		{"read_header_timeout", c.ReadHeaderTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"handler_timeout", c.HandlerTimeout},
+		{"batch_handler_timeout", c.BatchHandlerTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", t.name))
+		}
+	}
+	// иначе соединение закроется раньше, чем клиент получит 503 по таймауту обработчика
+	if c.WriteTimeout > 0 {
+		for _, t := range timeouts[4:] {
+			if t.value == 0 || t.value >= c.WriteTimeout {
+				errs = append(errs, fmt.Errorf("%s %s must be shorter than write_timeout %s", t.name, t.value, c.WriteTimeout))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateTLS() error {
+	if !c.EnableHTTPS {
+		if c.HTTPRedirectAddr != "" {
+			return errors.New("http_redirect_address requires enable_https")
+		}
+		return nil
+	}
+	var errs []error
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be set together"))
+	}
+	if c.TLSCertFile == "" && !c.TLSSelfSigned {
+		errs = append(errs, errors.New("enable_https requires tls_cert_file and tls_key_file or tls_self_signed"))
+	}
+	if c.HTTPRedirectAddr != "" {
+		if err := validateAddr(c.HTTPRedirectAddr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid http_redirect_address %q: %w", c.HTTPRedirectAddr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateJWTKeys() error {
+	var errs []error
+	// общеизвестного секрета по умолчанию нет, а случайный ключ включается только явно
+	if c.JWTSecret == "" && len(c.JWTKeys) == 0 && !c.JWTRandomKey {
+		errs = append(errs, errors.New("jwt_secret or jwt_keys is required (jwt_random_key for development)"))
+	}
+	seen := map[string]bool{}
+	for i, k := range c.JWTKeys {
+		if k.ID == "" {
+			errs = append(errs, fmt.Errorf("jwt_keys[%d]: kid is required", i))
+			continue
+		}
+		if seen[k.ID] {
+			errs = append(errs, fmt.Errorf("jwt_keys[%d]: duplicate kid %q", i, k.ID))
+		}
+		seen[k.ID] = true
+		switch k.Algorithm {
+		case "HS256":
+			if k.Secret == "" {
+				errs = append(errs, fmt.Errorf("jwt key %q: HS256 requires secret", k.ID))
+			}
+		case "RS256", "EdDSA":
+			if k.PrivateKeyFile == "" && k.PublicKeyFile == "" {
+				errs = append(errs, fmt.Errorf("jwt key %q: %s requires private_key_file or public_key_file", k.ID, k.Algorithm))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("jwt key %q: unsupported alg %q (HS256, RS256 or EdDSA)", k.ID, k.Algorithm))
+		}
+	}
+	if c.JWTSigningKey != "" {
+		found := false
+		for _, k := range c.JWTKeys {
+			if k.ID == c.JWTSigningKey {
+				found = true
+				if k.Secret == "" && k.PrivateKeyFile == "" {
+					errs = append(errs, fmt.Errorf("jwt_signing_key %q has no private key", k.ID))
+				}
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("jwt_signing_key %q is not in jwt_keys", c.JWTSigningKey))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("bad port %q", port)
+	}
+	return nil
+}
+
+func validateBaseURL(raw string) error {
+	u, err := urlLib.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errors.New("host is missing")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("query and fragment are not allowed")
+	}
+	return nil
+}
+
+var dsnPasswordRe = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// redactDSN прячет пароль как в url-форме DSN, так и в форме key=value
+func redactDSN(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := urlLib.Parse(dsn)
+		if err != nil {
+			return redacted
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = urlLib.UserPassword(u.User.Username(), redacted)
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPasswordRe.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// String выводит действующий конфиг, секреты заменяются звёздочками
+func (c *Config) String() string {
+	var b strings.Builder
+	row := func(k, v string) {
+		fmt.Fprintf(&b, "  %-18s %s\n", k+":", v)
+	}
+	live := c.Live()
+	row("config_file", c.ConfigPath)
+	row("server_address", c.ServerAddr)
+	row("base_url", live.ResultAddr)
+	row("file_storage_path", c.FileStoragePath)
+	row("database_dsn", redactDSN(c.DatabaseDSN))
+	row("auto_migrate", strconv.FormatBool(c.AutoMigrate))
+	row("db_connect_retries", strconv.Itoa(c.DBConnectRetries))
+	row("db_connect_backoff", c.DBConnectBackoff.String())
+	row("degraded_start", strconv.FormatBool(c.DegradedStart))
+	row("db_pool", fmt.Sprintf("max %d, min %d, lifetime %s, idle %s, health check %s", c.DBMaxConns, c.DBMinConns,
+		c.DBMaxConnLifetime, c.DBMaxConnIdleTime, c.DBHealthCheckPeriod))
+	var replicas []string
+	for _, dsn := range c.DBReplicaDSNs {
+		replicas = append(replicas, redactDSN(dsn))
+	}
+	row("db_replica_dsns", strings.Join(replicas, ", "))
+	row("db_timeouts", fmt.Sprintf("read %s, write %s, batch %s", c.DBReadTimeout, c.DBWriteTimeout, c.DBBatchTimeout))
+	row("request_limits", fmt.Sprintf("%d bytes, %d decoded, ratio %d", c.MaxRequestBytes, c.MaxDecodedRequestBytes, c.MaxDecompressionRatio))
+	row("http_timeouts", fmt.Sprintf("read %s, header %s, write %s, idle %s, handler %s, batch %s", c.ReadTimeout,
+		c.ReadHeaderTimeout, c.WriteTimeout, c.IdleTimeout, c.HandlerTimeout, c.BatchHandlerTimeout))
+	row("openapi_validation", c.OpenAPIValidation)
+	row("jwt_secret", redactSecret(c.JWTSecret))
+	var kids []string
+	for _, k := range c.JWTKeys {
+		kids = append(kids, k.ID+"/"+k.Algorithm)
+	}
+	row("jwt_keys", strings.Join(kids, ", "))
+	row("jwt_signing_key", c.JWTSigningKey)
+	row("jwt_random_key", strconv.FormatBool(c.JWTRandomKey))
+	row("log_level", live.LogLevel)
+	row("grpc_address", c.GRPCAddr)
+	row("admin_logins", strings.Join(c.AdminLogins, ", "))
+	row("audit_file", c.AuditFile)
+	row("audit_postgres", strconv.FormatBool(c.AuditPostgres))
+	row("audit_webhook_url", c.AuditWebhookURL)
+	row("coming_soon_file", c.ComingSoonFile)
+	row("geoip_file", c.GeoIPFile)
+	row("enable_https", strconv.FormatBool(c.EnableHTTPS))
+	if c.EnableHTTPS {
+		row("tls_cert_file", c.TLSCertFile)
+		row("tls_key_file", c.TLSKeyFile)
+		row("tls_self_signed", strconv.FormatBool(c.TLSSelfSigned))
+		row("http_redirect_addr", c.HTTPRedirectAddr)
+	}
+	return b.String()
+}
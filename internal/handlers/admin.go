@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/audit"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/body"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"net/http"
+	urlLib "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (h *Handlers) ListDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	domains, err := h.store.ListDomains(r.Context())
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, domains)
+}
+
+func (h *Handlers) AddDomainHandler(w http.ResponseWriter, r *http.Request) {
+	rb, err := body.GetBody(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
+	}
+	var d storage.Domain
+	if err := json.Unmarshal(rb, &d); err != nil {
+		problem.Error(w, r, problem.InvalidBody, "invalid json")
+		return
+	}
+	d, err = h.normalizeDomain(d)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = h.store.AddDomain(r.Context(), d)
+	if errors.Is(err, storage.ErrDomainExists) {
+		problem.Error(w, r, problem.Conflict, "domain already exists")
+		return
+	}
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, d)
+}
+
+// normalizeDomain приводит имя к нижнему регистру и, если base_url не указан,
+// собирает его из имени и схемы адреса по умолчанию
+func (h *Handlers) normalizeDomain(d storage.Domain) (storage.Domain, error) {
+	d.Name = strings.ToLower(strings.TrimSpace(d.Name))
+	if d.Name == "" || strings.ContainsAny(d.Name, "/ ?#@") {
+		return d, problem.Invalid(problem.Field("name", "invalid domain name"))
+	}
+	if d.BaseURL == "" {
+		scheme := "https"
+		if def, err := urlLib.Parse(h.Cfg.BaseURL()); err == nil && def.Scheme != "" {
+			scheme = def.Scheme
+		}
+		d.BaseURL = scheme + "://" + d.Name
+	}
+	d.BaseURL = strings.TrimRight(d.BaseURL, "/")
+	u, err := urlLib.Parse(d.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return d, problem.Invalid(problem.Field("base_url", "must be an http(s) url"))
+	}
+	if strings.ToLower(u.Host) != d.Name {
+		return d, problem.Invalid(problem.Field("base_url", "host must match the domain name"))
+	}
+	return d, nil
+}
+
+func (h *Handlers) DeleteDomainHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+	err := h.store.DeleteDomain(r.Context(), name)
+	switch {
+	case errors.Is(err, storage.ErrDomainNotFound):
+		problem.Error(w, r, problem.DomainNotFound, "")
+	case errors.Is(err, storage.ErrDomainInUse):
+		problem.Error(w, r, problem.Conflict, "domain still has links")
+	case err != nil:
+		problem.InternalError(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SearchLinksHandler ищет по всем ссылкам: ?code=&domain=&destination=&owner=&limit=&offset=
+func (h *Handlers) SearchLinksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := storage.LinkFilter{
+		Code:        q.Get("code"),
+		Domain:      strings.ToLower(q.Get("domain")),
+		Destination: q.Get("destination"),
+		Owner:       q.Get("owner"),
+	}
+	var fields []problem.FieldError
+	f.Limit = intParam(q, "limit", &fields)
+	f.Offset = intParam(q, "offset", &fields)
+	if len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
+		return
+	}
+	links, err := h.store.SearchLinks(r.Context(), f)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	for i := range links {
+		links[i] = links[i].Public()
+	}
+	writeJSON(w, r, http.StatusOK, links)
+}
+
+// DisableLinkHandler отключает ссылку. Редирект по ней отвечает 451,
+// если причина юридическая (legal), иначе 410
+func (h *Handlers) DisableLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+		Legal  bool   `json:"legal"`
+	}
+	rb, err := body.GetBody(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
+	}
+	if err := json.Unmarshal(rb, &req); err != nil {
+		problem.Error(w, r, problem.InvalidBody, "invalid json")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		problem.Write(w, r, problem.Invalid(problem.Field("reason", "is required")))
+		return
+	}
+	status := http.StatusGone
+	if req.Legal {
+		status = http.StatusUnavailableForLegalReasons
+	}
+	id := r.PathValue("id")
+	if !h.linkResult(w, r, h.store.DisableLink(r.Context(), id, status, req.Reason)) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) EnableLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !h.linkResult(w, r, h.store.DisableLink(r.Context(), id, 0, "")) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) TransferLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	rb, err := body.GetBody(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
+	}
+	if err := json.Unmarshal(rb, &req); err != nil || req.UserID == uuid.Nil {
+		problem.Write(w, r, problem.Invalid(problem.Field("user_id", "is required")))
+		return
+	}
+	id := r.PathValue("id")
+	if !h.linkResult(w, r, h.store.TransferLink(r.Context(), id, req.UserID)) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// linkResult отвечает на ошибку операции над ссылкой и возвращает true, если ошибки не было
+func (h *Handlers) linkResult(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, storage.ErrLinkNotFound):
+		problem.Error(w, r, problem.LinkNotFound, "")
+	case err != nil:
+		problem.InternalError(w, r, err)
+	default:
+		return true
+	}
+	return false
+}
+
+// DeleteDomainLinksHandler помечает удалёнными все ссылки домена, после этого домен можно удалить
+func (h *Handlers) DeleteDomainLinksHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+	if _, err := h.store.GetDomain(r.Context(), name); err != nil {
+		problem.Error(w, r, problem.DomainNotFound, "")
+		return
+	}
+	n, err := h.store.DeleteDomainLinks(r.Context(), name)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]int{"deleted": n})
+}
+
+// AuditHandler отдаёт журнал аудита: ?user=&action=&target=&since=&until=&limit=,
+// since и until в RFC 3339
+func (h *Handlers) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := audit.Filter{
+		UserID: q.Get("user"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+	}
+	var fields []problem.FieldError
+	f.Since = timeParam(q, "since", &fields)
+	f.Until = timeParam(q, "until", &fields)
+	f.Limit = intParam(q, "limit", &fields)
+	if len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
+		return
+	}
+	events, err := h.auditor.Query(r.Context(), f)
+	if errors.Is(err, audit.ErrNotQueryable) {
+		problem.Error(w, r, problem.NotImplemented, "audit log is not queryable, configure audit_file or audit_postgres")
+		return
+	}
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+	writeJSON(w, r, http.StatusOK, events)
+}
+
+// intParam разбирает целый параметр запроса, ошибку добавляет в fields
+func intParam(q urlLib.Values, name string, fields *[]problem.FieldError) int {
+	v := q.Get(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		*fields = append(*fields, problem.Field(name, "must be an integer"))
+	}
+	return n
+}
+
+// timeParam разбирает параметр запроса в RFC 3339, ошибку добавляет в fields
+func timeParam(q urlLib.Values, name string, fields *[]problem.FieldError) time.Time {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		*fields = append(*fields, problem.Field(name, "must be a time in RFC 3339"))
+	}
+	return t
+}
@@ -6,44 +6,67 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/morozoffnor/go-url-shortener/internal/audit"
 	authHelper "github.com/morozoffnor/go-url-shortener/internal/auth"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/internal/routing"
 	"github.com/morozoffnor/go-url-shortener/internal/storage"
 	"github.com/morozoffnor/go-url-shortener/pkg/body"
 	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"html/template"
 	"log"
 	"net/http"
 	urlLib "net/url"
+	"strings"
 	"time"
 )
 
 type Handlers struct {
-	Cfg   *config.Config
-	store storage.Storage
-	auth  *authHelper.JWT
+	Cfg     *config.Config
+	store   storage.Storage
+	auth    *authHelper.JWT
+	auditor *audit.Auditor
+	// geo определяет страну для правил маршрутизации, nil — страна всегда неизвестна
+	geo routing.CountryResolver
+
+	// unlockFailures ограничивает подбор паролей к ссылкам
+	unlockFailures *failureLimiter
+	comingSoonTmpl *template.Template
 }
 
-func New(cfg *config.Config, store storage.Storage, authHelper *authHelper.JWT) *Handlers {
+func New(cfg *config.Config, store storage.Storage, authHelper *authHelper.JWT, auditor *audit.Auditor, geo routing.CountryResolver) *Handlers {
 	h := &Handlers{
-		Cfg:   cfg,
-		store: store,
-		auth:  authHelper,
+		Cfg:     cfg,
+		store:   store,
+		auth:    authHelper,
+		auditor: auditor,
+		geo:     geo,
+
+		unlockFailures: newFailureLimiter(maxPasswordFailures, passwordFailureWindow),
+		comingSoonTmpl: loadComingSoon(cfg),
 	}
 
 	return h
 }
 
+// Storage отдаёт хранилище для middleware, которым оно нужно (например, middlewares.Domain)
+func (h *Handlers) Storage() storage.Storage {
+	return h.store
+}
+
+// Auth отдаёт помощник JWT для middlewares.Auth
+func (h *Handlers) Auth() *authHelper.JWT {
+	return h.auth
+}
+
+// shortURL собирает короткую ссылку с учётом домена запроса
+func (h *Handlers) shortURL(ctx context.Context, code string) string {
+	return storage.BaseURL(h.Cfg, storage.DomainFromContext(ctx)) + "/" + code
+}
+
+// ShortURLHandler принимает и отдаёт text/plain, поэтому и ошибки у него текстовые
 func (h *Handlers) ShortURLHandler(w http.ResponseWriter, r *http.Request) {
-	if !h.auth.CheckToken(r) {
-		ctx, err := h.auth.AddTokenToCookies(&w, r)
-		if err != nil {
-			http.Error(w, "Error creating token", http.StatusBadRequest)
-			return
-		}
-		r = r.WithContext(ctx)
-	}
 	body, err := body.GetBody(r)
 	if err != nil {
 		http.Error(w, "Failed parsing body", http.StatusBadRequest)
@@ -55,30 +78,29 @@ func (h *Handlers) ShortURLHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	ctx := r.Context()
 	url, err := h.store.AddNewURL(ctx, decodedBody)
-	defer cancel()
 
 	if err != nil {
-		var pgErr *pgconn.PgError
 		// возвращаем 409 если такой URL уже есть в бд
-		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		if errors.Is(err, storage.ErrURLExists) {
 			w.Header().Set("Content-Type", "text/plain, utf-8")
 			w.WriteHeader(http.StatusConflict)
 			// просто Fprint подставляет /n в конце строки, автотесты ругаются
-			_, err = fmt.Fprintf(w, "%s", h.Cfg.ResultAddr+"/"+url)
+			_, err = fmt.Fprintf(w, "%s", h.shortURL(ctx, url))
 			if err != nil {
 				log.Print("error while writing response")
 				return
 			}
 			return
 		}
+		logger.Logger.Error(err)
 		http.Error(w, "Unexpected internal error", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain, utf-8")
 	w.WriteHeader(http.StatusCreated)
-	_, err = fmt.Fprint(w, h.Cfg.ResultAddr+"/"+url)
+	_, err = fmt.Fprint(w, h.shortURL(ctx, url))
 	if err != nil {
 		log.Print("error while writing response")
 		return
@@ -86,84 +108,180 @@ func (h *Handlers) ShortURLHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) FullURLHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
-	v, isDeleted, err := h.store.GetFullURL(ctx, r.PathValue("id"))
-	if err != nil {
-		logger.Logger.Error(err)
-		http.Error(w, "Error", http.StatusBadRequest)
+	link, ok := h.activeLink(w, r)
+	if !ok {
 		return
 	}
-	if isDeleted {
-		http.Error(w, "Deleted", http.StatusGone)
+	if link.HasPassword() && !h.unlocked(r, link) {
+		passwordPage(w, http.StatusOK, "")
 		return
 	}
-	http.Redirect(w, r, v, http.StatusTemporaryRedirect)
+	h.redirect(w, r, link, http.StatusTemporaryRedirect)
+}
+
+// redirect отправляет на адрес ссылки, засчитывая переход, если их число ограничено
+func (h *Handlers) redirect(w http.ResponseWriter, r *http.Request, link storage.Link, status int) {
+	if link.Limited() {
+		err := h.store.ConsumeClick(r.Context(), link.ID)
+		if errors.Is(err, storage.ErrLinkExhausted) {
+			problem.Error(w, r, problem.LinkGone, "link has reached its click limit")
+			return
+		}
+		if err != nil {
+			problem.InternalError(w, r, err)
+			return
+		}
+		// одноразовую ссылку браузер не должен запоминать
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	http.Redirect(w, r, h.destination(w, r, link), status)
+}
+
+// activeLink находит ссылку по коду из пути. Если по ней нельзя перейти,
+// отвечает сам и возвращает false
+func (h *Handlers) activeLink(w http.ResponseWriter, r *http.Request) (storage.Link, bool) {
+	ctx := r.Context()
+	link, err := h.store.GetLink(ctx, r.PathValue("id"))
+	// /{id}/* ловит и опечатки в путях API, на них отвечаем как на несуществующий путь
+	if pathSuffix(r) != "" && errors.Is(err, storage.ErrLinkNotFound) {
+		problem.Error(w, r, problem.NotFound, "")
+		return link, false
+	}
+	if errors.Is(err, storage.ErrLinkNotFound) {
+		problem.Error(w, r, problem.LinkNotFound, "")
+		return link, false
+	}
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return link, false
+	}
+	// путь после кода принимают только ссылки с forward_path
+	if pathSuffix(r) != "" && (link.Options == nil || !link.Options.ForwardPath) {
+		problem.Error(w, r, problem.NotFound, "")
+		return link, false
+	}
+	if link.IsDeleted {
+		problem.Error(w, r, problem.LinkGone, "link has been deleted")
+		return link, false
+	}
+	if link.Disabled() {
+		disabledPage(w, link)
+		return link, false
+	}
+	now := time.Now()
+	if link.Exhausted() || link.Expired(now) {
+		problem.Error(w, r, problem.LinkGone, "link has expired")
+		return link, false
+	}
+	if link.Pending(now) {
+		h.comingSoon(w, r, link)
+		return link, false
+	}
+	return link, true
+}
+
+var disabledTmpl = template.Must(template.New("disabled").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body><h1>{{.Title}}</h1><p>{{.Reason}}</p></body></html>
+`))
+
+// disabledPage показывает причину, по которой модератор отключил ссылку
+func disabledPage(w http.ResponseWriter, link storage.Link) {
+	title := "This link has been disabled"
+	if link.DisabledStatus == http.StatusUnavailableForLegalReasons {
+		title = "This link is unavailable for legal reasons"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(link.DisabledStatus)
+	err := disabledTmpl.Execute(w, struct{ Title, Reason string }{title, link.DisabledReason})
+	if err != nil {
+		logger.Logger.Error(err)
+	}
 }
 
 func (h *Handlers) ShortenHandler(w http.ResponseWriter, r *http.Request) {
 	type reqBody struct {
-		URL string `json:"url"`
+		URL    string `json:"url"`
+		Domain string `json:"domain,omitempty"`
+		// Password защищает ссылку паролем, такая ссылка всегда создаётся новой
+		Password string `json:"password,omitempty"`
+		// MaxClicks ограничивает число переходов, 1 — одноразовая ссылка
+		MaxClicks int `json:"max_clicks,omitempty"`
+		// окно работы ссылки, см. ScheduleHandler
+		scheduleRequest
+		// перенос пути и параметров запроса, см. ForwardingHandler
+		routing.Forwarding
 	}
 	type resBody struct {
 		Result string `json:"result"`
 	}
 
-	if !h.auth.CheckToken(r) {
-		ctx, err := h.auth.AddTokenToCookies(&w, r)
-		if err != nil {
-			http.Error(w, "Error creating token", http.StatusBadRequest)
-			return
-		}
-		r = r.WithContext(ctx)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
 	var raw bytes.Buffer
 	if _, err := raw.ReadFrom(r.Body); err != nil {
-		http.Error(w, "Invalid body", http.StatusUnprocessableEntity)
+		problem.Write(w, r, problem.New(problem.InvalidBody, "").WithStatus(http.StatusUnprocessableEntity))
 		return
 	}
 
 	rbody := &reqBody{}
 	err := json.Unmarshal(raw.Bytes(), rbody)
 	if err != nil {
-		http.Error(w, "Invalid json", http.StatusUnprocessableEntity)
+		problem.Write(w, r, problem.New(problem.InvalidBody, "invalid json").WithStatus(http.StatusUnprocessableEntity))
 		return
 	}
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
-	url, err := h.store.AddNewURL(ctx, rbody.URL)
-
-	if err != nil {
-		var pgErr *pgconn.PgError
-		// возвращаем 409 если такой URL уже есть в бд
-		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			short := &resBody{Result: h.Cfg.ResultAddr + "/" + url}
-			resp, err := json.Marshal(short)
-			if err != nil {
-				logger.Logger.Error(err)
-				http.Error(w, "Fail during serializing", http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusConflict)
-			w.Write(resp)
+	ctx := r.Context()
+	// домен можно указать явно, иначе берётся домен из Host
+	if rbody.Domain != "" {
+		d, err := h.store.GetDomain(ctx, strings.ToLower(rbody.Domain))
+		if err != nil {
+			problem.Write(w, r, problem.Invalid(problem.Field("domain", "unknown domain")))
 			return
 		}
-		logger.Logger.Error(err)
-		http.Error(w, "Unexpected internal error", http.StatusInternalServerError)
+		ctx = storage.WithDomain(ctx, d)
+	}
+	var fields []problem.FieldError
+	if rbody.MaxClicks < 0 {
+		fields = append(fields, problem.Field("max_clicks", "must not be negative"))
+	}
+	fields = append(fields, rbody.scheduleRequest.validate()...)
+	if err := rbody.Forwarding.Validate(rbody.URL); err != nil {
+		fields = append(fields, problem.Field("forwarding", err.Error()))
+	}
+	if len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
 		return
 	}
+	opts := storage.LinkOptions{
+		MaxClicks:   rbody.MaxClicks,
+		NotBefore:   rbody.NotBefore,
+		NotAfter:    rbody.NotAfter,
+		FallbackURL: rbody.FallbackURL,
+		Forwarding:  rbody.Forwarding,
+	}
+	if rbody.Password != "" {
+		opts.PasswordHash, err = authHelper.HashPassword(rbody.Password)
+		if err != nil {
+			// bcrypt не принимает пароли длиннее 72 байт
+			problem.Write(w, r, problem.Invalid(problem.Field("password", "must be at most 72 bytes")))
+			return
+		}
+	}
+	var url string
+	if !opts.IsZero() {
+		url, err = h.store.AddNewURLWithOptions(ctx, rbody.URL, opts)
+	} else {
+		url, err = h.store.AddNewURL(ctx, rbody.URL)
+	}
 
-	w.WriteHeader(http.StatusCreated)
-	short := &resBody{Result: h.Cfg.ResultAddr + "/" + url}
-	resp, err := json.Marshal(short)
 	if err != nil {
-		logger.Logger.Error(err)
-		http.Error(w, "Fail during serializing", http.StatusInternalServerError)
+		// возвращаем 409 если такой URL уже есть в бд
+		if errors.Is(err, storage.ErrURLExists) {
+			writeJSON(w, r, http.StatusConflict, &resBody{Result: h.shortURL(ctx, url)})
+			return
+		}
+		problem.InternalError(w, r, err)
 		return
 	}
-	w.Write(resp)
+	writeJSON(w, r, http.StatusCreated, &resBody{Result: h.shortURL(ctx, url)})
 }
 
 func (h *Handlers) PingHandler(w http.ResponseWriter, r *http.Request) {
@@ -180,88 +298,75 @@ func (h *Handlers) PingHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (h *Handlers) BatchHandler(w http.ResponseWriter, r *http.Request) {
-	if !h.auth.CheckToken(r) {
-		ctx, err := h.auth.AddTokenToCookies(&w, r)
-		if err != nil {
-			http.Error(w, "Error creating token", http.StatusBadRequest)
-			return
-		}
-		r = r.WithContext(ctx)
+// ReadyHandler отвечает 503, пока хранилище не готово принимать запросы,
+// например пока сервер в режиме DegradedStart ждёт базу
+func (h *Handlers) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if v, ok := h.store.(storage.Pingable); ok && !v.Ping(r.Context()) {
+		problem.Error(w, r, problem.Unavailable, "storage is not ready")
+		return
 	}
+	w.Write([]byte("ok"))
+}
+
+func (h *Handlers) BatchHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := body.GetBody(r)
 	if err != nil {
-		http.Error(w, "Failed parsing body", http.StatusBadRequest)
+		problem.Error(w, r, problem.InvalidBody, "")
 		return
 	}
 	var input []storage.BatchInput
-	err = json.Unmarshal(body, &input)
-	w.Header().Set("Content-Type", "application/json")
-	if err != nil {
-		http.Error(w, "Failed decoding body", http.StatusBadRequest)
+	if err := json.Unmarshal(body, &input); err != nil {
+		problem.Error(w, r, problem.InvalidBody, "invalid json")
 		return
 	}
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
+	ctx := r.Context()
 	output, err := h.store.AddBatch(ctx, input)
 	if err != nil {
-		http.Error(w, "Unexpected internal error", http.StatusInternalServerError)
+		problem.InternalError(w, r, err)
 		return
 	}
-
-	w.WriteHeader(http.StatusCreated)
-	resp, err := json.Marshal(output)
-	if err != nil {
-		http.Error(w, "Fail during serializing", http.StatusInternalServerError)
-	}
-	w.Write(resp)
+	writeJSON(w, r, http.StatusCreated, output)
 }
 
 func (h *Handlers) GetUserURLsHandler(w http.ResponseWriter, r *http.Request) {
-
-	userID, ok := r.Context().Value(authHelper.ContextUserID).(uuid.UUID)
-
+	// пользователя гарантирует middlewares.Auth с политикой RequireIdentity
+	userID, ok := authHelper.UserIDFromContext(r.Context())
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, problem.Unauthorized, "")
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
+	ctx := r.Context()
 	v, err := h.store.GetUserURLs(ctx, userID)
 	if err != nil {
-		http.Error(w, "Error", http.StatusBadRequest)
+		problem.InternalError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
 	if v == nil {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	resp, err := json.Marshal(v)
-	log.Print(v)
-	if err != nil {
-		http.Error(w, "Fail during serializing", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(resp)
+	writeJSON(w, r, http.StatusOK, v)
 }
 
 func (h *Handlers) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
-	if !h.auth.CheckToken(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID, ok := authHelper.UserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "")
 		return
 	}
 	var ids []string
-	//var items []*storage.DeleteURLItem
 
 	rb, err := body.GetBody(r)
 	if err != nil {
-		http.Error(w, "Error parsing body", http.StatusBadRequest)
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
 	}
 	err = json.Unmarshal(rb, &ids)
 	if err != nil {
-		http.Error(w, "Error parsing body", http.StatusBadRequest)
+		problem.Error(w, r, problem.InvalidBody, "expected a json array of codes")
+		return
 	}
 
 	if len(ids) == 0 {
@@ -269,6 +374,6 @@ func (h *Handlers) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.store.DeleteURLs(r.Context(), r.Context().Value(authHelper.ContextUserID).(uuid.UUID), ids)
+	h.store.DeleteURLs(r.Context(), userID, ids)
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -5,15 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/audit"
 	"github.com/morozoffnor/go-url-shortener/internal/auth"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
 	"github.com/morozoffnor/go-url-shortener/internal/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -23,10 +28,12 @@ func TestShortURL(t *testing.T) {
 		ServerAddr:      ":8080",
 		ResultAddr:      "http://localhost:8080",
 		FileStoragePath: "/tmp/test.json",
+		JWTRandomKey:    true,
 	}
 	strg := storage.NewMemoryStorage(cfg)
-	authHelper := auth.New(cfg)
-	h := New(cfg, strg, authHelper)
+	authHelper, err := auth.New(cfg)
+	require.NoError(t, err)
+	h := New(cfg, strg, authHelper, audit.New(), nil)
 	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	require.Nil(t, err)
 	defer tmpFile.Close()
@@ -53,8 +60,9 @@ func TestShortURL(t *testing.T) {
 		{
 			name: "Positive test #2 (post the same full url twice)",
 			body: []string{"http://test.com/", "http://test.com/"},
+			// адрес уже сокращён в первом тесте
 			want: want{
-				code:        http.StatusCreated,
+				code:        http.StatusConflict,
 				response:    "",
 				contentType: "text/plain, utf-8",
 			},
@@ -70,7 +78,7 @@ func TestShortURL(t *testing.T) {
 				request := httptest.NewRequest(http.MethodPost, "/", rBody)
 
 				w := httptest.NewRecorder()
-				request = request.WithContext(context.WithValue(request.Context(), auth.ContextUserID, uuid.New()))
+				request = request.WithContext(auth.WithIdentity(request.Context(), auth.Identity{UserID: uuid.New()}))
 				h.ShortURLHandler(w, request)
 
 				res := w.Result()
@@ -96,10 +104,12 @@ func TestFullUrl(t *testing.T) {
 		ServerAddr:      ":8080",
 		ResultAddr:      "http://localhost:8080",
 		FileStoragePath: "/tmp/test.json",
+		JWTRandomKey:    true,
 	}
 	strg := storage.NewMemoryStorage(cfg)
-	authHelper := auth.New(cfg)
-	h := New(cfg, strg, authHelper)
+	authHelper, err := auth.New(cfg)
+	require.NoError(t, err)
+	h := New(cfg, strg, authHelper, audit.New(), nil)
 	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	require.Nil(t, err)
 	defer tmpFile.Close()
@@ -127,7 +137,7 @@ func TestFullUrl(t *testing.T) {
 			name:     "Negative test (url does not exist)",
 			shortURL: "/TeSt",
 			want: want{
-				code:          http.StatusBadRequest,
+				code:          http.StatusNotFound,
 				url:           "http://test.xyz/",
 				checkLocation: false,
 			},
@@ -137,14 +147,14 @@ func TestFullUrl(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
-			ctx = context.WithValue(ctx, auth.ContextUserID, uuid.New())
+			ctx = auth.WithIdentity(ctx, auth.Identity{UserID: uuid.New()})
 			url, _ := strg.AddNewURL(ctx, "http://test.xyz/")
 			if !test.want.checkLocation {
 				url = "DoNotCare"
 			}
 			request := httptest.NewRequest(http.MethodGet, "/"+url, nil)
 			request.SetPathValue("id", url)
-			rctx := context.WithValue(request.Context(), auth.ContextUserID, uuid.New())
+			rctx := auth.WithIdentity(request.Context(), auth.Identity{UserID: uuid.New()})
 			request = request.WithContext(rctx)
 			w := httptest.NewRecorder()
 			h.FullURLHandler(w, request)
@@ -165,10 +175,12 @@ func TestShorten(t *testing.T) {
 		ServerAddr:      ":8080",
 		ResultAddr:      "http://localhost:8080",
 		FileStoragePath: "/tmp/test.json",
+		JWTRandomKey:    true,
 	}
 	strg := storage.NewMemoryStorage(cfg)
-	authHelper := auth.New(cfg)
-	h := New(cfg, strg, authHelper)
+	authHelper, err := auth.New(cfg)
+	require.NoError(t, err)
+	h := New(cfg, strg, authHelper, audit.New(), nil)
 	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	require.Nil(t, err)
 	defer tmpFile.Close()
@@ -202,8 +214,9 @@ func TestShorten(t *testing.T) {
 		{
 			name: "Positive test #2 (post the same full url twice)",
 			body: []reqBody{{URL: "http://test.com/"}, {URL: "http://test.com/"}},
+			// адрес уже сокращён в первом тесте
 			want: want{
-				code:        http.StatusCreated,
+				code:        http.StatusConflict,
 				response:    resBody{},
 				contentType: "application/json",
 			},
@@ -218,7 +231,7 @@ func TestShorten(t *testing.T) {
 				jsonReqBody, err := json.Marshal(body)
 				require.NoError(t, err)
 				request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(jsonReqBody))
-				ctx := context.WithValue(request.Context(), auth.ContextUserID, uuid.New())
+				ctx := auth.WithIdentity(request.Context(), auth.Identity{UserID: uuid.New()})
 				request = request.WithContext(ctx)
 				w := httptest.NewRecorder()
 				h.ShortenHandler(w, request)
@@ -243,3 +256,109 @@ func TestShorten(t *testing.T) {
 		})
 	}
 }
+
+func TestFailureLimiter(t *testing.T) {
+	now := time.Now()
+	l := newFailureLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	_, ok := l.Acquire("a")
+	assert.True(t, ok)
+	// удачная попытка возвращается и лимит не расходует
+	l.Release("a")
+	_, ok = l.Acquire("a")
+	assert.True(t, ok)
+	_, ok = l.Acquire("a")
+	assert.True(t, ok)
+	wait, ok := l.Acquire("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, wait)
+	_, ok = l.Acquire("b")
+	assert.True(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = l.Acquire("a")
+	assert.True(t, ok)
+	assert.Len(t, l.hits, 1)
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.DefaultCost, cost)
+}
+
+func TestFailureLimiterConcurrent(t *testing.T) {
+	l := newFailureLimiter(5, time.Minute)
+	var wg sync.WaitGroup
+	var acquired atomic.Int32
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := l.Acquire("a"); ok {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), acquired.Load())
+}
+
+func TestLoadComingSoon(t *testing.T) {
+	notBefore := time.Date(2030, 1, 2, 3, 4, 0, 0, time.UTC)
+	custom := filepath.Join(t.TempDir(), "soon.html")
+	require.NoError(t, os.WriteFile(custom, []byte(`<p>Launch at {{.NotBefore.Year}}</p>`), 0600))
+	broken := filepath.Join(t.TempDir(), "broken.html")
+	require.NoError(t, os.WriteFile(broken, []byte(`{{.NotBefore`), 0600))
+
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{name: "built-in", file: "", want: "This link opens on 2030-01-02 03:04 UTC"},
+		{name: "custom", file: custom, want: "<p>Launch at 2030</p>"},
+		{name: "broken falls back", file: broken, want: "Coming soon"},
+		{name: "missing falls back", file: filepath.Join(t.TempDir(), "missing.html"), want: "Coming soon"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tmpl := loadComingSoon(&config.Config{ComingSoonFile: test.file})
+			require.NoError(t, tmpl.Execute(&out, struct{ NotBefore time.Time }{notBefore}))
+			assert.Contains(t, out.String(), test.want)
+		})
+	}
+}
+
+// pingStorage — хранилище, доступность которого задаёт тест
+type pingStorage struct {
+	storage.Storage
+	ok bool
+}
+
+func (s pingStorage) Ping(context.Context) bool {
+	return s.ok
+}
+
+func TestReady(t *testing.T) {
+	cfg := &config.Config{ResultAddr: "http://localhost:8080"}
+	tests := []struct {
+		name string
+		strg storage.Storage
+		want int
+	}{
+		{name: "memory", strg: storage.NewMemoryStorage(cfg), want: http.StatusOK},
+		{name: "connected", strg: pingStorage{storage.NewMemoryStorage(cfg), true}, want: http.StatusOK},
+		{name: "waiting for database", strg: pingStorage{storage.NewMemoryStorage(cfg), false}, want: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(cfg, audit.Wrap(test.strg, audit.New()), nil, audit.New(), nil)
+			w := httptest.NewRecorder()
+			h.ReadyHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, test.want, w.Code)
+		})
+	}
+}
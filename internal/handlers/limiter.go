@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// failureLimiter считает неудачные попытки по ключу в фиксированном окне.
+// После max неудач ключ блокируется до конца окна
+type failureLimiter struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	now    func() time.Time
+	hits   map[string]*failureWindow
+}
+
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		max:    max,
+		window: window,
+		now:    time.Now,
+		hits:   map[string]*failureWindow{},
+	}
+}
+
+// Acquire занимает попытку для ключа до её проверки: проверка лимита
+// и учёт попытки идут под одной блокировкой, поэтому параллельные запросы
+// не проходят сверх max. Если попытки исчерпаны, возвращает, сколько ещё ждать.
+// Удачную попытку нужно вернуть через Release, неудачная так и остаётся учтённой
+func (l *failureLimiter) Acquire(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	w, ok := l.hits[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.sweep(now)
+		w = &failureWindow{start: now}
+		l.hits[key] = w
+	}
+	if w.count >= l.max {
+		return w.start.Add(l.window).Sub(now), false
+	}
+	w.count++
+	return 0, true
+}
+
+// Release возвращает попытку, оказавшуюся удачной
+func (l *failureLimiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w, ok := l.hits[key]; ok && w.count > 0 {
+		w.count--
+	}
+}
+
+// sweep выбрасывает истёкшие окна, чтобы перебор кодов не раздувал память
+func (l *failureLimiter) sweep(now time.Time) {
+	for k, w := range l.hits {
+		if now.Sub(w.start) >= l.window {
+			delete(l.hits, k)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	authHelper "github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/internal/routing"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/body"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"html/template"
+	"net/http"
+	urlLib "net/url"
+	"strings"
+	"time"
+)
+
+var defaultComingSoonTmpl = template.Must(template.New("coming-soon").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Coming soon</title></head>
+<body><h1>Coming soon</h1><p>This link opens on {{.NotBefore.UTC.Format "2006-01-02 15:04 MST"}}.</p></body></html>
+`))
+
+// loadComingSoon читает шаблон из config.ComingSoonFile. Если файл битый,
+// сервер продолжает работать со встроенной страницей
+func loadComingSoon(cfg *config.Config) *template.Template {
+	if cfg.ComingSoonFile == "" {
+		return defaultComingSoonTmpl
+	}
+	t, err := template.ParseFiles(cfg.ComingSoonFile)
+	if err != nil {
+		logger.Logger.Errorw("coming soon template, using the built-in page", "error", err)
+		return defaultComingSoonTmpl
+	}
+	return t
+}
+
+// comingSoon отвечает на переход по ссылке до начала её окна. 503 с Retry-After
+// говорит клиентам и поисковикам прийти позже, а не забыть ссылку, как было бы с 403
+func (h *Handlers) comingSoon(w http.ResponseWriter, r *http.Request, link storage.Link) {
+	if link.Options.FallbackURL != "" {
+		http.Redirect(w, r, link.Options.FallbackURL, http.StatusFound)
+		return
+	}
+	notBefore := *link.Options.NotBefore
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", notBefore.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := h.comingSoonTmpl.Execute(w, struct{ NotBefore time.Time }{notBefore}); err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+// ownedLink находит ссылку текущего пользователя по коду из пути. Домен берётся
+// из ?domain= или из Host. Чужие ссылки неотличимы от несуществующих
+func (h *Handlers) ownedLink(w http.ResponseWriter, r *http.Request) (storage.Link, bool) {
+	userID, ok := authHelper.UserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "")
+		return storage.Link{}, false
+	}
+	// ссылку сейчас будут менять, реплика могла ещё не получить прошлую правку
+	ctx := storage.WithPrimary(r.Context())
+	if name := r.URL.Query().Get("domain"); name != "" {
+		d, err := h.store.GetDomain(ctx, strings.ToLower(name))
+		if err != nil {
+			problem.Write(w, r, problem.Invalid(problem.Field("domain", "unknown domain")))
+			return storage.Link{}, false
+		}
+		ctx = storage.WithDomain(ctx, d)
+	}
+	link, err := h.store.GetLink(ctx, r.PathValue("code"))
+	if err == nil && (link.UserID != userID.String() || link.IsDeleted) {
+		err = storage.ErrLinkNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, storage.ErrLinkNotFound) {
+			logger.Logger.Error(err)
+		}
+		problem.Error(w, r, problem.LinkNotFound, "")
+		return storage.Link{}, false
+	}
+	return link, true
+}
+
+// linkEditError отвечает на ошибку изменения ссылки владельца. Обычную ссылку
+// дедупликация могла выдать и другим пользователям, сократившим тот же адрес:
+// её адрес и настройки не меняются, иначе владелец поменял бы переход и для них
+func linkEditError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, storage.ErrLinkShared):
+		problem.Error(w, r, problem.LinkShared, "shorten the url with options to get a link of your own")
+	case errors.Is(err, storage.ErrLinkNotFound):
+		problem.Error(w, r, problem.LinkNotFound, "")
+	default:
+		problem.InternalError(w, r, err)
+	}
+}
+
+type scheduleRequest struct {
+	NotBefore   *time.Time `json:"not_before"`
+	NotAfter    *time.Time `json:"not_after"`
+	FallbackURL string     `json:"fallback_url"`
+}
+
+func (s scheduleRequest) validate() []problem.FieldError {
+	var fields []problem.FieldError
+	if s.NotBefore != nil && s.NotAfter != nil && !s.NotAfter.After(*s.NotBefore) {
+		fields = append(fields, problem.Field("not_after", "must be later than not_before"))
+	}
+	if s.FallbackURL != "" {
+		u, err := urlLib.Parse(s.FallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fields = append(fields, problem.Field("fallback_url", "must be an http(s) url"))
+		}
+	}
+	return fields
+}
+
+// ScheduleHandler задаёт окно работы ссылки владельца:
+// PUT /api/user/urls/{code}/schedule {"not_before", "not_after", "fallback_url"}.
+// Отсутствующее или null поле снимает ограничение
+func (h *Handlers) ScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+	rb, err := body.GetBody(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
+	}
+	var req scheduleRequest
+	if err := json.Unmarshal(rb, &req); err != nil {
+		problem.Error(w, r, problem.InvalidBody, "invalid json")
+		return
+	}
+	if fields := req.validate(); len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
+		return
+	}
+
+	var opts storage.LinkOptions
+	if link.Options != nil {
+		opts = *link.Options
+	}
+	opts.NotBefore, opts.NotAfter, opts.FallbackURL = req.NotBefore, req.NotAfter, req.FallbackURL
+	if err := h.store.SetLinkOptions(r.Context(), link.ID, opts); err != nil {
+		linkEditError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, req)
+}
+
+// ForwardingHandler задаёт перенос пути и параметров запроса в адрес назначения:
+// PUT /api/user/urls/{code}/forwarding {"forward_query", "forward_path", "template", "default_query"}.
+// Настройки заменяются целиком
+func (h *Handlers) ForwardingHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+	rb, err := body.GetBody(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
+	}
+	var req routing.Forwarding
+	if err := json.Unmarshal(rb, &req); err != nil {
+		problem.Error(w, r, problem.InvalidBody, "invalid json")
+		return
+	}
+	if err := req.Validate(link.OriginalURL); err != nil {
+		problem.Write(w, r, problem.Invalid(problem.Field("forwarding", err.Error())))
+		return
+	}
+
+	var opts storage.LinkOptions
+	if link.Options != nil {
+		opts = *link.Options
+	}
+	opts.Forwarding = req
+	if err := h.store.SetLinkOptions(r.Context(), link.ID, opts); err != nil {
+		linkEditError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, req)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	authHelper "github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxPasswordFailures неверных паролей за passwordFailureWindow блокируют ввод для ссылки
+	maxPasswordFailures   = 5
+	passwordFailureWindow = 5 * time.Minute
+)
+
+var passwordTmpl = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Password required</title></head>
+<body><h1>This link is password protected</h1>
+{{if .}}<p>{{.}}</p>{{end}}
+<form method="post"><input type="password" name="password" autofocus required> <button type="submit">Open</button></form>
+</body></html>
+`))
+
+// passwordPage показывает форму ввода пароля, message — ошибка прошлой попытки
+func passwordPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := passwordTmpl.Execute(w, message); err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func linkCookieName(link storage.Link) string {
+	return "link_" + link.Code
+}
+
+// unlocked проверяет куку, выданную UnlockHandler после верного пароля
+func (h *Handlers) unlocked(r *http.Request, link storage.Link) bool {
+	c, err := r.Cookie(linkCookieName(link))
+	if err != nil {
+		return false
+	}
+	return h.auth.CheckLinkToken(c.Value, link.ID)
+}
+
+// UnlockHandler принимает пароль из формы passwordPage и открывает ссылку
+func (h *Handlers) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.activeLink(w, r)
+	if !ok {
+		return
+	}
+	if !link.HasPassword() {
+		h.redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	key := link.ID
+	if wait, ok := h.unlockFailures.Acquire(key); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		passwordPage(w, http.StatusTooManyRequests, "Too many attempts, try again later")
+		return
+	}
+	if !authHelper.CheckPassword(link.Options.PasswordHash, r.PostFormValue("password")) {
+		passwordPage(w, http.StatusUnauthorized, "Wrong password")
+		return
+	}
+	h.unlockFailures.Release(key)
+
+	token, err := h.auth.GenerateLinkToken(link.ID, authHelper.LinkAccessTTL)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     linkCookieName(link),
+		Value:    token,
+		Path:     "/" + link.Code,
+		MaxAge:   int(authHelper.LinkAccessTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.Cfg.EnableHTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
+	h.redirect(w, r, link, http.StatusSeeOther)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/routing"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/body"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"github.com/morozoffnor/go-url-shortener/pkg/requestid"
+	"net"
+	"net/http"
+	urlLib "net/url"
+	"path"
+	"strings"
+)
+
+// visitorCookie закрепляет посетителя за вариантом A/B-разбиения во всех ссылках
+const visitorCookie = "visitor_id"
+
+const visitorCookieTTL = 365 * 24 * 60 * 60
+
+// destination выбирает адрес назначения по правилам ссылки и переносит
+// в него путь и параметры запроса
+func (h *Handlers) destination(w http.ResponseWriter, r *http.Request, link storage.Link) string {
+	if link.Options == nil {
+		return link.OriginalURL
+	}
+	return link.Options.Forwarding.Apply(h.route(w, r, link), pathSuffix(r), r.URL.Query())
+}
+
+// route выбирает адрес по правилам, без них — OriginalURL
+func (h *Handlers) route(w http.ResponseWriter, r *http.Request, link storage.Link) string {
+	if len(link.Options.Rules) == 0 {
+		return link.OriginalURL
+	}
+	rules := link.Options.Rules
+	v := routing.Visitor{
+		Devices:  routing.DetectDevices(r.UserAgent()),
+		Language: routing.PreferredLanguage(r.Header.Get("Accept-Language")),
+	}
+	if h.geo != nil {
+		v.Country = h.geo.Country(net.ParseIP(clientIP(r)))
+	}
+	if routing.NeedsVisitorID(rules) {
+		v.ID = h.visitorID(w, r)
+	}
+	// ответ зависит от посетителя, общим кешам его хранить нельзя
+	w.Header().Set("Cache-Control", "private, no-store")
+	if dest, ok := routing.Route(rules, v, link.ID); ok {
+		return dest
+	}
+	return link.OriginalURL
+}
+
+// pathSuffix возвращает путь после кода ссылки (/{id}/*) без ведущего слэша.
+// Точки в пути схлопываются, чтобы суффикс не выходил за пределы адреса назначения
+func pathSuffix(r *http.Request) string {
+	suffix := r.PathValue("*")
+	if suffix == "" {
+		return ""
+	}
+	// chi сопоставляет маршрут по RawPath, если он есть, и тогда суффикс экранирован
+	if r.URL.RawPath != "" {
+		if s, err := urlLib.PathUnescape(suffix); err == nil {
+			suffix = s
+		}
+	}
+	cleaned := strings.TrimPrefix(path.Clean("/"+suffix), "/")
+	if cleaned != "" && strings.HasSuffix(suffix, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// visitorID читает ID посетителя из куки или выдаёт новый
+func (h *Handlers) visitorID(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(visitorCookie); err == nil {
+		if _, err := uuid.Parse(c.Value); err == nil {
+			return c.Value
+		}
+	}
+	id := uuid.NewString()
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   visitorCookieTTL,
+		HttpOnly: true,
+		Secure:   h.Cfg.EnableHTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// clientIP берёт адрес, сохранённый middlewares.RequestID, иначе RemoteAddr
+func clientIP(r *http.Request) string {
+	if ip := requestid.FromContext(r.Context()).ClientIP; ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// GetRulesHandler отдаёт правила маршрутизации ссылки владельца
+func (h *Handlers) GetRulesHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+	rules := []routing.Rule{}
+	if link.Options != nil && link.Options.Rules != nil {
+		rules = link.Options.Rules
+	}
+	writeJSON(w, r, http.StatusOK, rules)
+}
+
+// PutRulesHandler заменяет правила маршрутизации ссылки владельца целиком,
+// пустой список их снимает
+func (h *Handlers) PutRulesHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedLink(w, r)
+	if !ok {
+		return
+	}
+	rb, err := body.GetBody(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
+	}
+	var rules []routing.Rule
+	if err := json.Unmarshal(rb, &rules); err != nil {
+		problem.Error(w, r, problem.InvalidBody, "invalid json")
+		return
+	}
+	if err := routing.Validate(rules); err != nil {
+		problem.Write(w, r, problem.Invalid(problem.Field("rules", err.Error())))
+		return
+	}
+
+	var opts storage.LinkOptions
+	if link.Options != nil {
+		opts = *link.Options
+	}
+	opts.Rules = rules
+	if err := h.store.SetLinkOptions(r.Context(), link.ID, opts); err != nil {
+		linkEditError(w, r, err)
+		return
+	}
+	if rules == nil {
+		rules = []routing.Rule{}
+	}
+	writeJSON(w, r, http.StatusOK, rules)
+}
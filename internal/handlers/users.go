@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	authHelper "github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/body"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const minPasswordLen = 8
+
+// dummyPasswordHash — bcrypt-хэш той же стоимости, что у HashPassword. С ним
+// сверяется пароль неизвестного логина, чтобы по времени ответа нельзя было
+// понять, есть ли такой пользователь
+const dummyPasswordHash = "$2a$10$NiwlL24Gkl23aRdi7XWByOmkhT72dCnye0R3e71SJevh/tXYo796C"
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type tokenResponse struct {
+	UserID       uuid.UUID `json:"user_id"`
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresIn    int       `json:"expires_in"`
+	Role         string    `json:"role,omitempty"`
+	// Claimed — сколько анонимных ссылок перенесено в учётную запись
+	Claimed int `json:"claimed,omitempty"`
+}
+
+type apiKeyResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Key       string    `json:"key,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func readCredentials(r *http.Request) (credentials, error) {
+	var c credentials
+	rb, err := body.GetBody(r)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(rb, &c); err != nil {
+		return c, errors.New("invalid json")
+	}
+	c.Login = strings.TrimSpace(c.Login)
+	return c, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// writeError отвечает ошибкой err: *problem.Problem как есть, остальные — 500
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		problem.Write(w, r, p)
+		return
+	}
+	problem.InternalError(w, r, err)
+}
+
+// registeredUser возвращает пользователя, если запрос пришёл от учётной записи
+// (по токену зарегистрированного пользователя или по API-ключу)
+func registeredUser(r *http.Request) (uuid.UUID, bool) {
+	id, ok := authHelper.IdentityFromContext(r.Context())
+	return id.UserID, ok && id.Registered
+}
+
+// issueTokens выдаёт пару access/refresh и кладёт access-токен в куку,
+// чтобы браузерные клиенты продолжали работать как раньше.
+// Роль берётся из конфига при каждой выдаче, поэтому снятие админа
+// вступает в силу не позже чем через AccessTokenTTL
+func (h *Handlers) issueTokens(ctx context.Context, w http.ResponseWriter, u storage.User) (tokenResponse, error) {
+	userID := u.ID
+	id := authHelper.Identity{UserID: userID, Registered: true}
+	if h.Cfg.IsAdmin(u.Login) {
+		id.Role = authHelper.RoleAdmin
+	}
+	access, err := h.auth.GenerateTokenFor(id, authHelper.AccessTokenTTL)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refresh, hash, err := authHelper.NewRefreshToken()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	err = h.store.SaveRefreshToken(ctx, storage.RefreshToken{
+		TokenHash: hash,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(authHelper.RefreshTokenTTL),
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	h.auth.SetTokenCookie(w, access, authHelper.AccessTokenTTL)
+	return tokenResponse{
+		UserID:       userID,
+		Role:         id.Role,
+		AccessToken:  strings.TrimPrefix(access, "Bearer "),
+		TokenType:    "Bearer",
+		RefreshToken: refresh,
+		ExpiresIn:    int(authHelper.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// createUser проверяет логин и пароль и заводит учётную запись
+func (h *Handlers) createUser(w http.ResponseWriter, r *http.Request) (storage.User, bool) {
+	c, err := readCredentials(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, err.Error())
+		return storage.User{}, false
+	}
+	var fields []problem.FieldError
+	if c.Login == "" {
+		fields = append(fields, problem.Field("login", "is required"))
+	}
+	if len(c.Password) < minPasswordLen {
+		fields = append(fields, problem.Field("password", fmt.Sprintf("must be at least %d characters", minPasswordLen)))
+	}
+	if len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
+		return storage.User{}, false
+	}
+	hash, err := authHelper.HashPassword(c.Password)
+	if err != nil {
+		problem.Write(w, r, problem.Invalid(problem.Field("password", "must be at most 72 bytes")))
+		return storage.User{}, false
+	}
+	u := storage.User{
+		ID:           uuid.New(),
+		Login:        c.Login,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = h.store.CreateUser(r.Context(), u)
+	if errors.Is(err, storage.ErrUserExists) {
+		problem.Error(w, r, problem.Conflict, "login is already taken")
+		return storage.User{}, false
+	}
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return storage.User{}, false
+	}
+	return u, true
+}
+
+func (h *Handlers) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.createUser(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := h.issueTokens(r.Context(), w, u)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, tokens)
+}
+
+func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := readCredentials(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, err.Error())
+		return
+	}
+	u, err := h.store.GetUserByLogin(r.Context(), c.Login)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		problem.InternalError(w, r, err)
+		return
+	}
+	// на неизвестный логин и неверный пароль отвечаем одинаково и за то же время
+	if err != nil {
+		u.PasswordHash = dummyPasswordHash
+	}
+	if !authHelper.CheckPassword(u.PasswordHash, c.Password) || err != nil {
+		problem.Error(w, r, problem.InvalidCredentials, "")
+		return
+	}
+	tokens, err := h.issueTokens(r.Context(), w, u)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, tokens)
+}
+
+func readRefreshToken(r *http.Request) (string, error) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	rb, err := body.GetBody(r)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(rb, &req); err != nil || req.RefreshToken == "" {
+		return "", problem.Invalid(problem.Field("refresh_token", "is required"))
+	}
+	return req.RefreshToken, nil
+}
+
+// RefreshHandler меняет refresh-токен на новую пару, старый становится недействительным
+func (h *Handlers) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := readRefreshToken(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	userID, err := h.store.ConsumeRefreshToken(r.Context(), authHelper.HashSecret(token))
+	if errors.Is(err, storage.ErrTokenInvalid) {
+		problem.Error(w, r, problem.InvalidToken, "")
+		return
+	}
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	u, err := h.store.GetUser(r.Context(), userID)
+	if err != nil {
+		logger.Logger.Error(err)
+		problem.Error(w, r, problem.InvalidToken, "")
+		return
+	}
+	tokens, err := h.issueTokens(r.Context(), w, u)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, tokens)
+}
+
+func (h *Handlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := readRefreshToken(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	_, err = h.store.ConsumeRefreshToken(r.Context(), authHelper.HashSecret(token))
+	if err != nil && !errors.Is(err, storage.ErrTokenInvalid) {
+		problem.InternalError(w, r, err)
+		return
+	}
+	h.auth.SetTokenCookie(w, "", -time.Hour)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ClaimHandler заводит учётную запись и переносит в неё ссылки анонимной личности из куки
+func (h *Handlers) ClaimHandler(w http.ResponseWriter, r *http.Request) {
+	anon, ok := authHelper.IdentityFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "no anonymous identity to claim")
+		return
+	}
+	if anon.Registered {
+		problem.Error(w, r, problem.Conflict, "identity is already registered")
+		return
+	}
+	u, ok := h.createUser(w, r)
+	if !ok {
+		return
+	}
+	n, err := h.store.TransferURLs(r.Context(), anon.UserID, u.ID)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	tokens, err := h.issueTokens(r.Context(), w, u)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	tokens.Claimed = n
+	writeJSON(w, r, http.StatusCreated, tokens)
+}
+
+func (h *Handlers) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := registeredUser(r)
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "")
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	rb, err := body.GetBody(r)
+	if err != nil {
+		problem.Error(w, r, problem.InvalidBody, "")
+		return
+	}
+	if len(rb) > 0 {
+		if err := json.Unmarshal(rb, &req); err != nil {
+			problem.Error(w, r, problem.InvalidBody, "invalid json")
+			return
+		}
+	}
+	key, hash, err := authHelper.NewAPIKey()
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	k := storage.APIKey{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		KeyHash:   hash,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.store.CreateAPIKey(r.Context(), k); err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	// ключ виден только в этом ответе
+	writeJSON(w, r, http.StatusCreated, apiKeyResponse{ID: k.ID, Name: k.Name, Key: key, CreatedAt: k.CreatedAt})
+}
+
+func (h *Handlers) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := registeredUser(r)
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "")
+		return
+	}
+	keys, err := h.store.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	result := make([]apiKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, apiKeyResponse{ID: k.ID, Name: k.Name, CreatedAt: k.CreatedAt})
+	}
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+func (h *Handlers) DeleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := registeredUser(r)
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "")
+		return
+	}
+	err := h.store.DeleteAPIKey(r.Context(), userID, r.PathValue("id"))
+	switch {
+	case errors.Is(err, storage.ErrKeyNotFound):
+		problem.Error(w, r, problem.APIKeyNotFound, "")
+	case err != nil:
+		problem.InternalError(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	authHelper "github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/body"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"net/http"
+	urlLib "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API v2: ресурс link в /api/v2/links. Успешные ответы завёрнуты в {"data": ...},
+// списки дополнительно содержат pagination, ошибки — problem+json
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	maxMetadataKeys     = 20
+	maxMetadataKeyLen   = 64
+	maxMetadataValueLen = 512
+)
+
+// linkResource — ссылка в API v2
+type linkResource struct {
+	ID          string            `json:"id"`
+	Code        string            `json:"code"`
+	Domain      string            `json:"domain,omitempty"`
+	ShortURL    string            `json:"short_url"`
+	Destination string            `json:"destination"`
+	Owner       string            `json:"owner"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	Metadata    map[string]string `json:"metadata"`
+	Clicks      int               `json:"clicks"`
+}
+
+type envelope struct {
+	Data any `json:"data"`
+}
+
+type listEnvelope struct {
+	Data       any        `json:"data"`
+	Pagination pagination `json:"pagination"`
+}
+
+type pagination struct {
+	Limit   int  `json:"limit"`
+	Offset  int  `json:"offset"`
+	HasMore bool `json:"has_more"`
+	// Next — адрес следующей страницы, если она есть
+	Next string `json:"next,omitempty"`
+}
+
+type createLinkRequest struct {
+	Destination string            `json:"destination"`
+	Domain      string            `json:"domain,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// updateLinkRequest — отсутствующие поля не меняются, пустой metadata удаляет метаданные
+type updateLinkRequest struct {
+	Destination *string           `json:"destination"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// decodeStrict разбирает json и не пропускает неизвестные поля: опечатка
+// в имени поля в v2 — ошибка, а не молча проигнорированное значение
+func decodeStrict(r *http.Request, v any) *problem.Problem {
+	rb, err := body.GetBody(r)
+	if err != nil {
+		return problem.New(problem.InvalidBody, "")
+	}
+	dec := json.NewDecoder(bytes.NewReader(rb))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return problem.New(problem.InvalidBody, err.Error())
+	}
+	return nil
+}
+
+func validateDestination(dest string) []problem.FieldError {
+	if dest == "" {
+		return []problem.FieldError{problem.Field("destination", "is required")}
+	}
+	u, err := urlLib.Parse(dest)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return []problem.FieldError{problem.Field("destination", "must be an http(s) url")}
+	}
+	return nil
+}
+
+func validateMetadata(m map[string]string) []problem.FieldError {
+	if len(m) > maxMetadataKeys {
+		return []problem.FieldError{problem.Field("metadata", fmt.Sprintf("at most %d keys are allowed", maxMetadataKeys))}
+	}
+	var fields []problem.FieldError
+	for k, v := range m {
+		switch {
+		case k == "" || len(k) > maxMetadataKeyLen:
+			fields = append(fields, problem.Field("metadata", fmt.Sprintf("key %q must be 1 to %d bytes long", k, maxMetadataKeyLen)))
+		case len(v) > maxMetadataValueLen:
+			fields = append(fields, problem.Field("metadata."+k, fmt.Sprintf("must be at most %d bytes long", maxMetadataValueLen)))
+		}
+	}
+	return fields
+}
+
+// linkBases запоминает базовые адреса доменов, чтобы не искать домен на каждую ссылку списка
+type linkBases map[string]string
+
+func (h *Handlers) linkBase(ctx context.Context, bases linkBases, domain string) string {
+	if base, ok := bases[domain]; ok {
+		return base
+	}
+	d := storage.Domain{Name: domain}
+	if domain != "" {
+		if found, err := h.store.GetDomain(ctx, domain); err == nil {
+			d = found
+		}
+	}
+	bases[domain] = storage.BaseURL(h.Cfg, d)
+	return bases[domain]
+}
+
+func (h *Handlers) resource(ctx context.Context, bases linkBases, l storage.Link) linkResource {
+	res := linkResource{
+		ID:          l.ID,
+		Code:        l.Code,
+		Domain:      l.Domain,
+		ShortURL:    h.linkBase(ctx, bases, l.Domain) + "/" + l.Code,
+		Destination: l.OriginalURL,
+		Owner:       l.UserID,
+		Metadata:    l.Metadata,
+		Clicks:      l.Clicks,
+	}
+	if !l.CreatedAt.IsZero() {
+		created := l.CreatedAt.UTC()
+		res.CreatedAt = &created
+	}
+	if res.Metadata == nil {
+		res.Metadata = map[string]string{}
+	}
+	return res
+}
+
+func linkLocation(id string) string {
+	return "/api/v2/links/" + urlLib.PathEscape(id)
+}
+
+// ownedLinkByID находит неудалённую ссылку текущего пользователя по id из пути.
+// Чужие ссылки неотличимы от несуществующих
+func (h *Handlers) ownedLinkByID(w http.ResponseWriter, r *http.Request) (storage.Link, bool) {
+	userID, ok := authHelper.UserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "")
+		return storage.Link{}, false
+	}
+	links, err := h.store.SearchLinks(storage.WithPrimary(r.Context()), storage.LinkFilter{
+		ID:             r.PathValue("id"),
+		Owner:          userID.String(),
+		ExcludeDeleted: true,
+		Limit:          1,
+	})
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return storage.Link{}, false
+	}
+	if len(links) == 0 {
+		problem.Error(w, r, problem.LinkNotFound, "")
+		return storage.Link{}, false
+	}
+	return links[0], true
+}
+
+// ListLinksHandler отдаёт ссылки пользователя постранично: ?limit=&offset=&destination=
+func (h *Handlers) ListLinksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authHelper.UserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, problem.Unauthorized, "")
+		return
+	}
+	q := r.URL.Query()
+	var fields []problem.FieldError
+	limit := intParam(q, "limit", &fields)
+	offset := intParam(q, "offset", &fields)
+	if q.Has("limit") && (limit < 1 || limit > maxPageSize) {
+		fields = append(fields, problem.Field("limit", fmt.Sprintf("must be between 1 and %d", maxPageSize)))
+	}
+	if offset < 0 {
+		fields = append(fields, problem.Field("offset", "must not be negative"))
+	}
+	if len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
+		return
+	}
+	if !q.Has("limit") {
+		limit = defaultPageSize
+	}
+
+	// лишняя ссылка показывает, есть ли следующая страница
+	links, err := h.store.SearchLinks(r.Context(), storage.LinkFilter{
+		Owner:          userID.String(),
+		Destination:    q.Get("destination"),
+		ExcludeDeleted: true,
+		Limit:          limit + 1,
+		Offset:         offset,
+	})
+	if err != nil {
+		problem.InternalError(w, r, err)
+		return
+	}
+	page := pagination{Limit: limit, Offset: offset, HasMore: len(links) > limit}
+	if page.HasMore {
+		links = links[:limit]
+		q.Set("limit", strconv.Itoa(limit))
+		q.Set("offset", strconv.Itoa(offset+limit))
+		page.Next = r.URL.Path + "?" + q.Encode()
+	}
+	bases := linkBases{}
+	data := make([]linkResource, 0, len(links))
+	for _, l := range links {
+		data = append(data, h.resource(r.Context(), bases, l))
+	}
+	writeJSON(w, r, http.StatusOK, listEnvelope{Data: data, Pagination: page})
+}
+
+// CreateLinkHandler создаёт ссылку. Если обычная ссылка на этот адрес уже есть,
+// отвечает 409 с её адресом в Location
+func (h *Handlers) CreateLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req createLinkRequest
+	if p := decodeStrict(r, &req); p != nil {
+		problem.Write(w, r, p)
+		return
+	}
+	fields := append(validateDestination(req.Destination), validateMetadata(req.Metadata)...)
+	ctx := r.Context()
+	if req.Domain != "" {
+		d, err := h.store.GetDomain(ctx, strings.ToLower(req.Domain))
+		if err != nil {
+			fields = append(fields, problem.Field("domain", "unknown domain"))
+		}
+		ctx = storage.WithDomain(ctx, d)
+	}
+	if len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
+		return
+	}
+
+	code, err := h.store.AddNewURL(ctx, req.Destination)
+	if err != nil && !errors.Is(err, storage.ErrURLExists) {
+		problem.InternalError(w, r, err)
+		return
+	}
+	// только что созданной ссылки на реплике ещё может не быть
+	link, getErr := h.store.GetLink(storage.WithPrimary(ctx), code)
+	if getErr != nil {
+		problem.InternalError(w, r, getErr)
+		return
+	}
+	if errors.Is(err, storage.ErrURLExists) {
+		// чужая ссылка не раскрывается даже своим id
+		if userID, _ := authHelper.UserIDFromContext(ctx); link.UserID == userID.String() {
+			w.Header().Set("Location", linkLocation(link.ID))
+		}
+		problem.Error(w, r, problem.Conflict, "destination is already shortened")
+		return
+	}
+	// метаданные пишутся отдельно: общий с v1 AddNewURL о них не знает
+	if len(req.Metadata) > 0 {
+		if err := h.store.UpdateLink(ctx, link.ID, storage.LinkUpdate{Metadata: req.Metadata}); err != nil {
+			problem.InternalError(w, r, err)
+			return
+		}
+		link.Metadata = req.Metadata
+	}
+	w.Header().Set("Location", linkLocation(link.ID))
+	writeJSON(w, r, http.StatusCreated, envelope{Data: h.resource(ctx, linkBases{}, link)})
+}
+
+func (h *Handlers) GetLinkHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedLinkByID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, r, http.StatusOK, envelope{Data: h.resource(r.Context(), linkBases{}, link)})
+}
+
+// UpdateLinkHandler меняет адрес назначения и метаданные: PATCH {"destination", "metadata"}.
+// Метаданные заменяются целиком
+func (h *Handlers) UpdateLinkHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedLinkByID(w, r)
+	if !ok {
+		return
+	}
+	var req updateLinkRequest
+	if p := decodeStrict(r, &req); p != nil {
+		problem.Write(w, r, p)
+		return
+	}
+	var fields []problem.FieldError
+	if req.Destination != nil {
+		fields = append(fields, validateDestination(*req.Destination)...)
+		if len(fields) == 0 && link.Options != nil {
+			if err := link.Options.Forwarding.Validate(*req.Destination); err != nil {
+				fields = append(fields, problem.Field("destination", err.Error()))
+			}
+		}
+	}
+	fields = append(fields, validateMetadata(req.Metadata)...)
+	if len(fields) > 0 {
+		problem.Write(w, r, problem.Invalid(fields...))
+		return
+	}
+
+	err := h.store.UpdateLink(r.Context(), link.ID, storage.LinkUpdate{OriginalURL: req.Destination, Metadata: req.Metadata})
+	switch {
+	case errors.Is(err, storage.ErrURLExists):
+		problem.Error(w, r, problem.Conflict, "destination is already shortened by another link")
+		return
+	case err != nil:
+		linkEditError(w, r, err)
+		return
+	}
+	if req.Destination != nil {
+		link.OriginalURL = *req.Destination
+	}
+	if req.Metadata != nil {
+		link.Metadata = req.Metadata
+	}
+	writeJSON(w, r, http.StatusOK, envelope{Data: h.resource(r.Context(), linkBases{}, link)})
+}
+
+// DeleteLinkHandler удаляет ссылку так же, как DELETE /api/user/urls, но сразу
+func (h *Handlers) DeleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedLinkByID(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := authHelper.UserIDFromContext(r.Context())
+	// ссылка найдена по id и может быть на другом домене, чем запрос
+	ctx := storage.WithDomain(r.Context(), storage.Domain{Name: link.Domain})
+	h.store.DeleteURLs(ctx, userID, storage.URLsForDeletion{link.Code})
+	w.WriteHeader(http.StatusNoContent)
+}
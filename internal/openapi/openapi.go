@@ -0,0 +1,70 @@
+// Package openapi — спецификация HTTP API сервиса и страница документации к ней
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"net/http"
+)
+
+// spec — исходник спецификации, его правят вместе с маршрутами в server.newRouter
+//
+//go:embed openapi.yaml
+var spec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+var (
+	doc      *openapi3.T
+	specJSON []byte
+	router   routers.Router
+)
+
+// спецификация зашита в бинарник, поэтому ошибка в ней — ошибка сборки,
+// как и в шаблонах страниц: сервер с ней не стартует
+func init() {
+	var err error
+	doc, err = openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		panic("openapi: load spec: " + err.Error())
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		panic("openapi: invalid spec: " + err.Error())
+	}
+	specJSON, err = json.Marshal(doc)
+	if err != nil {
+		panic("openapi: marshal spec: " + err.Error())
+	}
+	router, err = gorillamux.NewRouter(doc)
+	if err != nil {
+		panic("openapi: build router: " + err.Error())
+	}
+}
+
+// Doc возвращает разобранную спецификацию, менять её нельзя
+func Doc() *openapi3.T {
+	return doc
+}
+
+// Router ищет в спецификации операцию для запроса
+func Router() routers.Router {
+	return router
+}
+
+// SpecHandler отдаёт спецификацию в json
+func SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(specJSON)
+}
+
+// DocsHandler отдаёт страницу документации, которая рисует /openapi.json
+// без внешних скриптов
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
@@ -0,0 +1,126 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	urlLib "net/url"
+	"regexp"
+	"strings"
+)
+
+const MaxDefaultQuery = 20
+
+// Forwarding задаёт, что из запроса к короткой ссылке переносится в адрес назначения
+type Forwarding struct {
+	// ForwardQuery добавляет к адресу параметры запроса, они перекрывают DefaultQuery
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// ForwardPath разрешает путь после кода (/{id}/rest/of/path) и дописывает его к адресу
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// Template включает подстановки {path} и {query.имя} в адресе назначения
+	Template bool `json:"template,omitempty"`
+	// DefaultQuery — параметры по умолчанию (например, utm_*). Параметры,
+	// которые уже есть в адресе назначения, не заменяются
+	DefaultQuery map[string]string `json:"default_query,omitempty"`
+}
+
+func (f Forwarding) IsZero() bool {
+	return !f.ForwardQuery && !f.ForwardPath && !f.Template && len(f.DefaultQuery) == 0
+}
+
+// Validate проверяет настройки и подстановки в адресе назначения dest
+func (f Forwarding) Validate(dest string) error {
+	if len(f.DefaultQuery) > MaxDefaultQuery {
+		return fmt.Errorf("at most %d default_query parameters are allowed", MaxDefaultQuery)
+	}
+	for k := range f.DefaultQuery {
+		if k == "" {
+			return errors.New("default_query parameter name must not be empty")
+		}
+	}
+	if !f.Template {
+		return nil
+	}
+	for _, m := range placeholderRe.FindAllStringSubmatch(dest, -1) {
+		if m[1] != "path" && (!strings.HasPrefix(m[1], "query.") || m[1] == "query.") {
+			return fmt.Errorf("unknown placeholder %q, expected {path} or {query.name}", m[0])
+		}
+	}
+	return nil
+}
+
+var placeholderRe = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Apply строит итоговый адрес: подставляет значения в шаблон, дописывает
+// путь suffix (без ведущего слэша) и объединяет параметры запроса query
+// с DefaultQuery. Если dest не разбирается как url, он возвращается как есть
+func (f Forwarding) Apply(dest, suffix string, query urlLib.Values) string {
+	usedPath := false
+	if f.Template {
+		usedPath = strings.Contains(dest, "{path}")
+		dest = expand(dest, suffix, query)
+	}
+	appendPath := f.ForwardPath && suffix != "" && !usedPath
+	mergeQuery := len(f.DefaultQuery) > 0 || (f.ForwardQuery && len(query) > 0)
+	if !appendPath && !mergeQuery {
+		return dest
+	}
+	u, err := urlLib.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	if appendPath {
+		u = u.JoinPath(escapePath(suffix))
+	}
+	if mergeQuery {
+		q := u.Query()
+		for k, v := range f.DefaultQuery {
+			if !q.Has(k) {
+				q.Set(k, v)
+			}
+		}
+		if f.ForwardQuery {
+			for k, vs := range query {
+				q[k] = vs
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// expand заменяет известные подстановки. Значение экранируется по месту:
+// до '?' как путь, после — как параметр запроса
+func expand(dest, suffix string, query urlLib.Values) string {
+	queryStart := strings.IndexByte(dest, '?')
+	var b strings.Builder
+	last := 0
+	for _, loc := range placeholderRe.FindAllStringSubmatchIndex(dest, -1) {
+		name := dest[loc[2]:loc[3]]
+		var value string
+		switch {
+		case name == "path":
+			value = suffix
+		case strings.HasPrefix(name, "query."):
+			value = query.Get(strings.TrimPrefix(name, "query."))
+		default:
+			continue
+		}
+		b.WriteString(dest[last:loc[0]])
+		if queryStart >= 0 && loc[0] > queryStart {
+			b.WriteString(urlLib.QueryEscape(value))
+		} else {
+			b.WriteString(escapePath(value))
+		}
+		last = loc[1]
+	}
+	b.WriteString(dest[last:])
+	return b.String()
+}
+
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = urlLib.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
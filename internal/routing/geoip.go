@@ -0,0 +1,44 @@
+package routing
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+)
+
+// CountryResolver определяет страну по IP-адресу (ISO 3166 alpha-2),
+// пустая строка — страна неизвестна
+type CountryResolver interface {
+	Country(ip net.IP) string
+}
+
+// GeoIP читает страну из локальной базы MaxMind (GeoLite2-Country или GeoIP2-Country)
+type GeoIP struct {
+	db *maxminddb.Reader
+}
+
+func OpenGeoIP(path string) (*GeoIP, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{db: db}, nil
+}
+
+func (g *GeoIP) Country(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := g.db.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+func (g *GeoIP) Close() error {
+	return g.db.Close()
+}
@@ -0,0 +1,164 @@
+// Package routing выбирает адрес назначения ссылки по правилам:
+// устройству из User-Agent, языку из Accept-Language, стране клиента
+// по базе GeoIP и взвешенному A/B-разбиению, и переносит в него путь
+// и параметры запроса (см. Forwarding)
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	urlLib "net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	MaxRules    = 50
+	MaxVariants = 10
+	// MaxWeight ограничивает вес варианта, чтобы сумма весов не переполнялась
+	MaxWeight = 1_000_000
+)
+
+// Rule срабатывает, если выполнены все непустые условия. Правила проверяются
+// по порядку, первое сработавшее выбирает Destination или вариант из Split
+type Rule struct {
+	Devices   []string `json:"devices,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+
+	Destination string    `json:"destination,omitempty"`
+	Split       []Variant `json:"split,omitempty"`
+}
+
+// Variant — вариант A/B-разбиения, трафик делится пропорционально Weight
+type Variant struct {
+	Destination string `json:"destination"`
+	Weight      int    `json:"weight"`
+}
+
+// Visitor — то, что известно о посетителе
+type Visitor struct {
+	// ID закрепляет посетителя за вариантом разбиения, см. Route
+	ID       string
+	Devices  []string
+	Language string
+	Country  string
+}
+
+// Route возвращает адрес первого сработавшего правила. Вариант разбиения
+// зависит только от посетителя, ссылки и номера правила, поэтому
+// посетитель с тем же ID всегда попадает в тот же вариант
+func Route(rules []Rule, v Visitor, linkID string) (string, bool) {
+	for i, r := range rules {
+		if !r.match(v) {
+			continue
+		}
+		if len(r.Split) == 0 {
+			return r.Destination, true
+		}
+		return r.pick(bucket(v.ID, linkID, i)), true
+	}
+	return "", false
+}
+
+// NeedsVisitorID сообщает, что среди правил есть разбиение и посетителю нужен ID
+func NeedsVisitorID(rules []Rule) bool {
+	return slices.ContainsFunc(rules, func(r Rule) bool { return len(r.Split) > 0 })
+}
+
+func (r Rule) match(v Visitor) bool {
+	if len(r.Devices) > 0 && !slices.ContainsFunc(r.Devices, func(d string) bool { return slices.Contains(v.Devices, d) }) {
+		return false
+	}
+	if len(r.Languages) > 0 && !slices.ContainsFunc(r.Languages, func(l string) bool { return languageMatches(l, v.Language) }) {
+		return false
+	}
+	if len(r.Countries) > 0 && !slices.ContainsFunc(r.Countries, func(c string) bool { return strings.EqualFold(c, v.Country) }) {
+		return false
+	}
+	return true
+}
+
+func (r Rule) pick(n uint64) string {
+	total := 0
+	for _, s := range r.Split {
+		total += s.Weight
+	}
+	point := int(n % uint64(total))
+	for _, s := range r.Split {
+		if point < s.Weight {
+			return s.Destination
+		}
+		point -= s.Weight
+	}
+	return r.Split[len(r.Split)-1].Destination
+}
+
+func bucket(visitorID, linkID string, rule int) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s/%d", visitorID, linkID, rule)
+	return h.Sum64()
+}
+
+// Validate проверяет правила перед сохранением
+func Validate(rules []Rule) error {
+	if len(rules) > MaxRules {
+		return fmt.Errorf("at most %d rules are allowed", MaxRules)
+	}
+	var errs []error
+	for i, r := range rules {
+		if err := r.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r Rule) validate() error {
+	for _, d := range r.Devices {
+		if !slices.Contains(devices, d) {
+			return fmt.Errorf("unknown device %q, expected one of %s", d, strings.Join(devices, ", "))
+		}
+	}
+	for _, l := range r.Languages {
+		if l == "" || strings.ContainsAny(l, " ,;") {
+			return fmt.Errorf("invalid language %q", l)
+		}
+	}
+	for _, c := range r.Countries {
+		if len(c) != 2 {
+			return fmt.Errorf("invalid country %q, expected an ISO 3166 alpha-2 code", c)
+		}
+	}
+	switch {
+	case r.Destination != "" && len(r.Split) > 0:
+		return errors.New("destination and split are mutually exclusive")
+	case r.Destination != "":
+		return validateDestination(r.Destination)
+	case len(r.Split) == 0:
+		return errors.New("destination or split is required")
+	case len(r.Split) > MaxVariants:
+		return fmt.Errorf("at most %d split variants are allowed", MaxVariants)
+	}
+	for _, s := range r.Split {
+		if s.Weight <= 0 {
+			return errors.New("split weights must be positive")
+		}
+		if s.Weight > MaxWeight {
+			return fmt.Errorf("split weights must be at most %d", MaxWeight)
+		}
+		if err := validateDestination(s.Destination); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateDestination(dest string) error {
+	u, err := urlLib.Parse(dest)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("destination %q must be an http(s) url", dest)
+	}
+	return nil
+}
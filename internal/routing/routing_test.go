@@ -0,0 +1,199 @@
+package routing
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	urlLib "net/url"
+	"testing"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Mobile Safari/537.36"
+	desktopUA = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+	botUA     = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+)
+
+func TestDetectDevices(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want []string
+	}{
+		{ua: iPhoneUA, want: []string{DeviceIOS, DeviceMobile}},
+		{ua: androidUA, want: []string{DeviceAndroid, DeviceMobile}},
+		{ua: desktopUA, want: []string{DeviceDesktop}},
+		{ua: botUA, want: []string{DeviceBot}},
+		{ua: "", want: []string{DeviceDesktop}},
+	}
+	for _, test := range tests {
+		t.Run(test.ua, func(t *testing.T) {
+			assert.Equal(t, test.want, DetectDevices(test.ua))
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "de-DE,de;q=0.9,en;q=0.8", want: "de-DE"},
+		{header: "en;q=0.5, fr", want: "fr"},
+		{header: "en;q=0.8, fr;q=0.8", want: "en"},
+		{header: "*, ru;q=0.1", want: "ru"},
+		{header: "de;q=0, en;q=bad", want: ""},
+		{header: "", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			assert.Equal(t, test.want, PreferredLanguage(test.header))
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	rules := []Rule{
+		{Devices: []string{DeviceIOS}, Destination: "https://apps.apple.com/app"},
+		{Languages: []string{"de"}, Destination: "https://example.com/de"},
+		{Countries: []string{"fr"}, Languages: []string{"fr-CA"}, Destination: "https://example.com/ca"},
+		{Countries: []string{"FR"}, Destination: "https://example.com/fr"},
+	}
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{name: "ios wins over language", visitor: Visitor{Devices: []string{DeviceIOS, DeviceMobile}, Language: "de"}, want: "https://apps.apple.com/app"},
+		{name: "language prefix", visitor: Visitor{Devices: []string{DeviceDesktop}, Language: "de-AT"}, want: "https://example.com/de"},
+		{name: "language is not a prefix", visitor: Visitor{Language: "dev"}, want: ""},
+		{name: "all conditions must match", visitor: Visitor{Country: "FR", Language: "fr-FR"}, want: "https://example.com/fr"},
+		{name: "country and exact language", visitor: Visitor{Country: "FR", Language: "fr-ca"}, want: "https://example.com/ca"},
+		{name: "nothing matches", visitor: Visitor{Devices: []string{DeviceAndroid}, Country: "US", Language: "en"}, want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := Route(rules, test.visitor, "link")
+			assert.Equal(t, test.want != "", ok)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestRouteSplit(t *testing.T) {
+	rules := []Rule{{Split: []Variant{
+		{Destination: "https://a.example/", Weight: 3},
+		{Destination: "https://b.example/", Weight: 1},
+	}}}
+	assert.True(t, NeedsVisitorID(rules))
+	assert.False(t, NeedsVisitorID([]Rule{{Destination: "https://a.example/"}}))
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		v := Visitor{ID: fmt.Sprintf("visitor-%d", i)}
+		dest, ok := Route(rules, v, "link")
+		assert.True(t, ok)
+		counts[dest]++
+		// тот же посетитель всегда попадает в тот же вариант
+		again, _ := Route(rules, v, "link")
+		assert.Equal(t, dest, again)
+	}
+	assert.InDelta(t, 3000, counts["https://a.example/"], 200)
+	assert.InDelta(t, 1000, counts["https://b.example/"], 200)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		wantErr string
+	}{
+		{name: "valid", rules: []Rule{{Devices: []string{DeviceAndroid}, Countries: []string{"DE"}, Destination: "https://a.example/"}}},
+		{name: "no rules", rules: nil},
+		{name: "unknown device", rules: []Rule{{Devices: []string{"fridge"}, Destination: "https://a.example/"}}, wantErr: "unknown device"},
+		{name: "bad country", rules: []Rule{{Countries: []string{"DEU"}, Destination: "https://a.example/"}}, wantErr: "invalid country"},
+		{name: "bad language", rules: []Rule{{Languages: []string{"de, en"}, Destination: "https://a.example/"}}, wantErr: "invalid language"},
+		{name: "no target", rules: []Rule{{Devices: []string{DeviceIOS}}}, wantErr: "destination or split is required"},
+		{name: "both targets", rules: []Rule{{Destination: "https://a.example/", Split: []Variant{{Destination: "https://b.example/", Weight: 1}}}}, wantErr: "mutually exclusive"},
+		{name: "zero weight", rules: []Rule{{Split: []Variant{{Destination: "https://b.example/"}}}}, wantErr: "weights must be positive"},
+		// сумма таких весов переполняет int и делит на ноль при выборе варианта
+		{name: "huge weight", rules: []Rule{{Split: []Variant{
+			{Destination: "https://a.example/", Weight: math.MaxInt},
+			{Destination: "https://b.example/", Weight: 1},
+		}}}, wantErr: "weights must be at most"},
+		{name: "unsafe destination", rules: []Rule{{Destination: "javascript:alert(1)"}}, wantErr: "must be an http(s) url"},
+		{name: "error names the rule", rules: []Rule{{Destination: "https://a.example/"}, {Destination: "ftp://b"}}, wantErr: "rule 1:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.rules)
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestForwardingApply(t *testing.T) {
+	utm := map[string]string{"utm_source": "short", "utm_medium": "link"}
+	tests := []struct {
+		name   string
+		f      Forwarding
+		dest   string
+		suffix string
+		query  string
+		want   string
+	}{
+		{name: "nothing to forward", dest: "https://a.example/x?b=2&a=1", suffix: "more", query: "c=3", want: "https://a.example/x?b=2&a=1"},
+		{name: "query", f: Forwarding{ForwardQuery: true}, dest: "https://a.example/x?a=1", query: "c=3&a=2", want: "https://a.example/x?a=2&c=3"},
+		{name: "defaults", f: Forwarding{DefaultQuery: utm}, dest: "https://a.example/", want: "https://a.example/?utm_medium=link&utm_source=short"},
+		{name: "destination keeps its own value", f: Forwarding{DefaultQuery: utm}, dest: "https://a.example/?utm_source=mail", want: "https://a.example/?utm_medium=link&utm_source=mail"},
+		{name: "incoming overrides defaults", f: Forwarding{ForwardQuery: true, DefaultQuery: utm}, dest: "https://a.example/", query: "utm_source=ad&ref=x", want: "https://a.example/?ref=x&utm_medium=link&utm_source=ad"},
+		{name: "path", f: Forwarding{ForwardPath: true}, dest: "https://a.example/docs/", suffix: "guide/intro", want: "https://a.example/docs/guide/intro"},
+		{name: "path keeps trailing slash and escapes", f: Forwarding{ForwardPath: true}, dest: "https://a.example", suffix: "a b/", want: "https://a.example/a%20b/"},
+		{name: "path with query", f: Forwarding{ForwardPath: true, ForwardQuery: true}, dest: "https://a.example/docs?v=1", suffix: "x", query: "q=go", want: "https://a.example/docs/x?q=go&v=1"},
+		{
+			name:   "template",
+			f:      Forwarding{Template: true, ForwardPath: true},
+			dest:   "https://a.example/{path}/view?ref={query.ref}&missing={query.none}",
+			suffix: "team/a b", query: "ref=x%26y",
+			want: "https://a.example/team/a%20b/view?ref=x%26y&missing=",
+		},
+		{name: "unknown placeholder is kept", f: Forwarding{Template: true}, dest: "https://a.example/{other}", want: "https://a.example/{other}"},
+		{name: "template is off", f: Forwarding{ForwardQuery: true}, dest: "https://a.example/{path}", query: "a=1", want: "https://a.example/%7Bpath%7D?a=1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := urlLib.ParseQuery(test.query)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, test.f.Apply(test.dest, test.suffix, query))
+		})
+	}
+}
+
+func TestForwardingValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       Forwarding
+		dest    string
+		wantErr string
+	}{
+		{name: "valid template", f: Forwarding{Template: true}, dest: "https://a.example/{path}?r={query.ref}"},
+		{name: "placeholders ignored without template", dest: "https://a.example/{nope}"},
+		{name: "unknown placeholder", f: Forwarding{Template: true}, dest: "https://a.example/{nope}", wantErr: "unknown placeholder"},
+		{name: "empty query name", f: Forwarding{Template: true}, dest: "https://a.example/?r={query.}", wantErr: "unknown placeholder"},
+		{name: "empty default name", f: Forwarding{DefaultQuery: map[string]string{"": "x"}}, wantErr: "must not be empty"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.f.Validate(test.dest)
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Устройства, которые понимает Rule.Devices
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+var devices = []string{DeviceIOS, DeviceAndroid, DeviceMobile, DeviceDesktop, DeviceBot}
+
+// DetectDevices определяет по User-Agent платформу и тип устройства.
+// Достаточно грубой эвристики: точный разбор User-Agent здесь не нужен
+func DetectDevices(userAgent string) []string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case containsAny(ua, "bot", "crawler", "spider", "slurp", "facebookexternalhit"):
+		return []string{DeviceBot}
+	case containsAny(ua, "iphone", "ipad", "ipod"):
+		return []string{DeviceIOS, DeviceMobile}
+	case strings.Contains(ua, "android"):
+		return []string{DeviceAndroid, DeviceMobile}
+	case containsAny(ua, "mobile", "windows phone"):
+		return []string{DeviceMobile}
+	}
+	return []string{DeviceDesktop}
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreferredLanguage возвращает самый предпочтительный язык из Accept-Language
+// или пустую строку, если заголовка нет
+func PreferredLanguage(header string) string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag: tag, q: q})
+		}
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	// при равном q важен порядок в заголовке
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
+
+// languageMatches сравнивает язык правила с языком посетителя:
+// "de" подходит для "de" и "de-AT", а "de-AT" только для "de-AT"
+func languageMatches(rule, visitor string) bool {
+	if strings.EqualFold(rule, visitor) {
+		return true
+	}
+	return len(visitor) > len(rule) && visitor[len(rule)] == '-' && strings.EqualFold(visitor[:len(rule)], rule)
+}
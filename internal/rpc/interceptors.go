@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	authHelper "github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	pb "github.com/morozoffnor/go-url-shortener/internal/proto"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/pkg/middlewares"
+	"github.com/morozoffnor/go-url-shortener/pkg/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"time"
+)
+
+// AuthorizationKey — ключ метаданных, в котором ходит токен (аналог куки Authorization)
+const AuthorizationKey = "authorization"
+
+// RequestIDKey — ключ метаданных с идентификатором запроса (аналог X-Request-ID)
+const RequestIDKey = "x-request-id"
+
+// методы, которым выдаём новый токен, если клиент пришёл без него
+var provisionIdentity = map[string]bool{
+	pb.Shortener_Shorten_FullMethodName:      true,
+	pb.Shortener_ShortenBatch_FullMethodName: true,
+}
+
+// методы, которые без пользователя не имеют смысла
+var requireIdentity = map[string]bool{
+	pb.Shortener_ListUserURLs_FullMethodName:   true,
+	pb.Shortener_DeleteUserURLs_FullMethodName: true,
+}
+
+func logInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logger.Logger.Infoln(
+		"method", info.FullMethod,
+		"code", status.Code(err),
+		"duration", time.Since(start),
+	)
+	return resp, err
+}
+
+// requestInterceptor кладёт в контекст x-request-id и адрес клиента для журнала аудита
+func requestInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var ri requestid.Info
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDKey); len(values) > 0 && len(values[0]) <= 128 {
+			ri.ID = values[0]
+		}
+	}
+	if ri.ID == "" {
+		ri.ID = uuid.NewString()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ri.ClientIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ri.ClientIP); err == nil {
+			ri.ClientIP = host
+		}
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, ri.ID))
+	return handler(requestid.WithInfo(ctx, ri), req)
+}
+
+// domainInterceptor определяет домен по :authority, как middlewares.Domain по Host
+func domainInterceptor(cfg *config.Config, resolver middlewares.DomainResolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(":authority"); len(values) > 0 {
+				ctx = middlewares.WithHostDomain(ctx, cfg, resolver, values[0])
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authInterceptor ищет пользователя так же, как REST (см. middlewares.Auth): по API-ключу
+// или Bearer-токену. Неверные данные — Unauthenticated для методов, которым нужен пользователь
+func authInterceptor(jwt *authHelper.JWT, keys middlewares.APIKeyResolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		needed := provisionIdentity[info.FullMethod] || requireIdentity[info.FullMethod]
+		id, ok, err := middlewares.IdentifyHeader(ctx, authorization(ctx), jwt, keys)
+		switch {
+		case errors.Is(err, middlewares.ErrBadCredentials) && needed:
+			return nil, status.Error(codes.Unauthenticated, "invalid token or API key")
+		case errors.Is(err, middlewares.ErrBadCredentials):
+			ok = false
+		case err != nil:
+			logger.Logger.Error(err)
+			return nil, status.Error(codes.Internal, "unexpected internal error")
+		}
+		if ok {
+			return handler(authHelper.WithIdentity(ctx, id), req)
+		}
+
+		switch {
+		case provisionIdentity[info.FullMethod]:
+			id, token, err := jwt.NewIdentity()
+			if err != nil {
+				return nil, status.Error(codes.Internal, "error creating token")
+			}
+			if err := grpc.SetHeader(ctx, metadata.Pairs(AuthorizationKey, token)); err != nil {
+				return nil, status.Error(codes.Internal, "error sending token")
+			}
+			return handler(authHelper.WithIdentity(ctx, id), req)
+		case requireIdentity[info.FullMethod]:
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authorization — значение ключа authorization из метаданных, аналог заголовка
+func authorization(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(AuthorizationKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	authHelper "github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	pb "github.com/morozoffnor/go-url-shortener/internal/proto"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+//go:generate buf generate ../..
+
+// Server реализует pb.ShortenerServer поверх того же хранилища, что и REST
+type Server struct {
+	pb.UnimplementedShortenerServer
+	cfg   *config.Config
+	store storage.Storage
+}
+
+// New собирает gRPC-сервер. С tlsConfig (см. server.Server.TLSConfig) он принимает
+// только TLS-соединения с теми же сертификатами, что и HTTPS
+func New(cfg *config.Config, store storage.Storage, jwt *authHelper.JWT, tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(
+		logInterceptor,
+		requestInterceptor,
+		domainInterceptor(cfg, store),
+		authInterceptor(jwt, store),
+	)}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(s, &Server{cfg: cfg, store: store})
+	return s
+}
+
+func (s *Server) Shorten(ctx context.Context, in *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if in.GetUrl() == "" {
+		return nil, status.Error(codes.InvalidArgument, "url is required")
+	}
+	code, err := s.store.AddNewURL(ctx, in.GetUrl())
+	base := storage.BaseURL(s.cfg, storage.DomainFromContext(ctx))
+	if err != nil {
+		if errors.Is(err, storage.ErrURLExists) {
+			return &pb.ShortenResponse{Result: base + "/" + code, Conflict: true}, nil
+		}
+		logger.Logger.Error(err)
+		return nil, status.Error(codes.Internal, "unexpected internal error")
+	}
+	return &pb.ShortenResponse{Result: base + "/" + code}, nil
+}
+
+func (s *Server) ShortenBatch(ctx context.Context, in *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	input := make([]storage.BatchInput, 0, len(in.GetItems()))
+	for _, item := range in.GetItems() {
+		input = append(input, storage.BatchInput{
+			OriginalURL:   item.GetOriginalUrl(),
+			CorrelationID: item.GetCorrelationId(),
+		})
+	}
+	output, err := s.store.AddBatch(ctx, input)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, status.Error(codes.Internal, "unexpected internal error")
+	}
+	resp := &pb.ShortenBatchResponse{Items: make([]*pb.BatchResult, 0, len(output))}
+	for _, o := range output {
+		resp.Items = append(resp.Items, &pb.BatchResult{CorrelationId: o.CorrelationID, ShortUrl: o.ShortURL})
+	}
+	return resp, nil
+}
+
+func (s *Server) Resolve(ctx context.Context, in *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	link, err := s.store.GetLink(ctx, in.GetCode())
+	switch {
+	case errors.Is(err, storage.ErrLinkNotFound):
+		return nil, status.Error(codes.NotFound, "url not found")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return nil, status.FromContextError(err).Err()
+	case err != nil:
+		// сбой хранилища — не повод говорить клиенту, что ссылки нет
+		logger.Logger.Error(err)
+		return nil, status.Error(codes.Unavailable, "storage is unavailable")
+	}
+	// ссылка есть, но больше не работает, как 410 в REST
+	if link.IsDeleted {
+		return nil, status.Error(codes.FailedPrecondition, "url deleted")
+	}
+	if link.Disabled() {
+		return nil, status.Error(codes.FailedPrecondition, "url disabled: "+link.DisabledReason)
+	}
+	now := time.Now()
+	if link.Pending(now) {
+		return nil, status.Error(codes.FailedPrecondition, "url is not active yet")
+	}
+	if link.Expired(now) {
+		return nil, status.Error(codes.FailedPrecondition, "url has expired")
+	}
+	// пароль вводится только в браузере, через gRPC такую ссылку не раскрываем
+	if link.HasPassword() {
+		return nil, status.Error(codes.PermissionDenied, "url is password protected")
+	}
+	// Resolve — такой же переход, как редирект, и тратит лимит переходов
+	if link.Limited() {
+		err := s.store.ConsumeClick(ctx, link.ID)
+		if errors.Is(err, storage.ErrLinkExhausted) {
+			return nil, status.Error(codes.FailedPrecondition, "url click limit reached")
+		}
+		if err != nil {
+			logger.Logger.Error(err)
+			return nil, status.Error(codes.Internal, "unexpected internal error")
+		}
+	}
+	dest := link.OriginalURL
+	// параметры по умолчанию и шаблон применяются как при редиректе без пути и запроса;
+	// правила маршрутизации зависят от браузера посетителя, их в gRPC не выбрать
+	if link.Options != nil {
+		dest = link.Options.Forwarding.Apply(dest, "", nil)
+	}
+	return &pb.ResolveResponse{OriginalUrl: dest}, nil
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userID, _ := authHelper.UserIDFromContext(ctx)
+	urls, err := s.store.GetUserURLs(ctx, userID)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, status.Error(codes.Internal, "unexpected internal error")
+	}
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(urls))}
+	for _, u := range urls {
+		resp.Urls = append(resp.Urls, &pb.UserURL{ShortUrl: u.ShortURL, OriginalUrl: u.OriginalURL})
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteUserURLs(ctx context.Context, in *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	userID, _ := authHelper.UserIDFromContext(ctx)
+	if len(in.GetCodes()) > 0 {
+		s.store.DeleteURLs(ctx, userID, in.GetCodes())
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
@@ -0,0 +1,246 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	pb "github.com/morozoffnor/go-url-shortener/internal/proto"
+	"github.com/morozoffnor/go-url-shortener/internal/routing"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		ServerAddr: ":8080",
+		ResultAddr: "http://localhost:8080",
+		JWTSecret:  "secret",
+	}
+}
+
+func newTestClient(t *testing.T) pb.ShortenerClient {
+	cfg := newTestConfig()
+	return newTestClientWith(t, cfg, storage.NewMemoryStorage(cfg))
+}
+
+func newTestClientWith(t *testing.T, cfg *config.Config, store storage.Storage, opts ...grpc.DialOption) pb.ShortenerClient {
+	return newTestClientTLS(t, cfg, store, nil, append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)...)
+}
+
+func newTestClientTLS(t *testing.T, cfg *config.Config, store storage.Storage, tlsConfig *tls.Config, opts ...grpc.DialOption) pb.ShortenerClient {
+	lis := bufconn.Listen(1024 * 1024)
+	jwt, err := auth.New(cfg)
+	require.NoError(t, err)
+	s := New(cfg, store, jwt, tlsConfig)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn)
+}
+
+func TestShortenAndResolve(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://test.com/"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.False(t, resp.GetConflict())
+	require.NotEmpty(t, header.Get(AuthorizationKey), "token must be issued for a new identity")
+	token := header.Get(AuthorizationKey)[0]
+
+	code := resp.GetResult()[len("http://localhost:8080/"):]
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{Code: code})
+	require.NoError(t, err)
+	assert.Equal(t, "http://test.com/", resolved.GetOriginalUrl())
+
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Code: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	authCtx := metadata.AppendToOutgoingContext(ctx, AuthorizationKey, token)
+	list, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, resp.GetResult(), list.GetUrls()[0].GetShortUrl())
+
+	_, err = client.DeleteUserURLs(authCtx, &pb.DeleteUserURLsRequest{Codes: []string{code}})
+	require.NoError(t, err)
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Code: code})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestShortenBatch(t *testing.T) {
+	client := newTestClient(t)
+	resp, err := client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://one.com/"},
+		{CorrelationId: "2", OriginalUrl: "http://two.com/"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetItems(), 2)
+	assert.Equal(t, "1", resp.GetItems()[0].GetCorrelationId())
+	assert.NotEmpty(t, resp.GetItems()[1].GetShortUrl())
+}
+
+func TestRequireIdentity(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	bad := metadata.AppendToOutgoingContext(ctx, AuthorizationKey, "Bearer garbage")
+	_, err = client.DeleteUserURLs(bad, &pb.DeleteUserURLsRequest{Codes: []string{"x"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	cfg := newTestConfig()
+	store := storage.NewMemoryStorage(cfg)
+	client := newTestClientWith(t, cfg, store)
+	ctx := context.Background()
+
+	userID := uuid.New()
+	key, hash, err := auth.NewAPIKey()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateAPIKey(ctx, storage.APIKey{ID: "k1", UserID: userID, Name: "ci", KeyHash: hash}))
+
+	keyCtx := metadata.AppendToOutgoingContext(ctx, AuthorizationKey, auth.APIKeyScheme+key)
+	var header metadata.MD
+	_, err = client.Shorten(keyCtx, &pb.ShortenRequest{Url: "http://test.com/"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get(AuthorizationKey), "a key holder must not get a new identity")
+	list, err := client.ListUserURLs(keyCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.GetUrls(), 1)
+
+	// неизвестный ключ — как неверный токен в REST
+	bad := metadata.AppendToOutgoingContext(ctx, AuthorizationKey, auth.APIKeyScheme+"sk_unknown")
+	_, err = client.Shorten(bad, &pb.ShortenRequest{Url: "http://test.com/"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// brokenStorage отвечает ошибкой соединения на любой поиск ссылки
+type brokenStorage struct {
+	storage.Storage
+}
+
+func (brokenStorage) GetLink(context.Context, string) (storage.Link, error) {
+	return storage.Link{}, errors.New("connection refused")
+}
+
+func TestResolveStorageError(t *testing.T) {
+	cfg := newTestConfig()
+	client := newTestClientWith(t, cfg, brokenStorage{storage.NewMemoryStorage(cfg)})
+	_, err := client.Resolve(context.Background(), &pb.ResolveRequest{Code: "abc"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestDomainFromAuthority(t *testing.T) {
+	cfg := newTestConfig()
+	store := storage.NewMemoryStorage(cfg)
+	ctx := context.Background()
+	require.NoError(t, store.AddDomain(ctx, storage.Domain{Name: "brand.io", BaseURL: "https://brand.io"}))
+	client := newTestClientWith(t, cfg, store, grpc.WithAuthority("brand.io:443"))
+
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://brand.com/"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(resp.GetResult(), "https://brand.io/"), resp.GetResult())
+	code := strings.TrimPrefix(resp.GetResult(), "https://brand.io/")
+
+	again, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://brand.com/"})
+	require.NoError(t, err)
+	assert.True(t, again.GetConflict())
+	assert.Equal(t, resp.GetResult(), again.GetResult())
+
+	authCtx := metadata.AppendToOutgoingContext(ctx, AuthorizationKey, header.Get(AuthorizationKey)[0])
+	list, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, resp.GetResult(), list.GetUrls()[0].GetShortUrl())
+
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{Code: code})
+	require.NoError(t, err)
+	assert.Equal(t, "http://brand.com/", resolved.GetOriginalUrl())
+	// в домене по умолчанию такого кода нет
+	_, err = newTestClientWith(t, cfg, store).Resolve(ctx, &pb.ResolveRequest{Code: code})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestResolveAppliesForwarding(t *testing.T) {
+	cfg := newTestConfig()
+	store := storage.NewMemoryStorage(cfg)
+	client := newTestClientWith(t, cfg, store)
+	ctx := context.Background()
+
+	code, err := store.AddNewURLWithOptions(ctx, "http://fwd.com/?a=1", storage.LinkOptions{
+		Forwarding: routing.Forwarding{DefaultQuery: map[string]string{"utm_source": "grpc", "a": "2"}},
+	})
+	require.NoError(t, err)
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{Code: code})
+	require.NoError(t, err)
+	assert.Equal(t, "http://fwd.com/?a=1&utm_source=grpc", resolved.GetOriginalUrl())
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	cfg := newTestConfig()
+	store := storage.NewMemoryStorage(cfg)
+	serverTLS := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+
+	client := newTestClientTLS(t, cfg, store, serverTLS,
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "localhost"})))
+	_, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "http://tls.com/"})
+	require.NoError(t, err)
+
+	// открытым текстом TLS-сервер не отвечает
+	plain := newTestClientTLS(t, cfg, store, serverTLS, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	_, err = plain.Shorten(context.Background(), &pb.ShortenRequest{Url: "http://tls.com/"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	parsed, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
@@ -1,31 +1,249 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
 	"github.com/morozoffnor/go-url-shortener/internal/handlers"
+	"github.com/morozoffnor/go-url-shortener/internal/openapi"
 	"github.com/morozoffnor/go-url-shortener/pkg/middlewares"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"golang.org/x/sync/errgroup"
+	"net"
 	"net/http"
+	urlLib "net/url"
+	"time"
 )
 
 func newRouter(h *handlers.Handlers) *chi.Mux {
+	auth := func(policy middlewares.AuthPolicy) func(http.Handler) http.Handler {
+		return middlewares.Auth(h.Auth(), h.Storage(), policy)
+	}
+	timeout := middlewares.Timeout(time.Duration(h.Cfg.HandlerTimeout))
+
 	r := chi.NewRouter()
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, problem.NotFound, "")
+	})
+	r.MethodNotAllowed(methodNotAllowed)
 	r.Use(middlewares.Log)
-	r.Use(middlewares.Auth(h.Cfg))
-	r.Get("/ping", h.PingHandler)
-	r.Get("/{id}", h.FullURLHandler)
-	r.Post("/", middlewares.Compress(h.ShortURLHandler))
-	r.Post("/api/shorten/batch", middlewares.Compress(h.BatchHandler))
-	r.Post("/api/shorten", middlewares.Compress(h.ShortenHandler))
-	r.Get("/api/user/urls", middlewares.Compress(h.GetUserURLsHandler))
-	r.Delete("/api/user/urls", middlewares.Compress(h.DeleteUserURLs))
+	// ID нужен раньше всех, чтобы попасть в любые ответы с ошибкой
+	r.Use(middlewares.RequestID)
+	r.Use(middlewares.ReadBody(middlewares.BodyLimits{
+		MaxBytes:        int64(h.Cfg.MaxRequestBytes),
+		MaxDecodedBytes: int64(h.Cfg.MaxDecodedRequestBytes),
+		MaxRatio:        int64(h.Cfg.MaxDecompressionRatio),
+	}))
+	r.Use(middlewares.Compress)
+	r.Use(middlewares.Domain(h.Cfg, h.Storage()))
+	if requests, responses := h.Cfg.ValidatesOpenAPI(); requests || responses {
+		r.Use(middlewares.OpenAPI(openapi.Router(), middlewares.OpenAPIOptions{Requests: requests, Responses: responses}))
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(timeout, auth(middlewares.AllowAnonymous))
+		r.Get("/ping", h.PingHandler)
+		r.Get("/readyz", h.ReadyHandler)
+		r.Get("/openapi.json", openapi.SpecHandler)
+		r.Get("/docs", openapi.DocsHandler)
+		r.Get("/{id}", h.FullURLHandler)
+		r.Post("/{id}", h.UnlockHandler)
+		r.Post("/api/auth/register", h.RegisterHandler)
+		r.Post("/api/auth/login", h.LoginHandler)
+		r.Post("/api/auth/refresh", h.RefreshHandler)
+		r.Post("/api/auth/logout", h.LogoutHandler)
+	})
+	// создание ссылок выдаёт анонимную личность тем, у кого её ещё нет
+	r.Group(func(r chi.Router) {
+		r.Use(auth(middlewares.ProvisionIdentity))
+		r.With(timeout).Post("/", h.ShortURLHandler)
+		r.With(timeout).Post("/api/shorten", h.ShortenHandler)
+		// пакет может быть большим, у него свой таймаут
+		r.With(middlewares.Timeout(time.Duration(h.Cfg.BatchHandlerTimeout))).Post("/api/shorten/batch", h.BatchHandler)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(timeout, auth(middlewares.RequireIdentity))
+		r.Get("/api/user/urls", h.GetUserURLsHandler)
+		r.Delete("/api/user/urls", h.DeleteUserURLs)
+		r.Post("/api/user/claim", h.ClaimHandler)
+		r.Put("/api/user/urls/{code}/schedule", h.ScheduleHandler)
+		r.Get("/api/user/urls/{code}/rules", h.GetRulesHandler)
+		r.Put("/api/user/urls/{code}/rules", h.PutRulesHandler)
+		r.Put("/api/user/urls/{code}/forwarding", h.ForwardingHandler)
+	})
+	r.Route("/api/v2/links", func(r chi.Router) {
+		r.Use(timeout)
+		r.With(auth(middlewares.ProvisionIdentity)).Post("/", h.CreateLinkHandler)
+		r.Group(func(r chi.Router) {
+			r.Use(auth(middlewares.RequireIdentity))
+			r.Get("/", h.ListLinksHandler)
+			r.Get("/{id}", h.GetLinkHandler)
+			r.Patch("/{id}", h.UpdateLinkHandler)
+			r.Delete("/{id}", h.DeleteLinkHandler)
+		})
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(timeout, auth(middlewares.RequireRegistered))
+		r.Get("/api/user/api-keys", h.ListAPIKeysHandler)
+		r.Post("/api/user/api-keys", h.CreateAPIKeyHandler)
+		r.Delete("/api/user/api-keys/{id}", h.DeleteAPIKeyHandler)
+	})
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(timeout, auth(middlewares.RequireAdmin))
+		r.Get("/domains", h.ListDomainsHandler)
+		r.Post("/domains", h.AddDomainHandler)
+		r.Delete("/domains/{name}", h.DeleteDomainHandler)
+		r.Delete("/domains/{name}/links", h.DeleteDomainLinksHandler)
+		r.Get("/links", h.SearchLinksHandler)
+		r.Post("/links/{id}/disable", h.DisableLinkHandler)
+		r.Delete("/links/{id}/disable", h.EnableLinkHandler)
+		r.Post("/links/{id}/transfer", h.TransferLinkHandler)
+		r.Get("/audit", h.AuditHandler)
+	})
+	// путь после кода ссылки, см. routing.Forwarding
+	r.Group(func(sr chi.Router) {
+		sr.Use(timeout, auth(middlewares.AllowAnonymous), notRouted(r))
+		sr.Get(linkSuffixPattern, h.FullURLHandler)
+		sr.Post(linkSuffixPattern, h.UnlockHandler)
+	})
 	return r
 }
 
-func New(cfg *config.Config, h *handlers.Handlers) *http.Server {
-	s := &http.Server{
-		Addr:    cfg.ServerAddr,
-		Handler: newRouter(h),
+const linkSuffixPattern = "/{id}/*"
+
+// notRouted не пускает в /{id}/* пути, для которых есть другой маршрут с другим
+// методом: chi выбрал бы /{id}/* вместо ответа 405 (например, GET /api/shorten)
+func notRouted(mux *chi.Mux) func(http.Handler) http.Handler {
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, m := range methods {
+				rctx := chi.NewRouteContext()
+				if mux.Match(rctx, m, r.URL.Path) && rctx.RoutePattern() != linkSuffixPattern {
+					methodNotAllowed(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, problem.MethodNotAllowed, r.Method+" is not supported for "+r.URL.Path)
+}
+
+// Server — основной http(s)-сервер и, если включён https, вспомогательный
+// plain http listener, который перенаправляет на https
+type Server struct {
+	main     *http.Server
+	redirect *http.Server
+	tls      bool
+}
+
+func New(cfg *config.Config, h *handlers.Handlers) (*Server, error) {
+	s := &Server{
+		main: &http.Server{
+			Addr:              cfg.ServerAddr,
+			Handler:           newRouter(h),
+			ReadTimeout:       time.Duration(cfg.ReadTimeout),
+			ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+			WriteTimeout:      time.Duration(cfg.WriteTimeout),
+			IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		},
+		tls: cfg.EnableHTTPS,
+	}
+	if !cfg.EnableHTTPS {
+		return s, nil
+	}
+
+	certs, err := loadCertificates(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSSelfSigned, certHosts(cfg))
+	if err != nil {
+		return nil, err
+	}
+	s.main.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if cfg.HTTPRedirectAddr != "" {
+		s.redirect = &http.Server{
+			Addr:              cfg.HTTPRedirectAddr,
+			Handler:           redirectHandler(cfg.ServerAddr),
+			ReadTimeout:       s.main.ReadTimeout,
+			ReadHeaderTimeout: s.main.ReadHeaderTimeout,
+			WriteTimeout:      s.main.WriteTimeout,
+			IdleTimeout:       s.main.IdleTimeout,
+		}
+	}
+	return s, nil
+}
+
+// TLSConfig — настройки TLS основного сервера, nil без HTTPS.
+// Их же использует gRPC-сервер, чтобы не держать второй набор сертификатов
+func (s *Server) TLSConfig() *tls.Config {
+	if s.main.TLSConfig == nil {
+		return nil
+	}
+	return s.main.TLSConfig.Clone()
+}
+
+func certHosts(cfg *config.Config) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if u, err := urlLib.Parse(cfg.BaseURL()); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+	if h, _, err := net.SplitHostPort(cfg.ServerAddr); err == nil && h != "" {
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// ListenAndServe блокируется, пока не остановится любой из listener'ов.
+// Ошибка одного из них закрывает и другой, иначе Wait ждал бы его вечно
+func (s *Server) ListenAndServe() error {
+	g := &errgroup.Group{}
+	stop := func(err error) error {
+		if err != nil {
+			s.close()
+		}
+		return err
+	}
+	if s.redirect != nil {
+		g.Go(func() error {
+			return stop(ignoreClosed(s.redirect.ListenAndServe()))
+		})
+	}
+	g.Go(func() error {
+		if s.tls {
+			// сертификаты уже лежат в TLSConfig
+			return stop(ignoreClosed(s.main.ListenAndServeTLS("", "")))
+		}
+		return stop(ignoreClosed(s.main.ListenAndServe()))
+	})
+	return g.Wait()
+}
+
+func (s *Server) close() {
+	if s.redirect != nil {
+		s.redirect.Close()
+	}
+	s.main.Close()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if s.redirect != nil {
+		errs = append(errs, s.redirect.Shutdown(ctx))
+	}
+	errs = append(errs, s.main.Shutdown(ctx))
+	return errors.Join(errs...)
+}
+
+func ignoreClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
-	return s
+	return err
 }
@@ -0,0 +1,1526 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/morozoffnor/go-url-shortener/internal/audit"
+	"github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/internal/handlers"
+	"github.com/morozoffnor/go-url-shortener/internal/openapi"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/middlewares"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRouter(t *testing.T) (http.Handler, *config.Config) {
+	return newTestRouterWith(t, func(*config.Config) {})
+}
+
+// newTestRouterWith даёт поменять настройки, которые читаются при сборке роутера
+func newTestRouterWith(t *testing.T, configure func(cfg *config.Config)) (http.Handler, *config.Config) {
+	cfg := &config.Config{
+		ServerAddr:  ":8080",
+		ResultAddr:  "http://localhost:8080",
+		JWTSecret:   "secret",
+		AdminLogins: []string{"root"},
+		// все ответы в тестах сверяются со спецификацией
+		OpenAPIValidation: "responses",
+	}
+	configure(cfg)
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	auditor := audit.New(sink)
+	t.Cleanup(func() { auditor.Close() })
+	strg := audit.Wrap(storage.NewMemoryStorage(cfg), auditor)
+	jwt, err := auth.New(cfg)
+	require.NoError(t, err)
+	return newRouter(handlers.New(cfg, strg, jwt, auditor, fakeGeo{"203.0.113.7": "DE"})), cfg
+}
+
+// fakeGeo подменяет базу GeoIP
+type fakeGeo map[string]string
+
+func (g fakeGeo) Country(ip net.IP) string {
+	return g[ip.String()]
+}
+
+// login регистрирует пользователя и возвращает заголовок с его access-токеном
+func login(t *testing.T, h http.Handler, name string) map[string]string {
+	res := do(t, h, http.MethodPost, "localhost:8080", "/api/auth/register", `{"login": "`+name+`", "password": "password123"}`, nil)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+	return map[string]string{"Authorization": "Bearer " + tokens.AccessToken}
+}
+
+func do(t *testing.T, h http.Handler, method, host, target, body string, header map[string]string) *http.Response {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Host = host
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// maxPasswordAttempts совпадает с лимитом неверных паролей в handlers
+const maxPasswordAttempts = 5
+
+func TestDomainRouting(t *testing.T) {
+	router, _ := newTestRouter(t)
+	admin := login(t, router, "root")
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/admin/domains", `{"name": "go.brand.com"}`, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/admin/domains", `{"name": "Go.Brand.com"}`, admin)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	res = do(t, router, http.MethodPost, "go.brand.com", "/", "http://test.com/", nil)
+	short, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.True(t, strings.HasPrefix(string(short), "http://go.brand.com/"), string(short))
+	code := strings.TrimPrefix(string(short), "http://go.brand.com/")
+
+	res = do(t, router, http.MethodGet, "go.brand.com", "/"+code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "http://test.com/", res.Header.Get("Location"))
+
+	// за прокси Host приходит с портом
+	res = do(t, router, http.MethodGet, "go.brand.com:443", "/"+code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+
+	// на домене по умолчанию такого кода нет
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://x.com/", "domain": "go.brand.com"}`, nil)
+	out, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Contains(t, string(out), "http://go.brand.com/")
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://x.com/", "domain": "unknown.com"}`, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = do(t, router, http.MethodDelete, "localhost:8080", "/api/admin/domains/go.brand.com", "", admin)
+	res.Body.Close()
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/admin/domains", "", admin)
+	list, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.JSONEq(t, `[{"name": "go.brand.com", "base_url": "http://go.brand.com"}]`, string(bytes.TrimSpace(list)))
+}
+
+func TestUserAccounts(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	// анонимная ссылка, которую потом заберёт учётная запись
+	res := do(t, router, http.MethodPost, "localhost:8080", "/", "http://anon.com/", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	anon := res.Cookies()[0]
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{name: "short password", target: "/api/auth/register", body: `{"login": "bob", "password": "short"}`, status: http.StatusBadRequest},
+		{name: "no login", target: "/api/auth/register", body: `{"password": "password123"}`, status: http.StatusBadRequest},
+		{name: "register", target: "/api/auth/register", body: `{"login": "bob", "password": "password123"}`, status: http.StatusCreated},
+		{name: "duplicate login", target: "/api/auth/register", body: `{"login": "bob", "password": "password123"}`, status: http.StatusConflict},
+		{name: "wrong password", target: "/api/auth/login", body: `{"login": "bob", "password": "password124"}`, status: http.StatusUnauthorized},
+		{name: "unknown login", target: "/api/auth/login", body: `{"login": "alice", "password": "password123"}`, status: http.StatusUnauthorized},
+		{name: "login", target: "/api/auth/login", body: `{"login": "bob", "password": "password123"}`, status: http.StatusOK},
+		{name: "bad refresh token", target: "/api/auth/refresh", body: `{"refresh_token": "nope"}`, status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := do(t, router, http.MethodPost, "localhost:8080", test.target, test.body, nil)
+			res.Body.Close()
+			assert.Equal(t, test.status, res.StatusCode)
+		})
+	}
+
+	// claim: анонимная кука превращается в учётную запись вместе со ссылками
+	r := httptest.NewRequest(http.MethodPost, "/api/user/claim", strings.NewReader(`{"login": "carol", "password": "password123"}`))
+	r.AddCookie(anon)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Claimed      int    `json:"claimed"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	assert.Equal(t, 1, tokens.Claimed)
+	account := w.Result().Cookies()[0]
+
+	// тот же токен принимается из заголовка Authorization
+	bearer := map[string]string{"Authorization": "Bearer " + tokens.AccessToken}
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", bearer)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", map[string]string{"Authorization": "Bearer x"})
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// refresh-токен одноразовый
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/auth/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/auth/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// анонимной личности API-ключи недоступны
+	r = httptest.NewRequest(http.MethodPost, "/api/user/api-keys", strings.NewReader(`{"name": "ci"}`))
+	r.AddCookie(anon)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/api/user/api-keys", strings.NewReader(`{"name": "ci"}`))
+	r.AddCookie(account)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var key struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &key))
+	require.True(t, strings.HasPrefix(key.Key, auth.APIKeyPrefix))
+
+	// по ключу видны ссылки, перенесённые из анонимной личности
+	apiKey := map[string]string{"Authorization": "ApiKey " + key.Key}
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", apiKey)
+	urls, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(urls), "http://anon.com/")
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", map[string]string{"Authorization": "ApiKey sk_wrong"})
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = do(t, router, http.MethodDelete, "localhost:8080", "/api/user/api-keys/"+key.ID, "", apiKey)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", apiKey)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestAuthPolicies(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/auth/register", `{"login": "dave", "password": "password123"}`, nil)
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/", "http://anon.com/", nil)
+	res.Body.Close()
+	anonCookie := res.Cookies()[0]
+
+	credentials := map[string]func(r *http.Request){
+		"none":       func(r *http.Request) {},
+		"anonymous":  func(r *http.Request) { r.AddCookie(anonCookie) },
+		"registered": func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+tokens.AccessToken) },
+		"bad bearer": func(r *http.Request) { r.Header.Set("Authorization", "Bearer broken") },
+		"bad key":    func(r *http.Request) { r.Header.Set("Authorization", "ApiKey sk_broken") },
+		"bad cookie": func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "Authorization", Value: "x"}) },
+	}
+
+	const (
+		allow     = "allow"
+		provision = "provision"
+		identity  = "identity"
+		account   = "account"
+		admin     = "admin"
+	)
+	// кому отвечаем 401 при каждой политике
+	unauthorized := map[string][]string{
+		allow:     {},
+		provision: {"bad bearer", "bad key"},
+		identity:  {"none", "bad bearer", "bad key", "bad cookie"},
+		account:   {"none", "anonymous", "bad bearer", "bad key", "bad cookie"},
+		admin:     {"none", "bad bearer", "bad key", "bad cookie"},
+	}
+
+	routes := []struct {
+		method string
+		target string
+		body   string
+		policy string
+	}{
+		{http.MethodGet, "/ping", "", allow},
+		{http.MethodGet, "/readyz", "", allow},
+		{http.MethodGet, "/nope", "", allow},
+		{http.MethodPost, "/nope", "password=x", allow},
+		{http.MethodPost, "/api/auth/register", `{"login": "", "password": ""}`, allow},
+		{http.MethodPost, "/api/auth/login", `{"login": "dave", "password": "password123"}`, allow},
+		{http.MethodPost, "/api/auth/refresh", `{}`, allow},
+		{http.MethodPost, "/api/auth/logout", `{"refresh_token": "x"}`, allow},
+		{http.MethodPost, "/", "http://a.com/", provision},
+		{http.MethodPost, "/api/shorten", `{"url": "http://b.com/"}`, provision},
+		{http.MethodPost, "/api/shorten/batch", `[{"correlation_id": "1", "original_url": "http://c.com/"}]`, provision},
+		{http.MethodGet, "/api/user/urls", "", identity},
+		{http.MethodDelete, "/api/user/urls", `[]`, identity},
+		{http.MethodPost, "/api/user/claim", `{"login": "", "password": ""}`, identity},
+		{http.MethodPut, "/api/user/urls/unknown/schedule", `{}`, identity},
+		{http.MethodGet, "/api/user/urls/unknown/rules", "", identity},
+		{http.MethodPut, "/api/user/urls/unknown/rules", `[]`, identity},
+		{http.MethodPut, "/api/user/urls/unknown/forwarding", `{}`, identity},
+		{http.MethodPost, "/api/v2/links", `{"destination": "http://d.com/"}`, provision},
+		{http.MethodGet, "/api/v2/links", "", identity},
+		{http.MethodGet, "/api/v2/links/unknown", "", identity},
+		{http.MethodPatch, "/api/v2/links/unknown", `{}`, identity},
+		{http.MethodDelete, "/api/v2/links/unknown", "", identity},
+		{http.MethodGet, "/api/user/api-keys", "", account},
+		{http.MethodPost, "/api/user/api-keys", `{"name": "ci"}`, account},
+		{http.MethodDelete, "/api/user/api-keys/unknown", "", account},
+		{http.MethodGet, "/api/admin/domains", "", admin},
+		{http.MethodPost, "/api/admin/domains", `{"name": "x.com"}`, admin},
+		{http.MethodDelete, "/api/admin/domains/x.com", "", admin},
+		{http.MethodDelete, "/api/admin/domains/x.com/links", "", admin},
+		{http.MethodGet, "/api/admin/links", "", admin},
+		{http.MethodPost, "/api/admin/links/1/disable", `{"reason": "spam"}`, admin},
+		{http.MethodDelete, "/api/admin/links/1/disable", "", admin},
+		{http.MethodPost, "/api/admin/links/1/transfer", `{"user_id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}`, admin},
+		{http.MethodGet, "/api/admin/audit", "", admin},
+	}
+	for _, route := range routes {
+		for name, apply := range credentials {
+			t.Run(route.method+" "+route.target+" "+name, func(t *testing.T) {
+				r := httptest.NewRequest(route.method, route.target, strings.NewReader(route.body))
+				apply(r)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+
+				want401 := false
+				for _, c := range unauthorized[route.policy] {
+					want401 = want401 || c == name
+				}
+				if want401 {
+					assert.Equal(t, http.StatusUnauthorized, w.Code)
+					return
+				}
+				assert.NotEqual(t, http.StatusUnauthorized, w.Code, w.Body.String())
+				// без роли admin в админку не пускаем
+				if route.policy == admin {
+					assert.Equal(t, http.StatusForbidden, w.Code)
+					return
+				}
+				if route.policy == provision && (name == "none" || name == "bad cookie") {
+					assert.NotEmpty(t, w.Result().Cookies(), "new identity expected")
+				}
+			})
+		}
+	}
+}
+
+func TestAdminModeration(t *testing.T) {
+	router, _ := newTestRouter(t)
+	admin := login(t, router, "root")
+	user := login(t, router, "user")
+	other := login(t, router, "other")
+
+	res := do(t, router, http.MethodGet, "localhost:8080", "/api/admin/links", "", user)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://Spam.example/landing"}`, user)
+	var short struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	code := strings.TrimPrefix(short.Result, "http://localhost:8080/")
+
+	search := func(query string) []storage.Link {
+		res := do(t, router, http.MethodGet, "localhost:8080", "/api/admin/links?"+query, "", admin)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var links []storage.Link
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&links))
+		return links
+	}
+	links := search("destination=spam.example")
+	require.Len(t, links, 1)
+	link := links[0]
+	assert.Equal(t, code, link.Code)
+	assert.Len(t, search("code="+code), 1)
+	assert.Len(t, search("owner="+link.UserID), 1)
+	assert.Empty(t, search("destination=nothing"))
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/admin/links/"+link.ID+"/disable", `{"reason": "court <order>", "legal": true}`, admin)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+	page, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, res.StatusCode)
+	assert.Contains(t, string(page), "court &lt;order&gt;")
+
+	res = do(t, router, http.MethodDelete, "localhost:8080", "/api/admin/links/"+link.ID+"/disable", "", admin)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+
+	// передаём ссылку другому пользователю
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", other)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/auth/login", `{"login": "other", "password": "password123"}`, nil)
+	var tokens struct {
+		UserID string `json:"user_id"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+	res.Body.Close()
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/admin/links/"+link.ID+"/transfer", `{"user_id": "`+tokens.UserID+`"}`, admin)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", other)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/admin/links/missing/transfer", `{"user_id": "`+tokens.UserID+`"}`, admin)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// массовое удаление по домену освобождает домен для удаления
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/admin/domains", `{"name": "go.brand.com"}`, admin)
+	res.Body.Close()
+	for _, u := range []string{"http://a.com/", "http://b.com/"} {
+		res = do(t, router, http.MethodPost, "go.brand.com", "/", u, user)
+		res.Body.Close()
+	}
+	res = do(t, router, http.MethodDelete, "localhost:8080", "/api/admin/domains/go.brand.com/links", "", admin)
+	out, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.JSONEq(t, `{"deleted": 2}`, string(out))
+	res = do(t, router, http.MethodDelete, "localhost:8080", "/api/admin/domains/go.brand.com", "", admin)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+}
+
+func TestAuditLog(t *testing.T) {
+	router, _ := newTestRouter(t)
+	admin := login(t, router, "root")
+	user := login(t, router, "user")
+
+	header := map[string]string{"X-Request-ID": "req-42", "Authorization": user["Authorization"]}
+	res := do(t, router, http.MethodPost, "localhost:8080", "/", "http://audited.com/", header)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "req-42", res.Header.Get("X-Request-ID"))
+
+	query := func(q string) []audit.Event {
+		res := do(t, router, http.MethodGet, "localhost:8080", "/api/admin/audit?"+q, "", admin)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var events []audit.Event
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&events))
+		return events
+	}
+	events := query("action=link.create")
+	require.Len(t, events, 1)
+	assert.Equal(t, "req-42", events[0].RequestID)
+	assert.Equal(t, "192.0.2.1", events[0].ClientIP)
+	assert.Equal(t, "http://audited.com/", events[0].Details["original_url"])
+	assert.Len(t, query("user="+events[0].UserID+"&action=link.create"), 1)
+	assert.Len(t, query("action=user.create"), 2)
+	assert.Empty(t, query("action=link.create&since=2999-01-01T00:00:00Z"))
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/admin/audit?since=yesterday", "", admin)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestProtectedLinks(t *testing.T) {
+	router, _ := newTestRouter(t)
+	admin := login(t, router, "root")
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://docs.internal/", "password": "open sesame"}`, nil)
+	var short struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	code := strings.TrimPrefix(short.Result, "http://localhost:8080/")
+
+	unlock := func(password string) *http.Response {
+		header := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+		return do(t, router, http.MethodPost, "localhost:8080", "/"+code, "password="+password, header)
+	}
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+	page, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(page), `type="password"`)
+	assert.Empty(t, res.Header.Get("Location"))
+
+	res = unlock("wrong")
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = unlock("open+sesame")
+	res.Body.Close()
+	require.Equal(t, http.StatusSeeOther, res.StatusCode)
+	assert.Equal(t, "http://docs.internal/", res.Header.Get("Location"))
+	cookies := res.Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+
+	r := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+	r.AddCookie(cookies[0])
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	// кука одной ссылки не открывает другую
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://docs.internal/", "password": "other"}`, nil)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	other := strings.TrimPrefix(short.Result, "http://localhost:8080/")
+	r = httptest.NewRequest(http.MethodGet, "/"+other, nil)
+	r.AddCookie(&http.Cookie{Name: "link_" + other, Value: cookies[0].Value})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// после серии неверных паролей не пускает даже с верным
+	for i := 0; i < maxPasswordAttempts; i++ {
+		res = unlock("wrong")
+		res.Body.Close()
+	}
+	res = unlock("open+sesame")
+	res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.NotEmpty(t, res.Header.Get("Retry-After"))
+
+	// в админке вместо хэша пароля только признак защиты
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/admin/links?code="+code, "", admin)
+	out, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Contains(t, string(out), `"protected":true`)
+	assert.NotContains(t, string(out), "password_hash")
+}
+
+func TestClickLimitedLinks(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://invite.com/", "max_clicks": -1}`, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://invite.com/", "max_clicks": 1}`, nil)
+	var short struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	code := strings.TrimPrefix(short.Result, "http://localhost:8080/")
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusGone, res.StatusCode)
+
+	// ссылка без лимита на тот же адрес работает как обычно
+	res = do(t, router, http.MethodPost, "localhost:8080", "/", "http://invite.com/", nil)
+	out, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NotEqual(t, short.Result, string(out))
+	for i := 0; i < 3; i++ {
+		res = do(t, router, http.MethodGet, "localhost:8080", strings.TrimPrefix(string(out), "http://localhost:8080"), "", nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	}
+}
+
+func TestScheduledLinks(t *testing.T) {
+	router, _ := newTestRouter(t)
+	owner := login(t, router, "owner")
+	stranger := login(t, router, "stranger")
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	past := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten",
+		`{"url": "http://launch.com/", "not_before": "`+future.Format(time.RFC3339)+`"}`, owner)
+	var short struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	code := strings.TrimPrefix(short.Result, "http://localhost:8080/")
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+	page, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Contains(t, string(page), "Coming soon")
+	assert.Equal(t, future.Format(http.TimeFormat), res.Header.Get("Retry-After"))
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls", "", owner)
+	var listing []storage.UserURLs
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&listing))
+	res.Body.Close()
+	require.Len(t, listing, 1)
+	require.NotNil(t, listing[0].NotBefore)
+	assert.True(t, future.Equal(*listing[0].NotBefore))
+
+	schedule := func(header map[string]string, body string) int {
+		res := do(t, router, http.MethodPut, "localhost:8080", "/api/user/urls/"+code+"/schedule", body, header)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	tests := []struct {
+		name       string
+		header     map[string]string
+		body       string
+		wantStatus int
+		// wantRedirect — ответ на переход по ссылке после изменения окна
+		wantRedirect int
+		location     string
+	}{
+		{
+			name:       "stranger cannot edit",
+			header:     stranger,
+			body:       `{}`,
+			wantStatus: http.StatusNotFound, wantRedirect: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "window ends before it starts",
+			header:     owner,
+			body:       `{"not_before": "` + future.Format(time.RFC3339) + `", "not_after": "` + past.Format(time.RFC3339) + `"}`,
+			wantStatus: http.StatusBadRequest, wantRedirect: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "bad fallback",
+			header:     owner,
+			body:       `{"fallback_url": "javascript:alert(1)"}`,
+			wantStatus: http.StatusBadRequest, wantRedirect: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "fallback before launch",
+			header:     owner,
+			body:       `{"not_before": "` + future.Format(time.RFC3339) + `", "fallback_url": "http://launch.com/teaser"}`,
+			wantStatus: http.StatusOK, wantRedirect: http.StatusFound, location: "http://launch.com/teaser",
+		},
+		{
+			name:       "window is over",
+			header:     owner,
+			body:       `{"not_after": "` + past.Format(time.RFC3339) + `"}`,
+			wantStatus: http.StatusOK, wantRedirect: http.StatusGone,
+		},
+		{
+			name:       "window removed",
+			header:     owner,
+			body:       `{"not_before": null}`,
+			wantStatus: http.StatusOK, wantRedirect: http.StatusTemporaryRedirect, location: "http://launch.com/",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.wantStatus, schedule(test.header, test.body))
+			res := do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", nil)
+			res.Body.Close()
+			assert.Equal(t, test.wantRedirect, res.StatusCode)
+			assert.Equal(t, test.location, res.Header.Get("Location"))
+		})
+	}
+}
+
+func TestRoutingRules(t *testing.T) {
+	router, _ := newTestRouter(t)
+	owner := login(t, router, "owner")
+	stranger := login(t, router, "stranger")
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://app.com/"}`, owner)
+	var short struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	code := strings.TrimPrefix(short.Result, "http://localhost:8080/")
+
+	rules := `[
+		{"devices": ["ios"], "destination": "https://apps.apple.com/app"},
+		{"languages": ["de"], "destination": "http://app.com/de"},
+		{"countries": ["DE"], "destination": "http://app.com/de-store"},
+		{"devices": ["desktop"], "split": [
+			{"destination": "http://app.com/a", "weight": 1},
+			{"destination": "http://app.com/b", "weight": 1}
+		]}
+	]`
+	put := func(header map[string]string, body string) int {
+		res := do(t, router, http.MethodPut, "localhost:8080", "/api/user/urls/"+code+"/rules", body, header)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusNotFound, put(stranger, rules))
+	assert.Equal(t, http.StatusBadRequest, put(owner, `[{"devices": ["fridge"], "destination": "http://app.com/"}]`))
+	assert.Equal(t, http.StatusBadRequest, put(owner, `[{"devices": ["ios"]}]`))
+	require.Equal(t, http.StatusOK, put(owner, rules))
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls/"+code+"/rules", "", owner)
+	var saved []map[string]any
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&saved))
+	res.Body.Close()
+	assert.Len(t, saved, 4)
+
+	visit := func(remoteAddr string, header map[string]string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+		r.Host = "localhost:8080"
+		r.RemoteAddr = remoteAddr
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Result()
+	}
+	const desktop = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		location   string
+	}{
+		{
+			name:     "ios goes to the app store",
+			header:   map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", "Accept-Language": "de"},
+			location: "https://apps.apple.com/app",
+		},
+		{
+			name:     "german speaker",
+			header:   map[string]string{"User-Agent": desktop, "Accept-Language": "fr;q=0.5, de-AT"},
+			location: "http://app.com/de",
+		},
+		{
+			name:       "visitor from germany",
+			remoteAddr: "203.0.113.7:4242",
+			header:     map[string]string{"User-Agent": desktop, "Accept-Language": "en"},
+			location:   "http://app.com/de-store",
+		},
+		{
+			name:     "nothing matches",
+			header:   map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 14)", "Accept-Language": "en"},
+			location: "http://app.com/",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remoteAddr := test.remoteAddr
+			if remoteAddr == "" {
+				remoteAddr = "192.0.2.1:1234"
+			}
+			res := visit(remoteAddr, test.header)
+			res.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+			assert.Equal(t, test.location, res.Header.Get("Location"))
+			assert.Equal(t, "private, no-store", res.Header.Get("Cache-Control"))
+		})
+	}
+
+	t.Run("split is sticky", func(t *testing.T) {
+		seen := map[string]bool{}
+		for i := 0; i < 20; i++ {
+			res := visit("192.0.2.1:1234", map[string]string{"User-Agent": desktop})
+			res.Body.Close()
+			var cookie *http.Cookie
+			for _, c := range res.Cookies() {
+				if c.Name == "visitor_id" {
+					cookie = c
+				}
+			}
+			require.NotNil(t, cookie)
+			first := res.Header.Get("Location")
+			seen[first] = true
+
+			for j := 0; j < 3; j++ {
+				res := visit("192.0.2.1:1234", map[string]string{"User-Agent": desktop, "Cookie": cookie.String()})
+				res.Body.Close()
+				assert.Equal(t, first, res.Header.Get("Location"))
+				assert.Empty(t, res.Cookies())
+			}
+		}
+		assert.Equal(t, map[string]bool{"http://app.com/a": true, "http://app.com/b": true}, seen)
+	})
+
+	assert.Equal(t, http.StatusOK, put(owner, `[]`))
+	res = visit("192.0.2.1:1234", map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"})
+	res.Body.Close()
+	assert.Equal(t, "http://app.com/", res.Header.Get("Location"))
+}
+
+func TestSharedLinkEdits(t *testing.T) {
+	router, _ := newTestRouter(t)
+	owner := login(t, router, "owner")
+	stranger := login(t, router, "stranger")
+
+	shorten := func(header map[string]string) string {
+		res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://shared.com/"}`, header)
+		defer res.Body.Close()
+		var short struct {
+			Result string `json:"result"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+		return strings.TrimPrefix(short.Result, "http://localhost:8080/")
+	}
+	code := shorten(owner)
+	// дедупликация выдаёт ссылку владельца и другому пользователю
+	require.Equal(t, code, shorten(stranger))
+
+	res := do(t, router, http.MethodGet, "localhost:8080", "/api/v2/links", "", owner)
+	var list struct {
+		Data []struct{ ID string }
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+	res.Body.Close()
+	require.Len(t, list.Data, 1)
+	id := list.Data[0].ID
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{name: "destination", method: http.MethodPatch, target: "/api/v2/links/" + id, body: `{"destination": "http://evil.com/"}`},
+		{name: "rules", method: http.MethodPut, target: "/api/user/urls/" + code + "/rules", body: `[{"devices": ["desktop"], "destination": "http://evil.com/"}]`},
+		{name: "forwarding", method: http.MethodPut, target: "/api/user/urls/" + code + "/forwarding", body: `{"forward_query": true, "default_query": {"ref": "evil"}}`},
+		{name: "schedule", method: http.MethodPut, target: "/api/user/urls/" + code + "/schedule", body: `{"not_after": "2000-01-01T00:00:00Z"}`},
+		{name: "metadata", method: http.MethodPatch, target: "/api/v2/links/" + id, body: `{"metadata": {"team": "growth"}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := do(t, router, test.method, "localhost:8080", test.target, test.body, owner)
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusConflict, res.StatusCode)
+			var p problem.Problem
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+			assert.Equal(t, problem.LinkShared.URI, p.Type)
+		})
+	}
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+code, "", map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)"})
+	res.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "http://shared.com/", res.Header.Get("Location"))
+}
+
+func TestQueryForwarding(t *testing.T) {
+	router, _ := newTestRouter(t)
+	owner := login(t, router, "owner")
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten",
+		`{"url": "http://docs.com/v1?lang=en", "forward_query": true, "default_query": {"utm_source": "short", "lang": "de"}}`, owner)
+	var short struct {
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	code := strings.TrimPrefix(short.Result, "http://localhost:8080/")
+
+	forwarding := func(body string) int {
+		res := do(t, router, http.MethodPut, "localhost:8080", "/api/user/urls/"+code+"/forwarding", body, owner)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	tests := []struct {
+		name       string
+		forwarding string
+		target     string
+		wantStatus int
+		location   string
+	}{
+		{
+			name:       "query is merged with defaults",
+			target:     "/" + code + "?utm_source=ad&ref=tw",
+			wantStatus: http.StatusTemporaryRedirect,
+			location:   "http://docs.com/v1?lang=en&ref=tw&utm_source=ad",
+		},
+		{
+			name:       "path suffix needs forward_path",
+			target:     "/" + code + "/guide",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "path suffix",
+			forwarding: `{"forward_path": true}`,
+			target:     "/" + code + "/guide/intro?ref=tw",
+			wantStatus: http.StatusTemporaryRedirect,
+			location:   "http://docs.com/v1/guide/intro?lang=en",
+		},
+		{
+			name:       "suffix cannot climb out",
+			target:     "/" + code + "/a/../../../admin",
+			wantStatus: http.StatusTemporaryRedirect,
+			location:   "http://docs.com/v1/admin?lang=en",
+		},
+		{
+			name:       "settings are replaced",
+			forwarding: `{"forward_query": false}`,
+			target:     "/" + code + "?ref=tw",
+			wantStatus: http.StatusTemporaryRedirect,
+			location:   "http://docs.com/v1?lang=en",
+		},
+		{
+			name:       "suffix is rejected again",
+			target:     "/" + code + "/guide",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.forwarding != "" {
+				require.Equal(t, http.StatusOK, forwarding(test.forwarding))
+			}
+			res := do(t, router, http.MethodGet, "localhost:8080", test.target, "", nil)
+			res.Body.Close()
+			assert.Equal(t, test.wantStatus, res.StatusCode)
+			assert.Equal(t, test.location, res.Header.Get("Location"))
+		})
+	}
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten",
+		`{"url": "http://shop.com/{path}?ref={query.ref}", "template": true, "forward_path": true}`, owner)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&short))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", strings.TrimPrefix(short.Result, "http://localhost:8080")+"/shoes/red?ref=mail%20x", "", nil)
+	res.Body.Close()
+	assert.Equal(t, "http://shop.com/shoes/red?ref=mail+x", res.Header.Get("Location"))
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://shop.com/{paht}", "template": true}`, owner)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// /{id}/* не перехватывает другие маршруты
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/shorten", "", owner)
+	res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/user/urls/"+code+"/nope", "", owner)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestCompression(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	var batch []storage.BatchInput
+	for i := 0; i < 50; i++ {
+		batch = append(batch, storage.BatchInput{CorrelationID: fmt.Sprint(i), OriginalURL: fmt.Sprintf("http://compressed.com/%d", i)})
+	}
+	raw, err := json.Marshal(batch)
+	require.NoError(t, err)
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, err = zw.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	// сжатый запрос, ответ в кодировании с наибольшим q
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten/batch", gzipped.String(), map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+		"Accept-Encoding":  "br;q=0.5, gzip",
+	})
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	zr, err := gzip.NewReader(res.Body)
+	require.NoError(t, err)
+	var out []storage.BatchOutput
+	require.NoError(t, json.NewDecoder(zr).Decode(&out))
+	assert.Len(t, out, 50)
+
+	tests := []struct {
+		name         string
+		header       map[string]string
+		body         string
+		status       int
+		wantEncoding string
+	}{
+		{
+			name:   "short response is not compressed",
+			header: map[string]string{"Accept-Encoding": "gzip"},
+			body:   "http://compressed.com/short",
+			status: http.StatusCreated,
+		},
+		{
+			name:   "unknown request encoding",
+			header: map[string]string{"Content-Encoding": "compress"},
+			body:   "http://compressed.com/short",
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "broken request body",
+			header: map[string]string{"Content-Encoding": "gzip"},
+			body:   "http://compressed.com/short",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := do(t, router, http.MethodPost, "localhost:8080", "/", test.body, test.header)
+			defer res.Body.Close()
+			assert.Equal(t, test.status, res.StatusCode)
+			assert.Equal(t, test.wantEncoding, res.Header.Get("Content-Encoding"))
+		})
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	router, _ := newTestRouterWith(t, func(cfg *config.Config) {
+		cfg.MaxRequestBytes = 1 << 10
+		cfg.MaxDecodedRequestBytes = 64 << 10
+		cfg.MaxDecompressionRatio = 100
+	})
+	gzipped := func(data []byte) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.String()
+	}
+	// помещается в MaxRequestBytes, но разжимается за MaxDecodedRequestBytes
+	tooLargeDecoded := gzipped(make([]byte, 128<<10))
+	require.Less(t, len(tooLargeDecoded), 1<<10)
+
+	tests := []struct {
+		name   string
+		body   string
+		header map[string]string
+		status int
+	}{
+		{name: "plain body", body: "http://limits.com/", status: http.StatusCreated},
+		{name: "compressed body", body: gzipped([]byte("http://limits.com/gzip")), header: map[string]string{"Content-Encoding": "gzip"}, status: http.StatusCreated},
+		{name: "raw body too large", body: "http://limits.com/?q=" + strings.Repeat("a", 1<<10), status: http.StatusRequestEntityTooLarge},
+		{name: "decoded body too large", body: tooLargeDecoded, header: map[string]string{"Content-Encoding": "gzip"}, status: http.StatusRequestEntityTooLarge},
+		{name: "corrupted body", body: gzipped([]byte("http://limits.com/"))[:20], header: map[string]string{"Content-Encoding": "gzip"}, status: http.StatusBadRequest},
+		{name: "unsupported encoding", body: "http://limits.com/", header: map[string]string{"Content-Encoding": "compress"}, status: http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := do(t, router, http.MethodPost, "localhost:8080", "/", test.body, test.header)
+			defer res.Body.Close()
+			assert.Equal(t, test.status, res.StatusCode)
+		})
+	}
+
+	// Content-Length проверяется до чтения тела
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("http://limits.com/"))
+	r.ContentLength = 2 << 10
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestDecompressionBomb(t *testing.T) {
+	router, _ := newTestRouterWith(t, func(cfg *config.Config) {
+		cfg.MaxRequestBytes = 1 << 20
+		cfg.MaxDecodedRequestBytes = 100 << 20
+		cfg.MaxDecompressionRatio = 100
+	})
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(make([]byte, 10<<20))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/", buf.String(), map[string]string{"Content-Encoding": "gzip"})
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
+
+func TestEncodingChain(t *testing.T) {
+	router, _ := newTestRouter(t)
+	// на каждое кодирование сервер заводит декодер, поэтому длинная цепочка
+	// отклоняется до чтения тела
+	res := do(t, router, http.MethodPost, "localhost:8080", "/", "x", map[string]string{"Content-Encoding": "gzip, gzip, gzip"})
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+	assert.NotEmpty(t, res.Header.Get("Accept-Encoding"))
+
+	res = do(t, router, http.MethodPost, "localhost:8080", "/", "x", map[string]string{"Content-Encoding": strings.Repeat("zstd,", 10000)})
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+}
+
+func TestTimeouts(t *testing.T) {
+	cfg := &config.Config{
+		ServerAddr:        ":8080",
+		ReadTimeout:       config.Duration(time.Second),
+		ReadHeaderTimeout: config.Duration(2 * time.Second),
+		WriteTimeout:      config.Duration(3 * time.Second),
+		IdleTimeout:       config.Duration(4 * time.Second),
+	}
+	s, err := New(cfg, handlers.New(cfg, storage.NewMemoryStorage(cfg), nil, nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, s.main.ReadTimeout)
+	assert.Equal(t, 2*time.Second, s.main.ReadHeaderTimeout)
+	assert.Equal(t, 3*time.Second, s.main.WriteTimeout)
+	assert.Equal(t, 4*time.Second, s.main.IdleTimeout)
+
+	// обработчик, не уложившийся в таймаут, получает отменённый контекст, клиент — 503
+	cancelled := make(chan struct{})
+	slow := middlewares.Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	w := httptest.NewRecorder()
+	slow.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), problem.Timeout.URI)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	decode := func(t *testing.T, res *http.Response) problem.Problem {
+		defer res.Body.Close()
+		assert.Equal(t, problem.ContentType, res.Header.Get("Content-Type"))
+		var p problem.Problem
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+		assert.Equal(t, res.StatusCode, p.Status)
+		assert.Equal(t, res.Header.Get(middlewares.RequestIDHeader), p.RequestID)
+		assert.NotEmpty(t, p.Title)
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		header   map[string]string
+		status   int
+		wantType problem.Type
+	}{
+		{name: "unknown code", method: http.MethodGet, target: "/nonexistent", status: http.StatusNotFound, wantType: problem.LinkNotFound},
+		{name: "unknown route", method: http.MethodGet, target: "/api/nope", status: http.StatusNotFound, wantType: problem.NotFound},
+		{name: "wrong method", method: http.MethodGet, target: "/api/shorten", status: http.StatusMethodNotAllowed, wantType: problem.MethodNotAllowed},
+		{name: "malformed json", method: http.MethodPost, target: "/api/shorten/batch", body: "{", status: http.StatusBadRequest, wantType: problem.InvalidBody},
+		{name: "no identity", method: http.MethodGet, target: "/api/user/api-keys", status: http.StatusUnauthorized, wantType: problem.Unauthorized},
+		{name: "bad token", method: http.MethodGet, target: "/api/user/urls", header: map[string]string{"Authorization": "Bearer nope"}, status: http.StatusUnauthorized, wantType: problem.InvalidToken},
+		{name: "wrong password", method: http.MethodPost, target: "/api/auth/login", body: `{"login": "nobody", "password": "password123"}`, status: http.StatusUnauthorized, wantType: problem.InvalidCredentials},
+		{name: "unsupported encoding", method: http.MethodPost, target: "/api/shorten", body: "{}", header: map[string]string{"Content-Encoding": "compress"}, status: http.StatusUnsupportedMediaType, wantType: problem.UnsupportedEncoding},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := do(t, router, test.method, "localhost:8080", test.target, test.body, test.header)
+			assert.Equal(t, test.status, res.StatusCode)
+			p := decode(t, res)
+			assert.Equal(t, test.wantType.URI, p.Type)
+			assert.Equal(t, strings.SplitN(test.target, "?", 2)[0], p.Instance)
+		})
+	}
+
+	t.Run("field errors", func(t *testing.T) {
+		res := do(t, router, http.MethodPost, "localhost:8080", "/api/shorten",
+			`{"url": "http://a.com/", "max_clicks": -1, "fallback_url": "ftp://a.com/"}`, nil)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		p := decode(t, res)
+		assert.Equal(t, problem.ValidationFailed.URI, p.Type)
+		assert.Equal(t, []problem.FieldError{
+			{Field: "max_clicks", Message: "must not be negative"},
+			{Field: "fallback_url", Message: "must be an http(s) url"},
+		}, p.Errors)
+
+		res = do(t, router, http.MethodPost, "localhost:8080", "/api/auth/register", `{"password": "short"}`, nil)
+		p = decode(t, res)
+		assert.Len(t, p.Errors, 2)
+	})
+
+	t.Run("plain text", func(t *testing.T) {
+		// POST / работает с текстом и отвечает текстом
+		res := do(t, router, http.MethodPost, "localhost:8080", "/", "%zz", nil)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+
+		// клиент, который принимает только текст, получает ошибку текстом
+		res = do(t, router, http.MethodGet, "localhost:8080", "/nonexistent", "", map[string]string{"Accept": "text/plain"})
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+		out, _ := io.ReadAll(res.Body)
+		assert.Equal(t, "Link not found\n", string(out))
+	})
+}
+
+func TestAPIv2Links(t *testing.T) {
+	router, _ := newTestRouter(t)
+	owner := login(t, router, "erin")
+	other := login(t, router, "frank")
+
+	type link struct {
+		ID          string            `json:"id"`
+		Code        string            `json:"code"`
+		ShortURL    string            `json:"short_url"`
+		Destination string            `json:"destination"`
+		Owner       string            `json:"owner"`
+		CreatedAt   *time.Time        `json:"created_at"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+	decode := func(t *testing.T, res *http.Response, v any) {
+		defer res.Body.Close()
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+	}
+
+	res := do(t, router, http.MethodPost, "localhost:8080", "/api/v2/links",
+		`{"destination": "http://v2.com/", "metadata": {"campaign": "spring"}}`, owner)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var created struct{ Data link }
+	decode(t, res, &created)
+	l := created.Data
+	assert.Equal(t, "/api/v2/links/"+l.ID, res.Header.Get("Location"))
+	assert.Equal(t, "http://localhost:8080/"+l.Code, l.ShortURL)
+	assert.Equal(t, "http://v2.com/", l.Destination)
+	assert.NotEmpty(t, l.Owner)
+	assert.NotNil(t, l.CreatedAt)
+	assert.Equal(t, map[string]string{"campaign": "spring"}, l.Metadata)
+
+	// v1 и v2 работают с одними и теми же ссылками
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/shorten", `{"url": "http://v2.com/"}`, owner)
+	var v1 struct{ Result string }
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&v1))
+	res.Body.Close()
+	assert.Equal(t, l.ShortURL, v1.Result)
+
+	// повтор владельца получает 409 со ссылкой на свою ссылку
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/v2/links", `{"destination": "http://v2.com/"}`, owner)
+	res.Body.Close()
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, "/api/v2/links/"+l.ID, res.Header.Get("Location"))
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/v2/links/"+l.ID, "", owner)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var got struct{ Data link }
+	decode(t, res, &got)
+	assert.Equal(t, l, got.Data)
+
+	// чужая ссылка не видна
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/v2/links/"+l.ID, "", other)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = do(t, router, http.MethodPatch, "localhost:8080", "/api/v2/links/"+l.ID, `{"destination": "http://v2.com/new", "metadata": {}}`, owner)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var updated struct{ Data link }
+	decode(t, res, &updated)
+	assert.Equal(t, "http://v2.com/new", updated.Data.Destination)
+	assert.Empty(t, updated.Data.Metadata)
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+l.Code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, "http://v2.com/new", res.Header.Get("Location"))
+
+	// другой пользователь получает 409 без данных чужой ссылки и не меняет её метаданные
+	res = do(t, router, http.MethodPatch, "localhost:8080", "/api/v2/links/"+l.ID, `{"metadata": {"campaign": "summer"}}`, owner)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = do(t, router, http.MethodPost, "localhost:8080", "/api/v2/links", `{"destination": "http://v2.com/new", "metadata": {"campaign": "stolen"}}`, other)
+	conflict, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Location"))
+	assert.NotContains(t, string(conflict), l.ID)
+	assert.NotContains(t, string(conflict), l.Owner)
+	assert.NotContains(t, string(conflict), "summer")
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/v2/links/"+l.ID, "", owner)
+	var kept struct{ Data link }
+	decode(t, res, &kept)
+	assert.Equal(t, map[string]string{"campaign": "summer"}, kept.Data.Metadata)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{name: "no destination", method: http.MethodPost, body: `{}`, status: http.StatusBadRequest},
+		{name: "not a url", method: http.MethodPost, body: `{"destination": "v2.com"}`, status: http.StatusBadRequest},
+		{name: "unknown field", method: http.MethodPost, body: `{"url": "http://v2.com/"}`, status: http.StatusBadRequest},
+		{name: "unknown domain", method: http.MethodPost, body: `{"destination": "http://v2.com/", "domain": "nope.com"}`, status: http.StatusBadRequest},
+		{name: "bad patch", method: http.MethodPatch, body: `{"destination": ""}`, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := "/api/v2/links"
+			if test.method == http.MethodPatch {
+				target += "/" + l.ID
+			}
+			res := do(t, router, test.method, "localhost:8080", target, test.body, owner)
+			defer res.Body.Close()
+			assert.Equal(t, test.status, res.StatusCode)
+			assert.Equal(t, problem.ContentType, res.Header.Get("Content-Type"))
+		})
+	}
+
+	// пагинация
+	for i := 0; i < 4; i++ {
+		res := do(t, router, http.MethodPost, "localhost:8080", "/api/v2/links", fmt.Sprintf(`{"destination": "http://v2.com/%d"}`, i), owner)
+		res.Body.Close()
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+	}
+	type page struct {
+		Data       []link
+		Pagination struct {
+			Limit   int
+			Offset  int
+			HasMore bool `json:"has_more"`
+			Next    string
+		}
+	}
+	var codes []string
+	next := "/api/v2/links?limit=2"
+	for next != "" {
+		res := do(t, router, http.MethodGet, "localhost:8080", next, "", owner)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var p page
+		decode(t, res, &p)
+		assert.LessOrEqual(t, len(p.Data), 2)
+		assert.Equal(t, p.Pagination.Next != "", p.Pagination.HasMore)
+		for _, l := range p.Data {
+			codes = append(codes, l.Code)
+		}
+		next = p.Pagination.Next
+	}
+	assert.Len(t, codes, 5)
+	assert.Equal(t, l.Code, codes[0])
+
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/v2/links?limit=1000", "", owner)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// пустой список — пустой массив, а не 204
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/v2/links", "", other)
+	var empty page
+	decode(t, res, &empty)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NotNil(t, empty.Data)
+	assert.Empty(t, empty.Data)
+
+	res = do(t, router, http.MethodDelete, "localhost:8080", "/api/v2/links/"+l.ID, "", owner)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", "/api/v2/links/"+l.ID, "", owner)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = do(t, router, http.MethodGet, "localhost:8080", "/"+l.Code, "", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusGone, res.StatusCode)
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Run("spec covers routes", func(t *testing.T) {
+		router, _ := newTestRouter(t)
+		var routes []string
+		err := chi.Walk(router.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			// в спецификации пути без завершающего слэша, а хвост после кода — параметр
+			if route != "/" {
+				route = strings.TrimSuffix(route, "/")
+			}
+			if route == linkSuffixPattern {
+				route = "/{id}/{path}"
+			}
+			routes = append(routes, method+" "+route)
+			return nil
+		})
+		require.NoError(t, err)
+
+		var documented []string
+		for path, item := range openapi.Doc().Paths.Map() {
+			for method := range item.Operations() {
+				documented = append(documented, method+" "+path)
+			}
+		}
+		assert.ElementsMatch(t, routes, documented)
+	})
+
+	t.Run("spec and docs are served", func(t *testing.T) {
+		router, _ := newTestRouter(t)
+		res := do(t, router, http.MethodGet, "localhost:8080", "/openapi.json", "", nil)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+		var spec struct {
+			OpenAPI string         `json:"openapi"`
+			Paths   map[string]any `json:"paths"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&spec))
+		assert.Equal(t, "3.0.3", spec.OpenAPI)
+		assert.Contains(t, spec.Paths, "/api/v2/links/{id}")
+
+		res = do(t, router, http.MethodGet, "localhost:8080", "/docs", "", nil)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router, _ := newTestRouterWith(t, func(cfg *config.Config) {
+			cfg.OpenAPIValidation = "strict"
+		})
+		// без Content-Type тело не с чем сверить, поэтому строгий режим такие запросы отклоняет
+		res := do(t, router, http.MethodPost, "localhost:8080", "/api/auth/register", `{"login": "alice", "password": "password123"}`, nil)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+		res = do(t, router, http.MethodPost, "localhost:8080", "/api/auth/register", `{"login": "alice", "password": "password123"}`,
+			map[string]string{"Content-Type": "application/json"})
+		defer res.Body.Close()
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var tokens struct {
+			AccessToken string `json:"access_token"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+		header := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + tokens.AccessToken}
+
+		tests := []struct {
+			name   string
+			method string
+			target string
+			body   string
+			want   []problem.FieldError
+		}{
+			{
+				name:   "body",
+				method: http.MethodPost,
+				target: "/api/v2/links",
+				body:   `{"destination": 1}`,
+				want:   []problem.FieldError{{Field: "destination", Message: "value must be a string"}},
+			},
+			{
+				name:   "query",
+				method: http.MethodGet,
+				target: "/api/v2/links?limit=1000",
+				want:   []problem.FieldError{{Field: "limit", Message: "number must be at most 100"}},
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				res := do(t, router, test.method, "localhost:8080", test.target, test.body, header)
+				defer res.Body.Close()
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				var p problem.Problem
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+				assert.Equal(t, problem.ValidationFailed.URI, p.Type)
+				assert.Equal(t, test.want, p.Errors)
+			})
+		}
+
+		res = do(t, router, http.MethodPost, "localhost:8080", "/api/v2/links", `{"destination": `, header)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		var p problem.Problem
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+		assert.Equal(t, problem.InvalidBody.URI, p.Type)
+
+		// валидный запрос проходит как обычно
+		res = do(t, router, http.MethodPost, "localhost:8080", "/api/v2/links", `{"destination": "http://a.com/"}`, header)
+		res.Body.Close()
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		h := middlewares.OpenAPI(openapi.Router(), middlewares.OpenAPIOptions{Responses: true})(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Location", "/somewhere")
+				w.Write([]byte(`{"data": 1}`))
+			}))
+		res := do(t, h, http.MethodGet, "localhost:8080", "/api/v2/links", "", nil)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+		assert.Equal(t, problem.ContentType, res.Header.Get("Content-Type"))
+		assert.Empty(t, res.Header.Get("Location"))
+
+		// маршрутов вне спецификации проверка не касается
+		res = do(t, h, http.MethodGet, "localhost:8080", "/a/b/c", "", nil)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
+
+func TestListenAndServeMainFails(t *testing.T) {
+	// адрес основного сервера уже занят, redirect-сервер должен закрыться вместе с ним
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	s := &Server{
+		main:     &http.Server{Addr: busy.Addr().String()},
+		redirect: &http.Server{Addr: "127.0.0.1:0", Handler: redirectHandler(busy.Addr().String())},
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServe() }()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		s.close()
+		t.Fatal("ListenAndServe did not return after the main listener failed")
+	}
+}
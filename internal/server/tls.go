@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader отдаёт сертификат для каждого TLS-рукопожатия и перечитывает
+// файлы, если они поменялись (например, после продления сертификата)
+type certReloader struct {
+	mu        sync.RWMutex
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+const certCheckInterval = 10 * time.Second
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// staticCert используется, когда самоподписанный сертификат живёт только в памяти
+func staticCert(cert tls.Certificate) *certReloader {
+	return &certReloader{cert: &cert}
+}
+
+func (cr *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading tls certificate: %w", err)
+	}
+	mt, err := cr.filesModTime()
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = mt
+	cr.mu.Unlock()
+	return nil
+}
+
+func (cr *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (cr *certReloader) maybeReload() {
+	if cr.certFile == "" {
+		return
+	}
+	cr.mu.Lock()
+	if time.Since(cr.lastCheck) < certCheckInterval {
+		cr.mu.Unlock()
+		return
+	}
+	cr.lastCheck = time.Now()
+	current := cr.modTime
+	cr.mu.Unlock()
+
+	mt, err := cr.filesModTime()
+	if err != nil || !mt.After(current) {
+		return
+	}
+	// при ошибке продолжаем работать со старым сертификатом
+	if err := cr.load(); err != nil {
+		logger.Logger.Errorw("tls certificate reload failed", "error", err)
+		return
+	}
+	logger.Logger.Infow("tls certificate reloaded", "file", cr.certFile)
+}
+
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.maybeReload()
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// generateSelfSigned выпускает сертификат на год для перечисленных хостов
+func generateSelfSigned(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"go-url-shortener development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// loadCertificates готовит источник сертификатов согласно конфигу.
+// Если файлов нет и разрешён самоподписанный сертификат, он генерируется
+// и сохраняется по указанным путям, а без путей — остаётся в памяти
+func loadCertificates(certFile, keyFile string, selfSigned bool, hosts []string) (*certReloader, error) {
+	if certFile != "" {
+		_, certErr := os.Stat(certFile)
+		_, keyErr := os.Stat(keyFile)
+		missing := errors.Is(certErr, os.ErrNotExist) || errors.Is(keyErr, os.ErrNotExist)
+		if !missing || !selfSigned {
+			return newCertReloader(certFile, keyFile)
+		}
+	}
+	if !selfSigned {
+		return nil, errors.New("no tls certificate configured")
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned(hosts)
+	if err != nil {
+		return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+	}
+	logger.Logger.Warnw("using self-signed tls certificate, do not use in production", "hosts", hosts)
+	if certFile == "" {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		return staticCert(cert), nil
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	return newCertReloader(certFile, keyFile)
+}
+
+// redirectHandler отправляет все запросы по plain http на тот же путь по https
+func redirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
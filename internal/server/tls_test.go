@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadCertificatesSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	cr, err := loadCertificates(certFile, keyFile, true, []string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+	assert.FileExists(t, certFile)
+	assert.FileExists(t, keyFile)
+
+	cert, err := cr.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Contains(t, leaf.DNSNames, "localhost")
+	assert.Len(t, leaf.IPAddresses, 1)
+
+	// подменяем файлы и проверяем, что сертификат перечитывается
+	certPEM, keyPEM, err := generateSelfSigned([]string{"example.com"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0644))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	cr.lastCheck = time.Time{}
+
+	cert, err = cr.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, leaf.DNSNames)
+}
+
+func TestLoadCertificatesErrors(t *testing.T) {
+	_, err := loadCertificates("", "", false, nil)
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	_, err = loadCertificates(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), false, nil)
+	assert.Error(t, err)
+
+	cr, err := loadCertificates("", "", true, []string{"localhost"})
+	require.NoError(t, err)
+	cert, err := cr.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		host      string
+		target    string
+		want      string
+	}{
+		{name: "custom port", httpsAddr: ":8443", host: "example.com:8080", target: "/abc?x=1", want: "https://example.com:8443/abc?x=1"},
+		{name: "default port", httpsAddr: ":443", host: "example.com", target: "/", want: "https://example.com/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			r.Host = test.host
+			w := httptest.NewRecorder()
+			redirectHandler(test.httpsAddr).ServeHTTP(w, r)
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, test.want, w.Header().Get("Location"))
+		})
+	}
+}
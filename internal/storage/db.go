@@ -3,9 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -15,7 +13,10 @@ import (
 	"github.com/morozoffnor/go-url-shortener/pkg/chargen"
 	"github.com/morozoffnor/go-url-shortener/pkg/logger"
 	"log"
+	"math/rand/v2"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -24,42 +25,156 @@ import (
 type Database struct {
 	conn *pgxpool.Pool
 	cfg  *config.Config
+	// replicas обслуживают часть чтений, next выбирает их по кругу
+	replicas []*pgxpool.Pool
+	next     atomic.Uint64
+	// ready выставляется, когда база доступна и миграции применены
+	ready     atomic.Bool
+	connected chan struct{}
+	err       error
 }
 
-func NewDatabase(cfg *config.Config, ctx context.Context) *Database {
+// maxConnectBackoff ограничивает паузу между попытками подключения
+const maxConnectBackoff = 30 * time.Second
+
+// NewDatabase ждёт базу (cfg.DBConnectRetries повторов) и применяет миграции.
+// С cfg.DegradedStart возвращается сразу и подключается в фоне: до этого
+// Ping возвращает false, а о неустранимой ошибке сообщает Err
+func NewDatabase(cfg *config.Config, ctx context.Context) (*Database, error) {
+	conn, err := newPool(ctx, cfg, cfg.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
 	db := &Database{
-		cfg: cfg,
+		conn:      conn,
+		cfg:       cfg,
+		connected: make(chan struct{}),
+	}
+	// реплики подключаются лениво, их недоступность при старте не мешает
+	for i, dsn := range cfg.DBReplicaDSNs {
+		replica, err := newPool(ctx, cfg, dsn)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("database replica %d: %w", i, err)
+		}
+		db.replicas = append(db.replicas, replica)
+	}
+	if cfg.DegradedStart {
+		logger.Logger.Warn("starting in degraded mode, connecting to the database in background")
+		go db.connect(ctx, -1)
+		return db, nil
+	}
+	if err := db.connect(ctx, cfg.DBConnectRetries); err != nil {
+		db.Close()
+		return nil, err
 	}
-	conn, err := pgxpool.New(ctx, cfg.DatabaseDSN)
+	return db, nil
+}
+
+// newPool задаёт настройки пула до его создания: после pgxpool.New
+// изменения в Config() уже ни на что не влияют
+func newPool(ctx context.Context, cfg *config.Config, dsn string) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	conn.Config().MaxConns = 20
-	conn.Config().MinConns = 2
-	db.conn = conn
-	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	doMigrations(cfg)
-	//err = db.createTable(ctx)
-	//if err != nil {
-	//	panic(err)
-	//}
-	return db
+	if cfg.DBMaxConns > 0 {
+		poolCfg.MaxConns = int32(cfg.DBMaxConns)
+	}
+	if cfg.DBMinConns > 0 {
+		poolCfg.MinConns = int32(cfg.DBMinConns)
+	}
+	if cfg.DBMaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = time.Duration(cfg.DBMaxConnLifetime)
+	}
+	if cfg.DBMaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = time.Duration(cfg.DBMaxConnIdleTime)
+	}
+	if cfg.DBHealthCheckPeriod > 0 {
+		poolCfg.HealthCheckPeriod = time.Duration(cfg.DBHealthCheckPeriod)
+	}
+	return pgxpool.NewWithConfig(ctx, poolCfg)
 }
 
-func doMigrations(cfg *config.Config) {
-	m, err := migrate.New("file://internal/storage/migrations", cfg.DatabaseDSN)
+func (d *Database) Close() {
+	d.conn.Close()
+	for _, r := range d.replicas {
+		r.Close()
+	}
+}
 
-	if err != nil {
-		panic(err)
+// connect ждёт базу и применяет миграции, retries < 0 — без ограничения попыток
+func (d *Database) connect(ctx context.Context, retries int) error {
+	err := d.waitForDB(ctx, retries)
+	if err == nil && d.cfg.AutoMigrate {
+		if err = MigrateUp(d.cfg.DatabaseDSN); err != nil {
+			err = fmt.Errorf("applying migrations, see shortener migrate status: %w", err)
+		}
+	}
+	d.err = err
+	d.ready.Store(err == nil)
+	close(d.connected)
+	if err == nil {
+		logger.Logger.Info("database is ready")
+	}
+	return err
+}
+
+func (d *Database) waitForDB(ctx context.Context, retries int) error {
+	base := time.Duration(d.cfg.DBConnectBackoff)
+	for attempt := 0; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := d.conn.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if retries >= 0 && attempt >= retries {
+			return fmt.Errorf("database is not available after %d attempts: %w", attempt+1, err)
+		}
+		delay := backoff(base, attempt)
+		logger.Logger.Warnw("database is not available, retrying",
+			"attempt", attempt+1, "retry_in", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff удваивает паузу с каждой попыткой до maxConnectBackoff и берёт
+// случайное значение от половины до полной паузы, чтобы несколько
+// экземпляров сервиса не переподключались к базе одновременно
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := maxConnectBackoff
+	if attempt < 32 {
+		if exp := base << attempt; exp > 0 && exp < d {
+			d = exp
+		}
 	}
-	err = m.Up()
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		panic(err)
+	return d/2 + rand.N(d/2+1)
+}
+
+// Connected закрывается, когда подключение завершилось, успешно или нет
+func (d *Database) Connected() <-chan struct{} {
+	return d.connected
+}
+
+// Err возвращает ошибку подключения после закрытия Connected
+func (d *Database) Err() error {
+	select {
+	case <-d.connected:
+		return d.err
+	default:
+		return nil
 	}
 }
 
 func (d *Database) Ping(ctx context.Context) bool {
+	if !d.ready.Load() {
+		return false
+	}
 	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 	if err := d.conn.Ping(ctx); err != nil {
@@ -68,6 +183,44 @@ func (d *Database) Ping(ctx context.Context) bool {
 	return true
 }
 
+// withTimeout ограничивает время запроса к базе, 0 — без ограничения
+func withTimeout(ctx context.Context, timeout config.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout))
+}
+
+// querier — общее у основного пула и реплик
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// replica возвращает следующую реплику по кругу или nil, если реплик нет
+func (d *Database) replica() *pgxpool.Pool {
+	if len(d.replicas) == 0 {
+		return nil
+	}
+	return d.replicas[(d.next.Add(1)-1)%uint64(len(d.replicas))]
+}
+
+// readReplica выполняет чтение на реплике. Если реплика недоступна или ещё
+// не получила строку (отставание репликации), read повторяется на основной базе.
+// С WithPrimary реплики не используются
+func (d *Database) readReplica(ctx context.Context, read func(q querier) error) error {
+	if r := d.replica(); r != nil && !primaryFromContext(ctx) {
+		err := read(r)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger.Logger.Warnw("replica read failed, falling back to primary", "error", err)
+		}
+	}
+	return read(d.conn)
+}
+
 // Оставлю тут на случай, если автотесты будут ругаться на создание таблицы
 func (d *Database) createTable(ctx context.Context) error {
 	tx, err := d.conn.Begin(ctx)
@@ -90,122 +243,224 @@ func (d *Database) createTable(ctx context.Context) error {
 	return tx.Commit(ctx)
 }
 
+// upsertURL вставляет обычную ссылку или возвращает уже существующую ссылку
+// на тот же адрес (см. urlHash). DO UPDATE отмечает ссылку, выданную не владельцу,
+// и, в отличие от DO NOTHING, возвращает конфликтующую строку, поэтому хватает одного запроса
+const upsertURL = `INSERT INTO urls (id, domain, full_url, full_url_hash, short_url, user_id) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (domain, full_url_hash) WHERE options IS NULL
+	DO UPDATE SET shared = urls.shared OR urls.user_id <> EXCLUDED.user_id
+	RETURNING id, short_url`
+
+// maxCodeAttempts — сколько раз пробовать новый случайный код, если он уже занят
+const maxCodeAttempts = 3
+
 func (d *Database) AddNewURL(ctx context.Context, fullURL string) (string, error) {
-	tx, err := d.conn.Begin(ctx)
-	if err != nil {
-		return "", err
-	}
-	shortURL := chargen.CreateRandomCharSeq()
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	domain := DomainFromContext(ctx).Name
+	userID, _ := auth.UserIDFromContext(ctx)
 	id := uuid.NewString()
-
-	query := `INSERT INTO urls (id, full_url, short_url, user_id, is_deleted) VALUES ($1, $2, $3, $4, $5)`
-	_, err = tx.Exec(ctx, query, id, fullURL, shortURL, ctx.Value(auth.ContextUserID), false)
+	hash := urlHash(fullURL)
+	var gotID, shortURL string
+	err := withCode(func(code string) error {
+		return d.conn.QueryRow(ctx, upsertURL, id, domain, fullURL, hash[:], code, userID.String()).Scan(&gotID, &shortURL)
+	})
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			log.Print("URL already exists")
-			short, _ := d.getShortURL(ctx, fullURL)
-			_ = tx.Commit(ctx)
-			return short, pgErr
-		}
 		return "", err
 	}
-	err = tx.Commit(ctx)
-	if err != nil {
-		log.Print(err)
-		return "", err
+	if gotID != id {
+		return shortURL, ErrURLExists
 	}
 	return shortURL, nil
 }
 
-func (d *Database) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
-	var fullURL string
-	var isDeleted bool
-	query := `SELECT full_url, is_deleted FROM urls WHERE short_url=$1`
-	err := d.conn.QueryRow(ctx, query, shortURL).Scan(&fullURL, &isDeleted)
-	if err != nil {
-		return "", false, err
+// AddNewURLWithOptions не конфликтует с обычными ссылками:
+// уникальность адреса проверяется только для ссылок без настроек
+func (d *Database) AddNewURLWithOptions(ctx context.Context, fullURL string, opts LinkOptions) (string, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	if opts.orNil() == nil {
+		return d.AddNewURL(ctx, fullURL)
 	}
-	return fullURL, isDeleted, nil
-}
-
-func (d *Database) getShortURL(ctx context.Context, fullURL string) (string, error) {
+	userID, _ := auth.UserIDFromContext(ctx)
+	hash := urlHash(fullURL)
 	var shortURL string
-	query := `SELECT short_url FROM urls WHERE full_url=$1`
-	err := d.conn.QueryRow(ctx, query, fullURL).Scan(&shortURL)
+	err := withCode(func(code string) error {
+		shortURL = code
+		_, err := d.conn.Exec(ctx, `INSERT INTO urls (id, domain, full_url, full_url_hash, short_url, user_id, options)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			uuid.NewString(), DomainFromContext(ctx).Name, fullURL, hash[:], code, userID.String(), opts)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 	return shortURL, nil
 }
 
+// withCode повторяет вставку с новым кодом, если случайный код уже занят
+func withCode(insert func(code string) error) error {
+	var err error
+	for range maxCodeAttempts {
+		if err = insert(chargen.CreateRandomCharSeq()); !isCodeTaken(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func isCodeTaken(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "urls_domain_short_url_key"
+}
+
 func (d *Database) AddBatch(ctx context.Context, urls []BatchInput) ([]BatchOutput, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBBatchTimeout)
+	defer cancel()
 	if len(urls) < 1 {
 		return []BatchOutput{}, nil
 	}
 
-	batch := &pgx.Batch{}
-	var result []BatchOutput
-	for _, v := range urls {
-		if short, _ := d.getShortURL(ctx, v.OriginalURL); short != "" {
-			result = append(result, BatchOutput{
-				ShortURL:      d.cfg.ResultAddr + "/" + short,
-				CorrelationID: v.CorrelationID,
-			})
-			continue
+	dom := DomainFromContext(ctx)
+	var links map[string]batchLink
+	var err error
+	for range maxCodeAttempts {
+		if links, err = d.addBatch(ctx, dom.Name, urls); !isUniqueViolation(err) {
+			break
 		}
-		shortURL := chargen.CreateRandomCharSeq()
-		id := uuid.NewString()
-
-		batch.Queue("INSERT INTO urls (id, full_url, short_url, user_id) VALUES ($1, $2, $3, $4)", id, v.OriginalURL, shortURL, ctx.Value(auth.ContextUserID))
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	base := BaseURL(d.cfg, dom)
+	result := make([]BatchOutput, 0, len(urls))
+	for _, v := range urls {
+		l := links[v.OriginalURL]
 		result = append(result, BatchOutput{
-			ShortURL:      d.cfg.ResultAddr + "/" + shortURL,
+			ShortURL:      base + "/" + l.code,
 			CorrelationID: v.CorrelationID,
+			ID:            l.id,
+			Created:       l.created,
 		})
+		// повтор адреса внутри пачки уже ничего не создаёт
+		l.created = false
+		links[v.OriginalURL] = l
 	}
-	br := d.conn.SendBatch(ctx, batch)
-	defer br.Close()
 	return result, nil
+}
 
+type batchLink struct {
+	id, code string
+	created  bool
 }
 
-func (d *Database) GetUserURLs(ctx context.Context, userID uuid.UUID) ([]UserURLs, error) {
-	if len(userID) == 0 {
-		return nil, nil
+// addBatch одним запросом находит уже сокращённые адреса, а новые записывает
+// через COPY и возвращает ссылки по адресам. Если параллельный запрос успел
+// вставить тот же адрес или занять код, COPY падает целиком с 23505,
+// и AddBatch повторяет пачку заново
+func (d *Database) addBatch(ctx context.Context, domain string, urls []BatchInput) (map[string]batchLink, error) {
+	full := make([]string, 0, len(urls))
+	hashes := make([][]byte, 0, len(urls))
+	for _, v := range urls {
+		hash := urlHash(v.OriginalURL)
+		full = append(full, v.OriginalURL)
+		hashes = append(hashes, hash[:])
 	}
-
-	var result []UserURLs
-	rows, err := d.conn.Query(ctx, "SELECT short_url, full_url FROM urls WHERE user_id = $1", userID)
+	userID, _ := auth.UserIDFromContext(ctx)
+	// уже сокращённые адреса отмечаются так же, как в upsertURL
+	rows, err := d.conn.Query(ctx, `UPDATE urls SET shared = shared OR user_id <> $3
+		WHERE domain = $1 AND full_url_hash = ANY($2) AND options IS NULL
+		RETURNING full_url, short_url, id`,
+		domain, hashes, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	links := make(map[string]batchLink, len(urls))
+	var fullURL, shortURL, id string
+	_, err = pgx.ForEachRow(rows, []any{&fullURL, &shortURL, &id}, func() error {
+		links[fullURL] = batchLink{id: id, code: shortURL}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var row UserURLs
-		err := rows.Scan(&row.ShortURL, &row.OriginalURL)
-		if err != nil {
-			return nil, err
+	var created [][]any
+	for i, u := range full {
+		// повторы внутри пачки получают один код
+		if _, ok := links[u]; ok {
+			continue
 		}
-		row.ShortURL = d.cfg.ResultAddr + "/" + row.ShortURL
+		l := batchLink{id: uuid.NewString(), code: chargen.CreateRandomCharSeq(), created: true}
+		links[u] = l
+		created = append(created, []any{l.id, domain, u, hashes[i], l.code, userID.String()})
+	}
+	if len(created) == 0 {
+		return links, nil
+	}
+	_, err = d.conn.CopyFrom(ctx, pgx.Identifier{"urls"},
+		[]string{"id", "domain", "full_url", "full_url_hash", "short_url", "user_id"}, pgx.CopyFromRows(created))
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
 
-		result = append(result, row)
+func (d *Database) GetUserURLs(ctx context.Context, userID uuid.UUID) ([]UserURLs, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	if len(userID) == 0 {
+		return nil, nil
 	}
 
-	err = rows.Err()
+	var result []UserURLs
+	err := d.readReplica(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `SELECT u.short_url, u.full_url, u.domain, COALESCE(d.base_url, ''), u.options
+			FROM urls u LEFT JOIN domains d ON d.name = u.domain WHERE u.user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		// при повторе на основной базе список собирается заново
+		result = nil
+		for rows.Next() {
+			var row UserURLs
+			var dom Domain
+			var opts *LinkOptions
+			err := rows.Scan(&row.ShortURL, &row.OriginalURL, &dom.Name, &dom.BaseURL, &opts)
+			if err != nil {
+				return err
+			}
+			if opts != nil {
+				row.NotBefore, row.NotAfter = opts.NotBefore, opts.NotAfter
+			}
+			row.ShortURL = BaseURL(d.cfg, dom) + "/" + row.ShortURL
+
+			result = append(result, row)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return result, err
+	return result, nil
 }
 
-func (d *Database) DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) {
+func (d *Database) DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) URLsForDeletion {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBBatchTimeout)
+	defer cancel()
 	input := d.generator(ctx, userID, urls)
 	out := d.fanOut(ctx, input)
 	in := d.fanIn(ctx, out)
-	d.softDeleteURLs(ctx, in)
+	return d.softDeleteURLs(ctx, in)
 }
 
 func (d *Database) generator(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) chan DeleteURLItem {
@@ -218,6 +473,7 @@ func (d *Database) generator(ctx context.Context, userID uuid.UUID, urls URLsFor
 			item := DeleteURLItem{
 				UserID:   userID,
 				ShortURL: v,
+				Domain:   DomainFromContext(ctx).Name,
 			}
 			log.Print("gen", item)
 			select {
@@ -239,7 +495,8 @@ func (d *Database) fanOut(ctx context.Context, inputCh <-chan DeleteURLItem) cha
 
 		for item := range inputCh {
 			var id string
-			row := d.conn.QueryRow(ctx, "SELECT id FROM public.urls WHERE short_url = $1 AND user_id = $2", item.ShortURL, item.UserID)
+			row := d.conn.QueryRow(ctx, "SELECT id FROM public.urls WHERE short_url = $1 AND user_id = $2 AND domain = $3",
+				item.ShortURL, item.UserID, item.Domain)
 			err := row.Scan(&id)
 			if err != nil {
 				logger.Logger.Error(err)
@@ -288,22 +545,349 @@ func (d *Database) fanIn(ctx context.Context, ids ...chan string) chan string {
 	return delCh
 }
 
-func (d *Database) softDeleteURLs(ctx context.Context, delCh chan string) {
+func (d *Database) softDeleteURLs(ctx context.Context, delCh chan string) URLsForDeletion {
 	var idsForDeletion []string
 	for item := range delCh {
 		idsForDeletion = append(idsForDeletion, item)
 	}
 
 	if len(idsForDeletion) == 0 {
-		return
+		return nil
 	}
 
-	batch := &pgx.Batch{}
-	for _, item := range idsForDeletion {
-		log.Print("batch ", item)
-		batch.Queue("UPDATE urls SET is_deleted = true WHERE id = $1", item)
+	// RETURNING отдаёт только строки, которые действительно поменялись
+	rows, err := d.conn.Query(ctx, "UPDATE urls SET is_deleted = true WHERE id = ANY($1) AND NOT is_deleted RETURNING short_url", idsForDeletion)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil
+	}
+	codes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		logger.Logger.Error(err)
 	}
+	return codes
+}
 
-	br := d.conn.SendBatch(ctx, batch)
-	defer br.Close()
+func (d *Database) AddDomain(ctx context.Context, dom Domain) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	_, err := d.conn.Exec(ctx, "INSERT INTO domains (name, base_url) VALUES ($1, $2)", dom.Name, dom.BaseURL)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDomainExists
+	}
+	return err
+}
+
+func (d *Database) GetDomain(ctx context.Context, name string) (Domain, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	var dom Domain
+	err := d.conn.QueryRow(ctx, "SELECT name, base_url FROM domains WHERE name = $1", name).Scan(&dom.Name, &dom.BaseURL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Domain{}, ErrDomainNotFound
+	}
+	return dom, err
+}
+
+func (d *Database) ListDomains(ctx context.Context) ([]Domain, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	rows, err := d.conn.Query(ctx, "SELECT name, base_url FROM domains ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Domain])
+}
+
+func (d *Database) DeleteDomain(ctx context.Context, name string) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var inUse bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM urls WHERE domain = $1 AND NOT is_deleted)", name).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrDomainInUse
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM domains WHERE name = $1", name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDomainNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+func (d *Database) CreateUser(ctx context.Context, u User) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	_, err := d.conn.Exec(ctx, "INSERT INTO users (id, login, password_hash, created_at) VALUES ($1, $2, $3, $4)",
+		u.ID, u.Login, u.PasswordHash, u.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (d *Database) GetUserByLogin(ctx context.Context, login string) (User, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	var u User
+	err := d.conn.QueryRow(ctx, "SELECT id, login, password_hash, created_at FROM users WHERE login = $1", login).
+		Scan(&u.ID, &u.Login, &u.PasswordHash, &u.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (d *Database) SaveRefreshToken(ctx context.Context, t RefreshToken) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	_, err := d.conn.Exec(ctx, "INSERT INTO refresh_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		t.TokenHash, t.UserID, t.ExpiresAt)
+	return err
+}
+
+func (d *Database) ConsumeRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	var userID uuid.UUID
+	var expiresAt time.Time
+	err := d.conn.QueryRow(ctx, "DELETE FROM refresh_tokens WHERE token_hash = $1 RETURNING user_id, expires_at", tokenHash).
+		Scan(&userID, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if time.Now().After(expiresAt) {
+		return uuid.Nil, ErrTokenInvalid
+	}
+	return userID, nil
+}
+
+func (d *Database) CreateAPIKey(ctx context.Context, k APIKey) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	_, err := d.conn.Exec(ctx, "INSERT INTO api_keys (id, user_id, name, key_hash, created_at) VALUES ($1, $2, $3, $4, $5)",
+		k.ID, k.UserID, k.Name, k.KeyHash, k.CreatedAt)
+	return err
+}
+
+func (d *Database) GetAPIKey(ctx context.Context, keyHash string) (APIKey, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	var k APIKey
+	err := d.conn.QueryRow(ctx, "SELECT id, user_id, name, key_hash, created_at FROM api_keys WHERE key_hash = $1", keyHash).
+		Scan(&k.ID, &k.UserID, &k.Name, &k.KeyHash, &k.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, ErrKeyNotFound
+	}
+	return k, err
+}
+
+func (d *Database) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	rows, err := d.conn.Query(ctx, "SELECT id, user_id, name, key_hash, created_at FROM api_keys WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[APIKey])
+}
+
+func (d *Database) DeleteAPIKey(ctx context.Context, userID uuid.UUID, id string) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	tag, err := d.conn.Exec(ctx, "DELETE FROM api_keys WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (d *Database) TransferURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBBatchTimeout)
+	defer cancel()
+	tag, err := d.conn.Exec(ctx, "UPDATE urls SET user_id = $2 WHERE user_id = $1", from, to)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (d *Database) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	var u User
+	err := d.conn.QueryRow(ctx, "SELECT id, login, password_hash, created_at FROM users WHERE id = $1", id).
+		Scan(&u.ID, &u.Login, &u.PasswordHash, &u.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+const linkColumns = "id, domain, short_url, full_url, user_id, is_deleted, disabled_status, disabled_reason, options, clicks, created_at, metadata"
+
+// GetLink читает ссылку с реплики: через него идут все редиректы. Ссылку, которая
+// ещё не доехала до реплики, readReplica найдёт на основной базе
+func (d *Database) GetLink(ctx context.Context, code string) (Link, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	var l Link
+	err := d.readReplica(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, "SELECT "+linkColumns+" FROM urls WHERE domain = $1 AND short_url = $2",
+			DomainFromContext(ctx).Name, code)
+		if err != nil {
+			return err
+		}
+		l, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[Link])
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Link{}, ErrLinkNotFound
+	}
+	return l, err
+}
+
+// ConsumeClick проверяет лимит и увеличивает счётчик одним UPDATE,
+// поэтому параллельные переходы не могут его превысить
+func (d *Database) ConsumeClick(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	tag, err := d.conn.Exec(ctx, `UPDATE urls SET clicks = clicks + 1
+		WHERE id = $1 AND clicks < COALESCE((options->>'max_clicks')::int, 0)`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLinkExhausted
+	}
+	return nil
+}
+
+func (d *Database) SearchLinks(ctx context.Context, f LinkFilter) ([]Link, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	f = f.Normalize()
+	// пустые условия отключаются через $n = ''
+	rows, err := d.conn.Query(ctx, "SELECT "+linkColumns+` FROM urls
+		WHERE ($1 = '' OR short_url = $1)
+		  AND ($2 = '' OR domain = $2)
+		  AND ($3 = '' OR user_id = $3)
+		  AND ($4 = '' OR full_url ILIKE '%' || $4 || '%')
+		  AND ($7 = '' OR id = $7)
+		  AND NOT ($8 AND is_deleted)
+		ORDER BY created_at, id LIMIT $5 OFFSET $6`,
+		f.Code, f.Domain, f.Owner, escapeLike(f.Destination), f.Limit, f.Offset, f.ID, f.ExcludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	links, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Link])
+	if links == nil {
+		links = []Link{}
+	}
+	return links, err
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (d *Database) execLink(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBWriteTimeout)
+	defer cancel()
+	tag, err := d.conn.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLinkNotFound
+	}
+	return nil
+}
+
+func (d *Database) DisableLink(ctx context.Context, id string, status int, reason string) error {
+	if status == 0 {
+		reason = ""
+	}
+	return d.execLink(ctx, "UPDATE urls SET disabled_status = $2, disabled_reason = $3 WHERE id = $1", id, status, reason)
+}
+
+// SetLinkOptions пишет настройки даже пустыми ('{}', а не NULL):
+// иначе ссылка попала бы под уникальный индекс обычных ссылок
+func (d *Database) SetLinkOptions(ctx context.Context, id string, opts LinkOptions) error {
+	return d.execUnshared(ctx, "UPDATE urls SET options = $2 WHERE id = $1 AND (options IS NOT NULL OR NOT shared)", id, opts)
+}
+
+// execUnshared выполняет изменение, условие которого пропускает обычные ссылки,
+// выданные другим пользователям. Если ничего не изменилось, отдельный запрос
+// отличает такую ссылку от несуществующей
+func (d *Database) execUnshared(ctx context.Context, query string, id string, args ...any) error {
+	err := d.execLink(ctx, query, append([]any{id}, args...)...)
+	if !errors.Is(err, ErrLinkNotFound) {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, d.cfg.DBReadTimeout)
+	defer cancel()
+	var exists bool
+	if err := d.conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM urls WHERE id = $1)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrLinkShared
+	}
+	return ErrLinkNotFound
+}
+
+func (d *Database) UpdateLink(ctx context.Context, id string, upd LinkUpdate) error {
+	var hash []byte
+	if upd.OriginalURL != nil {
+		h := urlHash(*upd.OriginalURL)
+		hash = h[:]
+	}
+	// пустые метаданные хранятся как NULL
+	var metadata any
+	if len(upd.Metadata) > 0 {
+		metadata = upd.Metadata
+	}
+	err := d.execUnshared(ctx, `UPDATE urls SET full_url = COALESCE($2, full_url),
+		full_url_hash = COALESCE($3, full_url_hash),
+		metadata = CASE WHEN $4 THEN $5::jsonb ELSE metadata END
+		WHERE id = $1 AND (options IS NOT NULL OR NOT shared OR (($2::text IS NULL OR full_url = $2) AND NOT $4))`,
+		id, upd.OriginalURL, hash, upd.Metadata != nil, metadata)
+	if isUniqueViolation(err) {
+		return ErrURLExists
+	}
+	return err
+}
+
+func (d *Database) TransferLink(ctx context.Context, id string, to uuid.UUID) error {
+	return d.execLink(ctx, "UPDATE urls SET user_id = $2 WHERE id = $1", id, to)
+}
+
+func (d *Database) DeleteDomainLinks(ctx context.Context, domain string) (int, error) {
+	ctx, cancel := withTimeout(ctx, d.cfg.DBBatchTimeout)
+	defer cancel()
+	tag, err := d.conn.Exec(ctx, "UPDATE urls SET is_deleted = true WHERE domain = $1 AND NOT is_deleted", domain)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
 }
@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/morozoffnor/go-url-shortener/internal/auth"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/pkg/chargen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Тесты и бенчмарки Database запускаются только на отдельной базе,
+// которую можно очищать: TEST_DATABASE_DSN=postgres://... go test -bench AddBatch ./internal/storage
+func testDatabase(tb testing.TB) *Database {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_DSN is not set")
+	}
+	require.NoError(tb, MigrateUp(dsn))
+
+	conn, err := pgxpool.New(context.Background(), dsn)
+	require.NoError(tb, err)
+	tb.Cleanup(conn.Close)
+	_, err = conn.Exec(context.Background(), "TRUNCATE urls")
+	require.NoError(tb, err)
+	return &Database{conn: conn, cfg: &config.Config{ResultAddr: "http://localhost:8080"}}
+}
+
+// unreachableDSN указывает на порт, где база заведомо не слушает
+const unreachableDSN = "postgres://shortener@127.0.0.1:1/shortener?connect_timeout=1"
+
+func TestBackoff(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt, want := range []time.Duration{base, 2 * base, 4 * base, 8 * base} {
+		for i := 0; i < 100; i++ {
+			d := backoff(base, attempt)
+			assert.GreaterOrEqual(t, d, want/2)
+			assert.LessOrEqual(t, d, want)
+		}
+	}
+	// пауза не растёт бесконечно и не переполняется
+	for _, attempt := range []int{9, 40, 100} {
+		d := backoff(base, attempt)
+		assert.GreaterOrEqual(t, d, maxConnectBackoff/2)
+		assert.LessOrEqual(t, d, maxConnectBackoff)
+	}
+}
+
+func TestNewDatabase_unavailable(t *testing.T) {
+	cfg := &config.Config{
+		DatabaseDSN:      unreachableDSN,
+		DBConnectRetries: 2,
+		DBConnectBackoff: config.Duration(time.Millisecond),
+	}
+	_, err := NewDatabase(cfg, context.Background())
+	assert.ErrorContains(t, err, "after 3 attempts")
+
+	cfg.DatabaseDSN = "not a dsn"
+	_, err = NewDatabase(cfg, context.Background())
+	assert.Error(t, err)
+}
+
+func TestNewDatabase_degradedStart(t *testing.T) {
+	cfg := &config.Config{
+		DatabaseDSN:      unreachableDSN,
+		DBConnectBackoff: config.Duration(time.Millisecond),
+		DegradedStart:    true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	db, err := NewDatabase(cfg, ctx)
+	require.NoError(t, err)
+	assert.False(t, db.Ping(ctx))
+	assert.NoError(t, db.Err())
+
+	// без ограничения попыток подключение заканчивается только с контекстом
+	select {
+	case <-db.Connected():
+		t.Fatal("connected to an unreachable database")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case <-db.Connected():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connect did not stop after cancel")
+	}
+	assert.ErrorIs(t, db.Err(), context.Canceled)
+	assert.False(t, db.Ping(context.Background()))
+}
+
+func TestNewPool(t *testing.T) {
+	cfg := &config.Config{
+		DBMaxConns:          7,
+		DBMinConns:          3,
+		DBMaxConnLifetime:   config.Duration(time.Hour),
+		DBHealthCheckPeriod: config.Duration(10 * time.Second),
+	}
+	pool, err := newPool(context.Background(), cfg, unreachableDSN)
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.EqualValues(t, 7, pool.Config().MaxConns)
+	assert.EqualValues(t, 3, pool.Config().MinConns)
+	assert.Equal(t, time.Hour, pool.Config().MaxConnLifetime)
+	assert.Equal(t, 10*time.Second, pool.Config().HealthCheckPeriod)
+	// нули оставляют значения pgx
+	assert.Equal(t, 30*time.Minute, pool.Config().MaxConnIdleTime)
+}
+
+func TestDatabase_replica(t *testing.T) {
+	d := &Database{}
+	assert.Nil(t, d.replica())
+
+	first, err := pgxpool.New(context.Background(), unreachableDSN)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := pgxpool.New(context.Background(), unreachableDSN)
+	require.NoError(t, err)
+	defer second.Close()
+	d.replicas = []*pgxpool.Pool{first, second}
+	assert.Same(t, first, d.replica())
+	assert.Same(t, second, d.replica())
+	assert.Same(t, first, d.replica())
+}
+
+func TestDatabase_readReplicaFallback(t *testing.T) {
+	replica, err := pgxpool.New(context.Background(), unreachableDSN)
+	require.NoError(t, err)
+	defer replica.Close()
+	primary, err := pgxpool.New(context.Background(), unreachableDSN)
+	require.NoError(t, err)
+	defer primary.Close()
+	d := &Database{conn: primary, replicas: []*pgxpool.Pool{replica}}
+
+	var used []querier
+	err = d.readReplica(context.Background(), func(q querier) error {
+		used = append(used, q)
+		if q == replica {
+			return pgx.ErrNoRows
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []querier{replica, primary}, used)
+
+	// чтение перед записью идёт сразу на основную базу
+	used = nil
+	err = d.readReplica(WithPrimary(context.Background()), func(q querier) error {
+		used = append(used, q)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []querier{primary}, used)
+}
+
+// основная база недоступна, поэтому найти ссылку GetLink может только на реплике
+func TestDatabase_getLinkFromReplica(t *testing.T) {
+	d := testDatabase(t)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+	code, err := d.AddNewURL(ctx, "http://a.com/")
+	require.NoError(t, err)
+
+	primary, err := pgxpool.New(context.Background(), unreachableDSN)
+	require.NoError(t, err)
+	defer primary.Close()
+	replicated := &Database{conn: primary, replicas: []*pgxpool.Pool{d.conn}, cfg: d.cfg}
+
+	link, err := replicated.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, "http://a.com/", link.OriginalURL)
+}
+
+func TestDatabase_addNewURL(t *testing.T) {
+	d := testDatabase(t)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	code, err := d.AddNewURL(ctx, "http://a.com/")
+	require.NoError(t, err)
+
+	again, err := d.AddNewURL(ctx, "http://a.com/")
+	assert.ErrorIs(t, err, ErrURLExists)
+	assert.Equal(t, code, again)
+
+	// ссылка с настройками не склеивается с обычной
+	other, err := d.AddNewURLWithOptions(ctx, "http://a.com/", LinkOptions{MaxClicks: 1})
+	require.NoError(t, err)
+	assert.NotEqual(t, code, other)
+
+	// тот же адрес на другом домене — другая ссылка
+	onDomain, err := d.AddNewURL(WithDomain(ctx, Domain{Name: "go.brand.com"}), "http://a.com/")
+	require.NoError(t, err)
+	assert.NotEqual(t, code, onDomain)
+
+	// длина адреса не ограничена, дубликаты находятся по хэшу
+	long := "http://a.com/?payload=" + strings.Repeat("a", 10_000)
+	longCode, err := d.AddNewURL(ctx, long)
+	require.NoError(t, err)
+	again, err = d.AddNewURL(ctx, long)
+	assert.ErrorIs(t, err, ErrURLExists)
+	assert.Equal(t, longCode, again)
+	link, err := d.GetLink(ctx, longCode)
+	require.NoError(t, err)
+	assert.Equal(t, long, link.OriginalURL)
+}
+
+func TestDatabase_sharedLink(t *testing.T) {
+	d := testDatabase(t)
+	owner := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+	other := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	own, err := d.AddNewURL(owner, "http://own.com/")
+	require.NoError(t, err)
+	_, err = d.AddNewURL(owner, "http://own.com/")
+	assert.ErrorIs(t, err, ErrURLExists)
+	ownLink, err := d.GetLink(owner, own)
+	require.NoError(t, err)
+	moved := "http://own.com/moved"
+	require.NoError(t, d.UpdateLink(owner, ownLink.ID, LinkUpdate{OriginalURL: &moved}))
+
+	code, err := d.AddNewURL(owner, "http://shared.com/")
+	require.NoError(t, err)
+	// пачка выдаёт ссылку так же, как одиночное сокращение
+	_, err = d.AddBatch(other, []BatchInput{{CorrelationID: "1", OriginalURL: "http://shared.com/"}})
+	require.NoError(t, err)
+
+	link, err := d.GetLink(owner, code)
+	require.NoError(t, err)
+	hijack := "http://evil.com/"
+	assert.ErrorIs(t, d.UpdateLink(owner, link.ID, LinkUpdate{OriginalURL: &hijack}), ErrLinkShared)
+	assert.ErrorIs(t, d.SetLinkOptions(owner, link.ID, LinkOptions{MaxClicks: 1}), ErrLinkShared)
+	assert.ErrorIs(t, d.SetLinkOptions(owner, uuid.NewString(), LinkOptions{MaxClicks: 1}), ErrLinkNotFound)
+	assert.ErrorIs(t, d.UpdateLink(owner, link.ID, LinkUpdate{Metadata: map[string]string{"team": "growth"}}), ErrLinkShared)
+
+	got, err := d.GetLink(other, code)
+	require.NoError(t, err)
+	assert.Equal(t, "http://shared.com/", got.OriginalURL)
+	assert.Nil(t, got.Options)
+}
+
+func TestDatabase_updateLink(t *testing.T) {
+	d := testDatabase(t)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	code, err := d.AddNewURL(ctx, "http://a.com/")
+	require.NoError(t, err)
+	_, err = d.AddNewURL(ctx, "http://b.com/")
+	require.NoError(t, err)
+	link, err := d.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.False(t, link.CreatedAt.IsZero())
+
+	moved := "http://c.com/"
+	require.NoError(t, d.UpdateLink(ctx, link.ID, LinkUpdate{OriginalURL: &moved, Metadata: map[string]string{"team": "growth"}}))
+	got, err := d.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, moved, got.OriginalURL)
+	assert.Equal(t, map[string]string{"team": "growth"}, got.Metadata)
+
+	busy := "http://b.com/"
+	assert.ErrorIs(t, d.UpdateLink(ctx, link.ID, LinkUpdate{OriginalURL: &busy}), ErrURLExists)
+	assert.ErrorIs(t, d.UpdateLink(ctx, uuid.NewString(), LinkUpdate{}), ErrLinkNotFound)
+
+	require.NoError(t, d.UpdateLink(ctx, link.ID, LinkUpdate{Metadata: map[string]string{}}))
+	links, err := d.SearchLinks(ctx, LinkFilter{ID: link.ID})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Nil(t, links[0].Metadata)
+}
+
+func TestDatabase_addBatch(t *testing.T) {
+	d := testDatabase(t)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	existing, err := d.AddNewURL(ctx, "http://old.com/")
+	require.NoError(t, err)
+
+	out, err := d.AddBatch(ctx, []BatchInput{
+		{CorrelationID: "1", OriginalURL: "http://old.com/"},
+		{CorrelationID: "2", OriginalURL: "http://new.com/"},
+		{CorrelationID: "3", OriginalURL: "http://new.com/"},
+	})
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+	assert.Equal(t, "http://localhost:8080/"+existing, out[0].ShortURL)
+	assert.NotEqual(t, out[0].ShortURL, out[1].ShortURL)
+	assert.Equal(t, out[1].ShortURL, out[2].ShortURL)
+	assert.Equal(t, []string{"1", "2", "3"}, []string{out[0].CorrelationID, out[1].CorrelationID, out[2].CorrelationID})
+	// новой считается только первая запись адреса, которого ещё не было
+	assert.Equal(t, []bool{false, true, false}, []bool{out[0].Created, out[1].Created, out[2].Created})
+	assert.Equal(t, out[1].ID, out[2].ID)
+	old, err := d.GetLink(ctx, existing)
+	require.NoError(t, err)
+	assert.Equal(t, old.ID, out[0].ID)
+
+	again, err := d.AddBatch(ctx, []BatchInput{{CorrelationID: "4", OriginalURL: "http://new.com/"}})
+	require.NoError(t, err)
+	assert.Equal(t, out[1].ShortURL, again[0].ShortURL)
+	assert.Equal(t, out[1].ID, again[0].ID)
+	assert.False(t, again[0].Created)
+
+	var count int
+	require.NoError(t, d.conn.QueryRow(ctx, "SELECT count(*) FROM urls").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func batchInput(prefix string, n int) []BatchInput {
+	urls := make([]BatchInput, n)
+	for i := range urls {
+		urls[i] = BatchInput{CorrelationID: fmt.Sprint(i), OriginalURL: fmt.Sprintf("https://example.com/%s/%d", prefix, i)}
+	}
+	return urls
+}
+
+// addBatchPerItem — прежняя реализация AddBatch: SELECT на каждый адрес
+// и INSERT'ы в pgx.Batch, оставлена для сравнения в бенчмарке
+func (d *Database) addBatchPerItem(ctx context.Context, urls []BatchInput) error {
+	domain := DomainFromContext(ctx).Name
+	userID, _ := auth.UserIDFromContext(ctx)
+	batch := &pgx.Batch{}
+	for _, v := range urls {
+		var short string
+		hash := urlHash(v.OriginalURL)
+		err := d.conn.QueryRow(ctx, "SELECT short_url FROM urls WHERE domain=$1 AND full_url_hash=$2 AND options IS NULL",
+			domain, hash[:]).Scan(&short)
+		if err == nil {
+			continue
+		}
+		batch.Queue("INSERT INTO urls (id, domain, full_url, full_url_hash, short_url, user_id) VALUES ($1, $2, $3, $4, $5, $6)",
+			uuid.NewString(), domain, v.OriginalURL, hash[:], chargen.CreateRandomCharSeq(), userID.String())
+	}
+	return d.conn.SendBatch(ctx, batch).Close()
+}
+
+func BenchmarkAddBatch10k(b *testing.B) {
+	const size = 10_000
+	d := testDatabase(b)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	implementations := []struct {
+		name string
+		add  func(ctx context.Context, urls []BatchInput) error
+	}{
+		{name: "copy", add: func(ctx context.Context, urls []BatchInput) error {
+			_, err := d.AddBatch(ctx, urls)
+			return err
+		}},
+		{name: "per-item", add: d.addBatchPerItem},
+	}
+	for _, impl := range implementations {
+		b.Run(impl.name+"/new", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				// адреса каждый раз новые, в том числе между запусками с разным b.N
+				urls := batchInput(uuid.NewString(), size)
+				b.StartTimer()
+				require.NoError(b, impl.add(ctx, urls))
+			}
+		})
+		b.Run(impl.name+"/existing", func(b *testing.B) {
+			urls := batchInput(uuid.NewString(), size)
+			require.NoError(b, impl.add(ctx, urls))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, impl.add(ctx, urls))
+			}
+		})
+	}
+}
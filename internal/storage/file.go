@@ -4,85 +4,90 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
-	"github.com/morozoffnor/go-url-shortener/internal/auth"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
-	"github.com/morozoffnor/go-url-shortener/pkg/chargen"
 	"github.com/morozoffnor/go-url-shortener/pkg/logger"
-	"log"
 	"os"
 	"sync"
 )
 
+// FileStorage держит данные в памяти (через MemoryStorage) и дописывает
+// каждую изменённую запись в конец файла. При загрузке для каждого UUID
+// остаётся последняя версия записи, и файл с устаревшими версиями
+// (например, счётчики переходов) один раз переписывается начисто.
+// Домены хранятся отдельно, в файле <FileStoragePath>.domains,
+// пользователи с ключами и токенами — в <FileStoragePath>.users
 type FileStorage struct {
-	mu   *sync.Mutex
-	cfg  *config.Config
-	List []*url
+	*MemoryStorage
+	fmu *sync.Mutex
+	cfg *config.Config
+
+	// cmu держит переход и запись счётчика в файл вместе, чтобы в файле
+	// более поздняя строка всегда содержала больший счётчик
+	cmu sync.Mutex
 }
 
-func NewFileStorage(cfg *config.Config) *FileStorage {
+func NewFileStorage(cfg *config.Config) (*FileStorage, error) {
 	u := &FileStorage{
-		List: []*url{},
-		mu:   &sync.Mutex{},
-		cfg:  cfg,
+		MemoryStorage: NewMemoryStorage(cfg),
+		fmu:           &sync.Mutex{},
+		cfg:           cfg,
 	}
-	err := u.LoadFromFile()
-	if err != nil {
-		logger.Logger.Error("error loading from file", err)
+	if err := u.LoadFromFile(); err != nil {
+		return nil, fmt.Errorf("loading links from %s: %w", cfg.FileStoragePath, err)
+	}
+	if err := u.loadDomains(); err != nil {
+		return nil, fmt.Errorf("loading domains: %w", err)
 	}
-	return u
+	if err := u.loadUsers(); err != nil {
+		return nil, fmt.Errorf("loading users: %w", err)
+	}
+	return u, nil
 }
 
 func (s *FileStorage) AddNewURL(ctx context.Context, full string) (string, error) {
-	if len(full) < 1 {
-		return "", errors.New("blank URL")
+	u, changed, err := s.addURL(ctx, full, nil)
+	if err != nil && !errors.Is(err, ErrURLExists) {
+		return "", err
 	}
-	for _, v := range s.List {
-		if v.OriginalURL == full {
-			return v.ShortURL, nil
-		}
+	if changed {
+		_ = s.SaveToFile(&u)
 	}
-	newURL := &url{
-		UUID:        uuid.NewString(),
-		ShortURL:    chargen.CreateRandomCharSeq(),
-		OriginalURL: full,
-		UserID:      ctx.Value(auth.ContextUserID).(uuid.UUID).String(),
-		IsDeleted:   false,
-	}
-	s.mu.Lock()
-	s.List = append(s.List, newURL)
-	_ = s.SaveToFile(newURL)
-	s.mu.Unlock()
-	return newURL.ShortURL, nil
+	return u.ShortURL, err
 }
 
-func (s *FileStorage) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
-	if len(shortURL) < 1 {
-		return "", false, errors.New("no short URL provided")
+func (s *FileStorage) AddNewURLWithOptions(ctx context.Context, full string, opts LinkOptions) (string, error) {
+	u, changed, err := s.addURL(ctx, full, opts.orNil())
+	if err != nil && !errors.Is(err, ErrURLExists) {
+		return "", err
 	}
-	for _, v := range s.List {
-		if v.ShortURL == shortURL {
-			return v.OriginalURL, v.IsDeleted, nil
-		}
+	if changed {
+		_ = s.SaveToFile(&u)
 	}
-	return "", false, errors.New("there is no such URL")
+	return u.ShortURL, err
 }
 
-func (s *FileStorage) SaveToFile(URLToSave *url) error {
+func (s *FileStorage) SaveToFile(URLsToSave ...*url) error {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
 	file, err := os.OpenFile(s.cfg.FileStoragePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		logger.Logger.Error("error opening file "+s.cfg.FileStoragePath, err)
 		return err
 	}
-	data, err := json.MarshalIndent(&URLToSave, "", "    ")
-	if err != nil {
-		return err
-	}
-	_, err = file.Write(data)
-	if err != nil {
-		return err
+	defer file.Close()
+	for _, u := range URLsToSave {
+		data, err := json.MarshalIndent(u, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		if err != nil {
+			return err
+		}
 	}
-	return err
+	return nil
 }
 
 func (s *FileStorage) LoadFromFile() error {
@@ -95,6 +100,10 @@ func (s *FileStorage) LoadFromFile() error {
 		return err
 	}
 	defer file.Close()
+
+	// более поздняя версия записи перекрывает более раннюю
+	byID := make(map[string]*url)
+	records := 0
 	decoder := json.NewDecoder(file)
 	for decoder.More() {
 		var u url
@@ -102,202 +111,262 @@ func (s *FileStorage) LoadFromFile() error {
 		if err != nil {
 			return err
 		}
+		records++
+		if prev, ok := byID[u.UUID]; ok {
+			*prev = u
+			continue
+		}
+		byID[u.UUID] = &u
 		s.List = append(s.List, &u)
 	}
+	// индекс адресов перестроится по загруженному списку
+	s.plain = nil
+	if records > len(s.List) {
+		return s.compact()
+	}
 	return nil
 }
 
-func (s *FileStorage) AddBatch(ctx context.Context, urls []BatchInput) ([]BatchOutput, error) {
-	if len(urls) < 1 {
-		return []BatchOutput{}, nil
+// compact переписывает файл, оставляя по одной записи на UUID. Новый файл
+// пишется рядом и подменяет старый целиком, поэтому сбой посреди записи
+// оставляет прежний файл
+func (s *FileStorage) compact() error {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	tmp := s.cfg.FileStoragePath + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
 	}
-	var result []BatchOutput
-	for _, v := range urls {
-		shortURL, err := s.AddNewURL(ctx, v.OriginalURL)
+	for _, u := range s.List {
+		data, err := json.MarshalIndent(u, "", "    ")
+		if err == nil {
+			_, err = file.Write(data)
+		}
 		if err != nil {
-			return nil, err
+			file.Close()
+			return err
 		}
-		result = append(result, BatchOutput{
-			ShortURL:      shortURL,
-			CorrelationID: v.CorrelationID,
-		})
 	}
-	return result, nil
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cfg.FileStoragePath)
 }
 
-func (s *FileStorage) GetUserURLs(ctx context.Context, userID uuid.UUID) ([]UserURLs, error) {
-	if len(userID) == 0 {
-		return nil, nil
+func (s *FileStorage) AddBatch(ctx context.Context, urls []BatchInput) ([]BatchOutput, error) {
+	result, changed, err := s.addBatch(ctx, urls)
+	if len(changed) > 0 {
+		_ = s.SaveToFile(ptrs(changed)...)
 	}
-	var result []UserURLs
-	for _, v := range s.List {
-		if v.UserID == userID.String() {
-			var u UserURLs
-			u.ShortURL = s.cfg.ResultAddr + "/" + v.ShortURL
-			u.OriginalURL = v.OriginalURL
+	return result, err
+}
 
-			result = append(result, u)
+func (s *FileStorage) DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) URLsForDeletion {
+	changed := s.deleteURLs(ctx, userID, urls)
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := s.SaveToFile(ptrs(changed)...); err != nil {
+		logger.Logger.Error(err)
+	}
+	return deletedCodes(changed)
+}
 
-		}
+func (s *FileStorage) AddDomain(ctx context.Context, d Domain) error {
+	if err := s.MemoryStorage.AddDomain(ctx, d); err != nil {
+		return err
 	}
-	return result, nil
+	return s.saveDomains(ctx)
 }
 
-func (s *FileStorage) DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) {
-	input := s.generator(ctx, userID, urls)
-	out := s.fanOut(ctx, input)
-	in := s.fanIn(ctx, out)
-	s.softDeleteURLs(ctx, in)
+func (s *FileStorage) DeleteDomain(ctx context.Context, name string) error {
+	if err := s.MemoryStorage.DeleteDomain(ctx, name); err != nil {
+		return err
+	}
+	return s.saveDomains(ctx)
 }
 
-func (s *FileStorage) generator(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) chan DeleteURLItem {
-	inputCh := make(chan DeleteURLItem)
-
-	// наполняем канал айтемами
-	go func() {
-		defer close(inputCh)
-		for _, v := range urls {
-			item := DeleteURLItem{
-				UserID:   userID,
-				ShortURL: v,
-			}
-			log.Print("gen", item)
-			select {
-			case <-ctx.Done():
-				return
-			case inputCh <- item:
-			}
-		}
-	}()
-	return inputCh
+func (s *FileStorage) domainsPath() string {
+	return s.cfg.FileStoragePath + ".domains"
 }
 
-func (s *FileStorage) fanOut(ctx context.Context, inputCh <-chan DeleteURLItem) chan string {
-	outCh := make(chan string)
-
-	// распределяем работу: ищем айдишники урлов в базе
-	go func() {
-		defer close(outCh)
-
-		for item := range inputCh {
-			var id string
-			for _, v := range s.List {
-				if item.ShortURL == v.ShortURL && item.UserID.String() == v.UserID {
-					id = v.UUID
-				}
-			}
-			if id == "" {
-				continue
-			}
-			log.Print("fanOut", "sent id")
-			select {
-			case <-ctx.Done():
-				return
-			case outCh <- id:
-			}
-		}
-	}()
+// saveDomains переписывает файл доменов целиком, их немного
+func (s *FileStorage) saveDomains(ctx context.Context) error {
+	domains, err := s.ListDomains(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(domains, "", "    ")
+	if err != nil {
+		return err
+	}
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	return os.WriteFile(s.domainsPath(), data, 0666)
+}
 
-	return outCh
+func (s *FileStorage) loadDomains() error {
+	data, err := os.ReadFile(s.domainsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.Domains)
 }
 
-func (s *FileStorage) fanIn(ctx context.Context, ids ...chan string) chan string {
-	delCh := make(chan string)
+// usersFile — содержимое файла <FileStoragePath>.users
+type usersFile struct {
+	Users         []User         `json:"users"`
+	APIKeys       []APIKey       `json:"api_keys"`
+	RefreshTokens []RefreshToken `json:"refresh_tokens"`
+}
 
-	var wg sync.WaitGroup
+func (s *FileStorage) usersPath() string {
+	return s.cfg.FileStoragePath + ".users"
+}
 
-	// собираем полученные айдишники в один канал
-	for _, ch := range ids {
-		wg.Add(1)
-		log.Print("fanIn", " collected id")
-		go func() {
-			defer wg.Done()
+// saveUsers переписывает файл пользователей целиком
+func (s *FileStorage) saveUsers() error {
+	s.mu.Lock()
+	uf := usersFile{
+		Users:   append([]User{}, s.Users...),
+		APIKeys: append([]APIKey{}, s.APIKeys...),
+	}
+	for _, t := range s.RefreshTokens {
+		uf.RefreshTokens = append(uf.RefreshTokens, t)
+	}
+	s.mu.Unlock()
 
-			for item := range ch {
-				select {
-				case <-ctx.Done():
-					return
-				case delCh <- item:
-				}
-			}
-		}()
+	data, err := json.MarshalIndent(uf, "", "    ")
+	if err != nil {
+		return err
 	}
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	return os.WriteFile(s.usersPath(), data, 0600)
+}
 
-	// ждём выполнения и закрываем канал
-	go func() {
-		wg.Wait()
-		close(delCh)
-	}()
+func (s *FileStorage) loadUsers() error {
+	data, err := os.ReadFile(s.usersPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var uf usersFile
+	if err := json.Unmarshal(data, &uf); err != nil {
+		return err
+	}
+	s.Users = uf.Users
+	s.APIKeys = uf.APIKeys
+	for _, t := range uf.RefreshTokens {
+		s.RefreshTokens[t.TokenHash] = t
+	}
+	return nil
+}
 
-	return delCh
+func (s *FileStorage) CreateUser(ctx context.Context, u User) error {
+	if err := s.MemoryStorage.CreateUser(ctx, u); err != nil {
+		return err
+	}
+	return s.saveUsers()
 }
 
-func (s *FileStorage) softDeleteURLs(ctx context.Context, delCh chan string) {
-	var idsForDeletion []string
-	for item := range delCh {
-		idsForDeletion = append(idsForDeletion, item)
+func (s *FileStorage) SaveRefreshToken(ctx context.Context, t RefreshToken) error {
+	if err := s.MemoryStorage.SaveRefreshToken(ctx, t); err != nil {
+		return err
 	}
+	return s.saveUsers()
+}
 
-	if len(idsForDeletion) == 0 {
-		return
+func (s *FileStorage) ConsumeRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	userID, err := s.MemoryStorage.ConsumeRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return uuid.Nil, err
 	}
+	return userID, s.saveUsers()
+}
 
-	// забираем себе файл, лочим мьютекс
-	s.mu.Lock()
-	file, err := os.OpenFile(s.cfg.FileStoragePath, os.O_TRUNC|os.O_APPEND, 0666)
-	if os.IsNotExist(err) {
-		logger.Logger.Error(err)
-		return
+func (s *FileStorage) CreateAPIKey(ctx context.Context, k APIKey) error {
+	if err := s.MemoryStorage.CreateAPIKey(ctx, k); err != nil {
+		return err
+	}
+	return s.saveUsers()
+}
+
+func (s *FileStorage) DeleteAPIKey(ctx context.Context, userID uuid.UUID, id string) error {
+	if err := s.MemoryStorage.DeleteAPIKey(ctx, userID, id); err != nil {
+		return err
+	}
+	return s.saveUsers()
+}
+
+func (s *FileStorage) TransferURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	changed := s.transferURLs(from, to)
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	return len(changed), s.SaveToFile(ptrs(changed)...)
+}
+
+func ptrs(list []url) []*url {
+	res := make([]*url, len(list))
+	for i := range list {
+		res[i] = &list[i]
 	}
+	return res
+}
+
+func (s *FileStorage) DisableLink(ctx context.Context, id string, status int, reason string) error {
+	u, err := s.updateLink(id, disable(status, reason))
 	if err != nil {
-		logger.Logger.Error(err)
-		return
+		return err
 	}
-	defer file.Close()
+	return s.SaveToFile(&u)
+}
 
-	// парсим файл в лист
-	var list []*url
-	decoder := json.NewDecoder(file)
-	for decoder.More() {
-		var u url
-		err := decoder.Decode(&u)
-		if err != nil {
-			logger.Logger.Error(err)
-			return
-		}
-		list = append(list, &u)
+func (s *FileStorage) SetLinkOptions(ctx context.Context, id string, opts LinkOptions) error {
+	u, err := s.setLinkOptions(id, opts)
+	if err != nil {
+		return err
 	}
+	return s.SaveToFile(&u)
+}
 
-	// ищем айдишники и "удаляем"
-	for _, v := range list {
-		for _, w := range idsForDeletion {
-			if v.UUID == w {
-				v.IsDeleted = true
-			}
-		}
+func (s *FileStorage) UpdateLink(ctx context.Context, id string, upd LinkUpdate) error {
+	u, err := s.updateLinkFields(id, upd)
+	if err != nil {
+		return err
 	}
+	return s.SaveToFile(&u)
+}
 
-	// очищаем файл
-	err = file.Truncate(0)
+func (s *FileStorage) TransferLink(ctx context.Context, id string, to uuid.UUID) error {
+	u, err := s.updateLink(id, func(u *url) { u.UserID = to.String() })
 	if err != nil {
-		logger.Logger.Error(err)
-		return
+		return err
 	}
+	return s.SaveToFile(&u)
+}
 
-	// записываем новые урлы в файл
-	for _, v := range list {
-		data, err := json.MarshalIndent(&v, "", "    ")
-		if err != nil {
-			logger.Logger.Error(err)
-			return
-		}
-		_, err = file.Write(data)
-		if err != nil {
-			logger.Logger.Error(err)
-			return
-		}
+func (s *FileStorage) DeleteDomainLinks(ctx context.Context, domain string) (int, error) {
+	changed := s.deleteDomainLinks(domain)
+	if len(changed) == 0 {
+		return 0, nil
 	}
+	return len(changed), s.SaveToFile(ptrs(changed)...)
+}
 
-	// убираем лок с мьютекса
-	s.mu.Unlock()
+func (s *FileStorage) ConsumeClick(ctx context.Context, id string) error {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	u, err := s.consumeClick(id)
+	if err != nil {
+		return err
+	}
+	return s.SaveToFile(&u)
 }
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"github.com/morozoffnor/go-url-shortener/internal/routing"
+	"strings"
+	"time"
+)
+
+// Link — ссылка целиком, как её видят редирект и админка
+type Link struct {
+	ID          string `json:"id"`
+	Domain      string `json:"domain"`
+	Code        string `json:"code"`
+	OriginalURL string `json:"original_url"`
+	UserID      string `json:"user_id"`
+	IsDeleted   bool   `json:"is_deleted"`
+	// DisabledStatus — код ответа для отключённой модератором ссылки (410 или 451), 0 — ссылка работает
+	DisabledStatus int    `json:"disabled_status,omitempty"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
+
+	Options *LinkOptions `json:"options,omitempty"`
+	Clicks  int          `json:"clicks"`
+	// CreatedAt пуст у ссылок из файлов, записанных до появления поля
+	CreatedAt time.Time         `json:"created_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	// Protected заполняет Public вместо хэша пароля
+	Protected bool `json:"protected,omitempty" db:"-"`
+}
+
+func (l Link) Disabled() bool {
+	return l.DisabledStatus != 0
+}
+
+// Limited сообщает, что у ссылки есть лимит переходов и их нужно считать
+func (l Link) Limited() bool {
+	return l.Options != nil && l.Options.MaxClicks > 0
+}
+
+// Exhausted сообщает, что лимит переходов уже исчерпан
+func (l Link) Exhausted() bool {
+	return l.Limited() && l.Clicks >= l.Options.MaxClicks
+}
+
+// Pending сообщает, что окно ссылки ещё не началось
+func (l Link) Pending(now time.Time) bool {
+	return l.Options != nil && l.Options.NotBefore != nil && now.Before(*l.Options.NotBefore)
+}
+
+// Expired сообщает, что окно ссылки уже закончилось
+func (l Link) Expired(now time.Time) bool {
+	return l.Options != nil && l.Options.NotAfter != nil && !now.Before(*l.Options.NotAfter)
+}
+
+// HasPassword сообщает, что перед редиректом нужно ввести пароль
+func (l Link) HasPassword() bool {
+	return l.Options != nil && l.Options.PasswordHash != ""
+}
+
+// Public убирает из ссылки хэш пароля перед отдачей наружу
+func (l Link) Public() Link {
+	if l.HasPassword() {
+		opts := *l.Options
+		opts.PasswordHash = ""
+		l.Options = &opts
+		l.Protected = true
+	}
+	return l
+}
+
+// LinkOptions — необязательные настройки ссылки. Ссылка с настройками
+// не склеивается с другими ссылками на тот же адрес
+type LinkOptions struct {
+	// PasswordHash — bcrypt-хэш пароля, который спрашивается перед редиректом
+	PasswordHash string `json:"password_hash,omitempty"`
+	// MaxClicks — сколько раз можно перейти по ссылке, 1 — одноразовая ссылка
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// NotBefore и NotAfter задают окно, в котором ссылка работает. До начала окна
+	// редирект ведёт на FallbackURL, а без него показывает страницу «скоро»
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	FallbackURL string     `json:"fallback_url,omitempty"`
+	// Rules выбирают адрес назначения по устройству, языку и стране,
+	// OriginalURL используется, если ни одно правило не сработало
+	Rules []routing.Rule `json:"rules,omitempty"`
+	// Forwarding переносит в адрес назначения путь и параметры запроса
+	routing.Forwarding
+}
+
+// IsZero сообщает, что настроек нет
+func (o LinkOptions) IsZero() bool {
+	return o.PasswordHash == "" && o.MaxClicks == 0 && o.NotBefore == nil && o.NotAfter == nil &&
+		o.FallbackURL == "" && len(o.Rules) == 0 && o.Forwarding.IsZero()
+}
+
+// orNil возвращает nil для пустых настроек, чтобы такие ссылки дедуплицировались как обычные
+func (o LinkOptions) orNil() *LinkOptions {
+	if o.IsZero() {
+		return nil
+	}
+	return &o
+}
+
+// LinkUpdate — изменения ссылки, nil оставляет поле как есть.
+// Пустые, но не nil метаданные удаляют их
+type LinkUpdate struct {
+	OriginalURL *string
+	Metadata    map[string]string
+}
+
+// LinkFilter — условия поиска по всем ссылкам, пустые поля не учитываются.
+// Результат упорядочен по времени создания
+type LinkFilter struct {
+	ID     string
+	Code   string
+	Domain string
+	// Destination ищется как подстрока без учёта регистра
+	Destination string
+	Owner       string
+	// ExcludeDeleted скрывает удалённые ссылки
+	ExcludeDeleted bool
+	Limit          int
+	Offset         int
+}
+
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 500
+)
+
+var (
+	ErrLinkNotFound  = errors.New("link not found")
+	ErrLinkExhausted = errors.New("link click limit reached")
+	// ErrURLExists возвращается вместе с кодом ссылки, которая уже ведёт на этот адрес
+	ErrURLExists = errors.New("url is already shortened")
+	// ErrLinkShared — обычную ссылку дедупликация уже выдала другим пользователям,
+	// сократившим тот же адрес. Её адрес и настройки меняют переход и для них
+	ErrLinkShared = errors.New("link is shared with other users")
+)
+
+func (u *url) link() Link {
+	return Link{
+		ID:             u.UUID,
+		Domain:         u.Domain,
+		Code:           u.ShortURL,
+		OriginalURL:    u.OriginalURL,
+		UserID:         u.UserID,
+		IsDeleted:      u.IsDeleted,
+		DisabledStatus: u.DisabledStatus,
+		DisabledReason: u.DisabledReason,
+		Options:        u.Options,
+		Clicks:         u.Clicks,
+		CreatedAt:      u.CreatedAt,
+		Metadata:       u.Metadata,
+	}
+}
+
+func (f LinkFilter) match(u *url) bool {
+	if f.ID != "" && u.UUID != f.ID {
+		return false
+	}
+	if f.ExcludeDeleted && u.IsDeleted {
+		return false
+	}
+	if f.Code != "" && u.ShortURL != f.Code {
+		return false
+	}
+	if f.Domain != "" && u.Domain != f.Domain {
+		return false
+	}
+	if f.Owner != "" && u.UserID != f.Owner {
+		return false
+	}
+	if f.Destination != "" && !strings.Contains(strings.ToLower(u.OriginalURL), strings.ToLower(f.Destination)) {
+		return false
+	}
+	return true
+}
+
+// Normalize ограничивает размер страницы
+func (f LinkFilter) Normalize() LinkFilter {
+	if f.Limit <= 0 {
+		f.Limit = DefaultSearchLimit
+	}
+	if f.Limit > MaxSearchLimit {
+		f.Limit = MaxSearchLimit
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	return f
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"github.com/google/uuid"
 	"github.com/morozoffnor/go-url-shortener/internal/auth"
@@ -9,85 +10,154 @@ import (
 	"github.com/morozoffnor/go-url-shortener/pkg/chargen"
 	"log"
 	"sync"
+	"time"
 )
 
 type MemoryStorage struct {
-	mu   *sync.Mutex
-	cfg  *config.Config
-	List []*url
+	mu      *sync.Mutex
+	cfg     *config.Config
+	List    []*url
+	Domains []Domain
+	// plain — индекс обычных ссылок по домену и хэшу адреса, строится
+	// из List при первом обращении (см. plainLocked)
+	plain map[urlKey]*url
+
+	Users         []User
+	APIKeys       []APIKey
+	RefreshTokens map[string]RefreshToken
 }
 
 func NewMemoryStorage(cfg *config.Config) *MemoryStorage {
 	u := &MemoryStorage{
-		List: []*url{},
-		mu:   &sync.Mutex{},
-		cfg:  cfg,
+		List:          []*url{},
+		RefreshTokens: map[string]RefreshToken{},
+		mu:            &sync.Mutex{},
+		cfg:           cfg,
 	}
 	return u
 }
 
 func (s *MemoryStorage) AddNewURL(ctx context.Context, full string) (string, error) {
+	u, _, err := s.addURL(ctx, full, nil)
+	if err != nil && !errors.Is(err, ErrURLExists) {
+		return "", err
+	}
+	return u.ShortURL, err
+}
+
+func (s *MemoryStorage) AddNewURLWithOptions(ctx context.Context, full string, opts LinkOptions) (string, error) {
+	u, _, err := s.addURL(ctx, full, opts.orNil())
+	if err != nil && !errors.Is(err, ErrURLExists) {
+		return "", err
+	}
+	return u.ShortURL, err
+}
+
+// addURL возвращает копию записи и признак того, что запись создана или изменена.
+// Используется и FileStorage, которому нужно дописать такую запись в файл.
+// Для уже сокращённого адреса вместе с существующей записью возвращается
+// ErrURLExists, как и в Database. Ссылки с настройками (opts != nil) всегда создаются заново
+func (s *MemoryStorage) addURL(ctx context.Context, full string, opts *LinkOptions) (url, bool, error) {
 	if len(full) < 1 {
-		return "", errors.New("blank URL")
+		return url{}, false, errors.New("blank URL")
 	}
-	for _, v := range s.List {
-		if v.OriginalURL == full {
-			return v.ShortURL, nil
+	domain := DomainFromContext(ctx).Name
+	userID, _ := auth.UserIDFromContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := urlKey{domain: domain, hash: urlHash(full)}
+	if opts == nil {
+		// ссылка из индекса могла с тех пор получить настройки
+		if v, ok := s.plainLocked()[key]; ok && v.Options == nil {
+			if v.Shared || v.UserID == userID.String() {
+				return *v, false, ErrURLExists
+			}
+			v.Shared = true
+			return *v, true, ErrURLExists
 		}
 	}
 	newURL := &url{
 		UUID:        uuid.NewString(),
+		Domain:      domain,
 		ShortURL:    chargen.CreateRandomCharSeq(),
 		OriginalURL: full,
-		UserID:      ctx.Value(auth.ContextUserID).(uuid.UUID).String(),
+		UserID:      userID.String(),
 		IsDeleted:   false,
+		Options:     opts,
+		CreatedAt:   time.Now().UTC(),
 	}
-	s.mu.Lock()
 	s.List = append(s.List, newURL)
-	s.mu.Unlock()
-	return newURL.ShortURL, nil
+	if opts == nil {
+		s.plainLocked()[key] = newURL
+	}
+	return *newURL, true, nil
 }
 
-func (s *MemoryStorage) GetFullURL(ctx context.Context, shortURL string) (string, bool, error) {
-	if len(shortURL) < 1 {
-		return "", false, errors.New("no short URL provided")
-	}
-	for _, v := range s.List {
-		if v.ShortURL == shortURL {
-			return v.OriginalURL, v.IsDeleted, nil
+// urlKey — ключ дедупликации обычных ссылок, как уникальный индекс
+// urls_domain_full_url_hash_key в базе
+type urlKey struct {
+	domain string
+	hash   [sha256.Size]byte
+}
+
+func (s *MemoryStorage) plainLocked() map[urlKey]*url {
+	if s.plain == nil {
+		s.plain = make(map[urlKey]*url)
+		for _, v := range s.List {
+			if v.Options == nil {
+				s.plain[urlKey{domain: v.Domain, hash: urlHash(v.OriginalURL)}] = v
+			}
 		}
 	}
-	return "", false, errors.New("there is no such URL")
+	return s.plain
 }
 
 func (s *MemoryStorage) AddBatch(ctx context.Context, urls []BatchInput) ([]BatchOutput, error) {
+	result, _, err := s.addBatch(ctx, urls)
+	return result, err
+}
+
+func (s *MemoryStorage) addBatch(ctx context.Context, urls []BatchInput) ([]BatchOutput, []url, error) {
 	if len(urls) < 1 {
-		return []BatchOutput{}, nil
+		return []BatchOutput{}, nil, nil
 	}
+	base := BaseURL(s.cfg, DomainFromContext(ctx))
 	var result []BatchOutput
+	var changed []url
 	for _, v := range urls {
-		shortURL, err2 := s.AddNewURL(ctx, v.OriginalURL)
-		if err2 != nil {
-			return nil, err2
+		u, isChanged, err := s.addURL(ctx, v.OriginalURL, nil)
+		if err != nil && !errors.Is(err, ErrURLExists) {
+			return nil, changed, err
+		}
+		if isChanged {
+			changed = append(changed, u)
 		}
 		result = append(result, BatchOutput{
-			ShortURL:      shortURL,
+			ShortURL:      base + "/" + u.ShortURL,
 			CorrelationID: v.CorrelationID,
+			ID:            u.UUID,
+			Created:       err == nil,
 		})
 	}
-	return result, nil
+	return result, changed, nil
 }
 
 func (s *MemoryStorage) GetUserURLs(ctx context.Context, userID uuid.UUID) ([]UserURLs, error) {
 	if len(userID) == 0 {
 		return nil, nil
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	var result []UserURLs
 	for _, v := range s.List {
 		if v.UserID == userID.String() {
 			var u UserURLs
-			u.ShortURL = s.cfg.ResultAddr + "/" + v.ShortURL
+			u.ShortURL = BaseURL(s.cfg, s.domainLocked(v.Domain)) + "/" + v.ShortURL
 			u.OriginalURL = v.OriginalURL
+			if v.Options != nil {
+				u.NotBefore, u.NotAfter = v.Options.NotBefore, v.Options.NotAfter
+			}
 
 			result = append(result, u)
 
@@ -96,11 +166,24 @@ func (s *MemoryStorage) GetUserURLs(ctx context.Context, userID uuid.UUID) ([]Us
 	return result, nil
 }
 
-func (s *MemoryStorage) DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) {
+func (s *MemoryStorage) DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) URLsForDeletion {
+	return deletedCodes(s.deleteURLs(ctx, userID, urls))
+}
+
+func deletedCodes(changed []url) URLsForDeletion {
+	var codes URLsForDeletion
+	for _, u := range changed {
+		codes = append(codes, u.ShortURL)
+	}
+	return codes
+}
+
+// deleteURLs возвращает копии помеченных на удаление записей
+func (s *MemoryStorage) deleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) []url {
 	input := s.generator(ctx, userID, urls)
 	out := s.fanOut(ctx, input)
 	in := s.fanIn(ctx, out)
-	s.softDeleteURLs(ctx, in)
+	return s.softDeleteURLs(ctx, in)
 }
 
 func (s *MemoryStorage) generator(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) chan DeleteURLItem {
@@ -113,6 +196,7 @@ func (s *MemoryStorage) generator(ctx context.Context, userID uuid.UUID, urls UR
 			item := DeleteURLItem{
 				UserID:   userID,
 				ShortURL: v,
+				Domain:   DomainFromContext(ctx).Name,
 			}
 			log.Print("gen", item)
 			select {
@@ -134,11 +218,13 @@ func (s *MemoryStorage) fanOut(ctx context.Context, inputCh <-chan DeleteURLItem
 
 		for item := range inputCh {
 			var id string
+			s.mu.Lock()
 			for _, v := range s.List {
-				if item.ShortURL == v.ShortURL && item.UserID.String() == v.UserID {
+				if item.ShortURL == v.ShortURL && item.Domain == v.Domain && item.UserID.String() == v.UserID {
 					id = v.UUID
 				}
 			}
+			s.mu.Unlock()
 			if id == "" {
 				continue
 			}
@@ -185,28 +271,373 @@ func (s *MemoryStorage) fanIn(ctx context.Context, ids ...chan string) chan stri
 	return delCh
 }
 
-func (s *MemoryStorage) softDeleteURLs(ctx context.Context, delCh chan string) {
+func (s *MemoryStorage) softDeleteURLs(ctx context.Context, delCh chan string) []url {
 	var idsForDeletion []string
 	for item := range delCh {
 		idsForDeletion = append(idsForDeletion, item)
 	}
 
 	if len(idsForDeletion) == 0 {
-		return
+		return nil
 	}
 
 	// лочим мьютекс
 	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// ищем айдишники и "удаляем"
+	var changed []url
 	for _, v := range s.List {
 		for _, w := range idsForDeletion {
-			if v.UUID == w {
+			if v.UUID == w && !v.IsDeleted {
 				v.IsDeleted = true
+				changed = append(changed, *v)
+			}
+		}
+	}
+	return changed
+}
+
+func (s *MemoryStorage) AddDomain(ctx context.Context, d Domain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.Domains {
+		if v.Name == d.Name {
+			return ErrDomainExists
+		}
+	}
+	s.Domains = append(s.Domains, d)
+	return nil
+}
+
+func (s *MemoryStorage) GetDomain(ctx context.Context, name string) (Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.domainLocked(name)
+	if d.Name == "" {
+		return Domain{}, ErrDomainNotFound
+	}
+	return d, nil
+}
+
+// domainLocked ищет домен по имени, вызывать под s.mu
+func (s *MemoryStorage) domainLocked(name string) Domain {
+	for _, v := range s.Domains {
+		if v.Name == name {
+			return v
+		}
+	}
+	return Domain{}
+}
+
+func (s *MemoryStorage) ListDomains(ctx context.Context) ([]Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Domain{}, s.Domains...), nil
+}
+
+func (s *MemoryStorage) DeleteDomain(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.List {
+		if v.Domain == name && !v.IsDeleted {
+			return ErrDomainInUse
+		}
+	}
+	for i, v := range s.Domains {
+		if v.Name == name {
+			s.Domains = append(s.Domains[:i], s.Domains[i+1:]...)
+			return nil
+		}
+	}
+	return ErrDomainNotFound
+}
+
+func (s *MemoryStorage) CreateUser(ctx context.Context, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.Users {
+		if v.Login == u.Login {
+			return ErrUserExists
+		}
+	}
+	s.Users = append(s.Users, u)
+	return nil
+}
+
+func (s *MemoryStorage) GetUserByLogin(ctx context.Context, login string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.Users {
+		if v.Login == login {
+			return v, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemoryStorage) SaveRefreshToken(ctx context.Context, t RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RefreshTokens[t.TokenHash] = t
+	return nil
+}
+
+func (s *MemoryStorage) ConsumeRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.RefreshTokens[tokenHash]
+	if !ok {
+		return uuid.Nil, ErrTokenInvalid
+	}
+	delete(s.RefreshTokens, tokenHash)
+	if time.Now().After(t.ExpiresAt) {
+		return uuid.Nil, ErrTokenInvalid
+	}
+	return t.UserID, nil
+}
+
+func (s *MemoryStorage) CreateAPIKey(ctx context.Context, k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.APIKeys = append(s.APIKeys, k)
+	return nil
+}
+
+func (s *MemoryStorage) GetAPIKey(ctx context.Context, keyHash string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.APIKeys {
+		if v.KeyHash == keyHash {
+			return v, nil
+		}
+	}
+	return APIKey{}, ErrKeyNotFound
+}
+
+func (s *MemoryStorage) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []APIKey
+	for _, v := range s.APIKeys {
+		if v.UserID == userID {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStorage) DeleteAPIKey(ctx context.Context, userID uuid.UUID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.APIKeys {
+		if v.ID == id && v.UserID == userID {
+			s.APIKeys = append(s.APIKeys[:i], s.APIKeys[i+1:]...)
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+func (s *MemoryStorage) TransferURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	changed := s.transferURLs(from, to)
+	return len(changed), nil
+}
+
+func (s *MemoryStorage) transferURLs(from, to uuid.UUID) []url {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []url
+	for _, v := range s.List {
+		if v.UserID == from.String() {
+			v.UserID = to.String()
+			changed = append(changed, *v)
+		}
+	}
+	return changed
+}
+
+func (s *MemoryStorage) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.Users {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemoryStorage) GetLink(ctx context.Context, code string) (Link, error) {
+	domain := DomainFromContext(ctx).Name
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.List {
+		if v.Domain == domain && v.ShortURL == code {
+			return v.link(), nil
+		}
+	}
+	return Link{}, ErrLinkNotFound
+}
+
+func (s *MemoryStorage) SearchLinks(ctx context.Context, f LinkFilter) ([]Link, error) {
+	f = f.Normalize()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := []Link{}
+	skipped := 0
+	for _, v := range s.List {
+		if !f.match(v) {
+			continue
+		}
+		if skipped < f.Offset {
+			skipped++
+			continue
+		}
+		result = append(result, v.link())
+		if len(result) == f.Limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStorage) SetLinkOptions(ctx context.Context, id string, opts LinkOptions) error {
+	_, err := s.setLinkOptions(id, opts)
+	return err
+}
+
+// setLinkOptions не сбрасывает Options в nil даже для пустых настроек:
+// ссылка, у которой они когда-то были, уже не участвует в дедупликации
+func (s *MemoryStorage) setLinkOptions(id string, opts LinkOptions) (url, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.List {
+		if v.UUID != id {
+			continue
+		}
+		if v.Options == nil && v.Shared {
+			return url{}, ErrLinkShared
+		}
+		v.Options = &opts
+		return *v, nil
+	}
+	return url{}, ErrLinkNotFound
+}
+
+func (s *MemoryStorage) UpdateLink(ctx context.Context, id string, upd LinkUpdate) error {
+	_, err := s.updateLinkFields(id, upd)
+	return err
+}
+
+// updateLinkFields вместе со сменой адреса обновляет индекс обычных ссылок
+func (s *MemoryStorage) updateLinkFields(id string, upd LinkUpdate) (url, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.List {
+		if v.UUID != id {
+			continue
+		}
+		moved := upd.OriginalURL != nil && *upd.OriginalURL != v.OriginalURL
+		// обычную ссылку, выданную другим пользователям, не меняют целиком, вместе с метаданными
+		if v.Options == nil && v.Shared && (moved || upd.Metadata != nil) {
+			return url{}, ErrLinkShared
+		}
+		if moved && v.Options == nil {
+			plain := s.plainLocked()
+			key := urlKey{domain: v.Domain, hash: urlHash(*upd.OriginalURL)}
+			if other, ok := plain[key]; ok && other != v && other.Options == nil {
+				return url{}, ErrURLExists
+			}
+			old := urlKey{domain: v.Domain, hash: urlHash(v.OriginalURL)}
+			if plain[old] == v {
+				delete(plain, old)
+			}
+			plain[key] = v
+		}
+		if upd.OriginalURL != nil {
+			v.OriginalURL = *upd.OriginalURL
+		}
+		if upd.Metadata != nil {
+			v.Metadata = upd.Metadata
+			if len(upd.Metadata) == 0 {
+				v.Metadata = nil
 			}
 		}
+		return *v, nil
 	}
+	return url{}, ErrLinkNotFound
+}
 
-	// убираем лок с мьютекса
-	s.mu.Unlock()
+func (s *MemoryStorage) ConsumeClick(ctx context.Context, id string) error {
+	_, err := s.consumeClick(id)
+	return err
+}
+
+// consumeClick увеличивает счётчик под s.mu и возвращает изменённую копию
+func (s *MemoryStorage) consumeClick(id string) (url, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.List {
+		if v.UUID != id {
+			continue
+		}
+		if v.Options != nil && v.Options.MaxClicks > 0 && v.Clicks >= v.Options.MaxClicks {
+			return url{}, ErrLinkExhausted
+		}
+		v.Clicks++
+		return *v, nil
+	}
+	return url{}, ErrLinkNotFound
+}
+
+// updateLink применяет fn к ссылке с данным id и возвращает изменённую копию
+func (s *MemoryStorage) updateLink(id string, fn func(u *url)) (url, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.List {
+		if v.UUID == id {
+			fn(v)
+			return *v, nil
+		}
+	}
+	return url{}, ErrLinkNotFound
+}
+
+func (s *MemoryStorage) DisableLink(ctx context.Context, id string, status int, reason string) error {
+	_, err := s.updateLink(id, disable(status, reason))
+	return err
+}
+
+func disable(status int, reason string) func(u *url) {
+	return func(u *url) {
+		u.DisabledStatus = status
+		u.DisabledReason = reason
+		if status == 0 {
+			u.DisabledReason = ""
+		}
+	}
+}
+
+func (s *MemoryStorage) TransferLink(ctx context.Context, id string, to uuid.UUID) error {
+	_, err := s.updateLink(id, func(u *url) { u.UserID = to.String() })
+	return err
+}
+
+func (s *MemoryStorage) DeleteDomainLinks(ctx context.Context, domain string) (int, error) {
+	changed := s.deleteDomainLinks(domain)
+	return len(changed), nil
+}
+
+func (s *MemoryStorage) deleteDomainLinks(domain string) []url {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []url
+	for _, v := range s.List {
+		if v.Domain == domain && !v.IsDeleted {
+			v.IsDeleted = true
+			changed = append(changed, *v)
+		}
+	}
+	return changed
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/google/uuid"
 	"github.com/morozoffnor/go-url-shortener/internal/auth"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
@@ -9,6 +10,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -49,15 +54,19 @@ func TestUrlStorage_addNewUrl(t *testing.T) {
 
 			for _, full := range test.urls {
 				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				ctx = context.WithValue(ctx, auth.ContextUserID, uuid.New())
+				ctx = auth.WithIdentity(ctx, auth.Identity{UserID: uuid.New()})
 				result, err := strg.AddNewURL(ctx, full)
 				defer cancel()
-				require.NoError(t, err)
 				assert.IsType(t, "", result)
 				log.Print(result)
+				// повтор отдаёт код существующей ссылки с ErrURLExists, как и Database
 				if len(lastResult) > 0 {
+					require.ErrorIs(t, err, ErrURLExists)
 					assert.Equal(t, result, lastResult)
+				} else {
+					require.NoError(t, err)
 				}
+				lastResult = result
 			}
 		})
 	}
@@ -101,12 +110,12 @@ func TestUrlStorage_getFullUrl(t *testing.T) {
 			defer tmpFile.Close()
 
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			ctx = context.WithValue(ctx, auth.ContextUserID, uuid.New())
+			ctx = auth.WithIdentity(ctx, auth.Identity{UserID: uuid.New()})
 			shortURL, _ := strg.AddNewURL(ctx, test.URLs[0].OriginalURL)
-			full, _, err := strg.GetFullURL(ctx, shortURL)
+			link, err := strg.GetLink(ctx, shortURL)
 			defer cancel()
 			if !test.wantErr {
-				require.Equal(t, "http://test.com", full)
+				require.Equal(t, "http://test.com", link.OriginalURL)
 			} else {
 				assert.Error(t, err)
 			}
@@ -114,3 +123,356 @@ func TestUrlStorage_getFullUrl(t *testing.T) {
 		})
 	}
 }
+
+func TestUrlStorage_domains(t *testing.T) {
+	cfg := &config.Config{
+		ServerAddr: ":8080",
+		ResultAddr: "http://localhost:8080",
+	}
+	strg := NewMemoryStorage(cfg)
+	brand := Domain{Name: "go.brand.com", BaseURL: "https://go.brand.com"}
+	require.NoError(t, strg.AddDomain(context.Background(), brand))
+	assert.ErrorIs(t, strg.AddDomain(context.Background(), brand), ErrDomainExists)
+
+	userID := uuid.New()
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: userID})
+	brandCtx := WithDomain(ctx, brand)
+
+	defCode, err := strg.AddNewURL(ctx, "http://test.com")
+	require.NoError(t, err)
+	brandCode, err := strg.AddNewURL(brandCtx, "http://test.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, defCode, brandCode, "the same url gets its own link on every domain")
+
+	// код ищется только в рамках своего домена
+	_, err = strg.GetLink(ctx, brandCode)
+	assert.ErrorIs(t, err, ErrLinkNotFound)
+	link, err := strg.GetLink(brandCtx, brandCode)
+	require.NoError(t, err)
+	assert.Equal(t, "http://test.com", link.OriginalURL)
+
+	urls, err := strg.GetUserURLs(ctx, userID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []UserURLs{
+		{ShortURL: "http://localhost:8080/" + defCode, OriginalURL: "http://test.com"},
+		{ShortURL: "https://go.brand.com/" + brandCode, OriginalURL: "http://test.com"},
+	}, urls)
+
+	out, err := strg.AddBatch(brandCtx, []BatchInput{{OriginalURL: "http://other.com", CorrelationID: "1"}})
+	require.NoError(t, err)
+	assert.Contains(t, out[0].ShortURL, "https://go.brand.com/")
+
+	assert.ErrorIs(t, strg.DeleteDomain(ctx, brand.Name), ErrDomainInUse)
+	// удаление ищет коды только в домене запроса
+	strg.DeleteURLs(ctx, userID, URLsForDeletion{brandCode})
+	assert.ErrorIs(t, strg.DeleteDomain(ctx, brand.Name), ErrDomainInUse)
+	strg.DeleteURLs(brandCtx, userID, URLsForDeletion{brandCode, strings.TrimPrefix(out[0].ShortURL, "https://go.brand.com/")})
+	require.NoError(t, strg.DeleteDomain(ctx, brand.Name))
+	assert.ErrorIs(t, strg.DeleteDomain(ctx, brand.Name), ErrDomainNotFound)
+}
+
+func newFileStorage(t *testing.T, cfg *config.Config) *FileStorage {
+	strg, err := NewFileStorage(cfg)
+	require.NoError(t, err)
+	return strg
+}
+
+func TestNewFileStorage_corruptedFile(t *testing.T) {
+	cfg := &config.Config{FileStoragePath: filepath.Join(t.TempDir(), "urls.json")}
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath, []byte(`{"uuid": "1"`), 0666))
+
+	_, err := NewFileStorage(cfg)
+	assert.ErrorContains(t, err, cfg.FileStoragePath)
+}
+
+func TestStorage_longURL(t *testing.T) {
+	cfg := &config.Config{
+		ResultAddr:      "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "storage.json"),
+	}
+	long := "https://tracker.example.com/click?payload=" + strings.Repeat("a", 10_000)
+	other := long[:len(long)-1] + "b"
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	tests := []struct {
+		name   string
+		strg   Storage
+		reload func() Storage
+	}{
+		{name: "memory", strg: NewMemoryStorage(cfg)},
+		{name: "file", strg: newFileStorage(t, cfg), reload: func() Storage { return newFileStorage(t, cfg) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := test.strg.AddNewURL(ctx, long)
+			require.NoError(t, err)
+			again, err := test.strg.AddNewURL(ctx, long)
+			assert.ErrorIs(t, err, ErrURLExists)
+			assert.Equal(t, code, again)
+			// адрес, отличающийся последним символом, — другая ссылка
+			otherCode, err := test.strg.AddNewURL(ctx, other)
+			require.NoError(t, err)
+			assert.NotEqual(t, code, otherCode)
+
+			link, err := test.strg.GetLink(ctx, code)
+			require.NoError(t, err)
+			assert.Equal(t, long, link.OriginalURL)
+
+			// ссылка, получившая настройки, больше не участвует в дедупликации
+			require.NoError(t, test.strg.SetLinkOptions(ctx, link.ID, LinkOptions{MaxClicks: 5}))
+			fresh, err := test.strg.AddNewURL(ctx, long)
+			require.NoError(t, err)
+			assert.NotEqual(t, code, fresh)
+
+			if test.reload != nil {
+				again, err := test.reload().AddNewURL(ctx, long)
+				assert.ErrorIs(t, err, ErrURLExists)
+				assert.Equal(t, fresh, again)
+			}
+		})
+	}
+}
+
+func TestFileStorage_moderation(t *testing.T) {
+	cfg := &config.Config{
+		ResultAddr:      "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "urls.json"),
+	}
+	strg := newFileStorage(t, cfg)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+	code, err := strg.AddNewURL(ctx, "http://test.com")
+	require.NoError(t, err)
+	link, err := strg.GetLink(ctx, code)
+	require.NoError(t, err)
+
+	newOwner := uuid.New()
+	require.NoError(t, strg.DisableLink(ctx, link.ID, 451, "court order"))
+	require.NoError(t, strg.TransferLink(ctx, link.ID, newOwner))
+	assert.ErrorIs(t, strg.DisableLink(ctx, "missing", 410, "spam"), ErrLinkNotFound)
+
+	// изменения переживают перезапуск
+	reloaded := newFileStorage(t, cfg)
+	got, err := reloaded.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, 451, got.DisabledStatus)
+	assert.Equal(t, "court order", got.DisabledReason)
+	assert.Equal(t, newOwner.String(), got.UserID)
+
+	links, err := reloaded.SearchLinks(ctx, LinkFilter{Owner: newOwner.String()})
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+}
+
+func TestFileStorage_linkOptions(t *testing.T) {
+	cfg := &config.Config{
+		ResultAddr:      "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "storage.json"),
+	}
+	strg := newFileStorage(t, cfg)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	plain, err := strg.AddNewURL(ctx, "http://docs.internal/")
+	require.NoError(t, err)
+	// защищённая ссылка не склеивается ни с обычной, ни с другой защищённой
+	first, err := strg.AddNewURLWithOptions(ctx, "http://docs.internal/", LinkOptions{PasswordHash: "hash"})
+	require.NoError(t, err)
+	second, err := strg.AddNewURLWithOptions(ctx, "http://docs.internal/", LinkOptions{PasswordHash: "hash"})
+	require.NoError(t, err)
+	assert.NotEqual(t, plain, first)
+	assert.NotEqual(t, first, second)
+	again, err := strg.AddNewURL(ctx, "http://docs.internal/")
+	assert.ErrorIs(t, err, ErrURLExists)
+	assert.Equal(t, plain, again)
+
+	reloaded := newFileStorage(t, cfg)
+	link, err := reloaded.GetLink(ctx, first)
+	require.NoError(t, err)
+	assert.True(t, link.HasPassword())
+	public := link.Public()
+	assert.True(t, public.Protected)
+	assert.Empty(t, public.Options.PasswordHash)
+	assert.True(t, link.HasPassword(), "Public must not modify the original link")
+}
+
+func TestFileStorage_updateLink(t *testing.T) {
+	cfg := &config.Config{
+		ResultAddr:      "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "storage.json"),
+	}
+	strg := newFileStorage(t, cfg)
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+	code, err := strg.AddNewURL(ctx, "http://a.com/")
+	require.NoError(t, err)
+	_, err = strg.AddNewURL(ctx, "http://b.com/")
+	require.NoError(t, err)
+	link, err := strg.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.False(t, link.CreatedAt.IsZero())
+
+	moved := "http://c.com/"
+	require.NoError(t, strg.UpdateLink(ctx, link.ID, LinkUpdate{OriginalURL: &moved, Metadata: map[string]string{"team": "growth"}}))
+	// старый адрес освободился, новый склеивается с изменённой ссылкой
+	again, err := strg.AddNewURL(ctx, "http://c.com/")
+	assert.ErrorIs(t, err, ErrURLExists)
+	assert.Equal(t, code, again)
+	fresh, err := strg.AddNewURL(ctx, "http://a.com/")
+	require.NoError(t, err)
+	assert.NotEqual(t, code, fresh)
+
+	busy := "http://b.com/"
+	assert.ErrorIs(t, strg.UpdateLink(ctx, link.ID, LinkUpdate{OriginalURL: &busy}), ErrURLExists)
+	assert.ErrorIs(t, strg.UpdateLink(ctx, "missing", LinkUpdate{}), ErrLinkNotFound)
+
+	reloaded := newFileStorage(t, cfg)
+	got, err := reloaded.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, moved, got.OriginalURL)
+	assert.Equal(t, map[string]string{"team": "growth"}, got.Metadata)
+	assert.Equal(t, link.CreatedAt.Unix(), got.CreatedAt.Unix())
+
+	// пустые метаданные очищают прежние
+	require.NoError(t, reloaded.UpdateLink(ctx, link.ID, LinkUpdate{Metadata: map[string]string{}}))
+	got, err = reloaded.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.Nil(t, got.Metadata)
+
+	links, err := reloaded.SearchLinks(ctx, LinkFilter{ID: link.ID})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, code, links[0].Code)
+}
+
+func TestFileStorage_sharedLink(t *testing.T) {
+	cfg := &config.Config{
+		ResultAddr:      "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "storage.json"),
+	}
+	strg := newFileStorage(t, cfg)
+	owner := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+	other := auth.WithIdentity(context.Background(), auth.Identity{UserID: uuid.New()})
+
+	// повторное сокращение тем же владельцем ссылку не выдаёт другим
+	own, err := strg.AddNewURL(owner, "http://own.com/")
+	require.NoError(t, err)
+	_, err = strg.AddNewURL(owner, "http://own.com/")
+	assert.ErrorIs(t, err, ErrURLExists)
+	ownLink, err := strg.GetLink(owner, own)
+	require.NoError(t, err)
+	moved := "http://own.com/moved"
+	require.NoError(t, strg.UpdateLink(owner, ownLink.ID, LinkUpdate{OriginalURL: &moved}))
+
+	code, err := strg.AddNewURL(owner, "http://shared.com/")
+	require.NoError(t, err)
+	again, err := strg.AddNewURL(other, "http://shared.com/")
+	assert.ErrorIs(t, err, ErrURLExists)
+	require.Equal(t, code, again)
+
+	// отметка переживает перезапуск
+	reloaded := newFileStorage(t, cfg)
+	link, err := reloaded.GetLink(owner, code)
+	require.NoError(t, err)
+	hijack := "http://evil.com/"
+	assert.ErrorIs(t, reloaded.UpdateLink(owner, link.ID, LinkUpdate{OriginalURL: &hijack}), ErrLinkShared)
+	assert.ErrorIs(t, reloaded.SetLinkOptions(owner, link.ID, LinkOptions{MaxClicks: 1}), ErrLinkShared)
+	assert.ErrorIs(t, reloaded.UpdateLink(owner, link.ID, LinkUpdate{Metadata: map[string]string{"team": "growth"}}), ErrLinkShared)
+
+	got, err := reloaded.GetLink(other, code)
+	require.NoError(t, err)
+	assert.Equal(t, "http://shared.com/", got.OriginalURL)
+	assert.Nil(t, got.Options)
+	assert.Nil(t, got.Metadata)
+}
+
+func TestStorage_consumeClickConcurrently(t *testing.T) {
+	cfg := &config.Config{
+		ResultAddr:      "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "storage.json"),
+	}
+	tests := []struct {
+		name string
+		strg Storage
+	}{
+		{name: "memory", strg: NewMemoryStorage(cfg)},
+		{name: "file", strg: newFileStorage(t, cfg)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			code, err := test.strg.AddNewURLWithOptions(ctx, "http://invite.com/", LinkOptions{MaxClicks: 3})
+			require.NoError(t, err)
+			link, err := test.strg.GetLink(ctx, code)
+			require.NoError(t, err)
+
+			var ok atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := test.strg.ConsumeClick(ctx, link.ID)
+					if err == nil {
+						ok.Add(1)
+						return
+					}
+					assert.ErrorIs(t, err, ErrLinkExhausted)
+				}()
+			}
+			wg.Wait()
+			assert.EqualValues(t, 3, ok.Load())
+
+			link, err = test.strg.GetLink(ctx, code)
+			require.NoError(t, err)
+			assert.True(t, link.Exhausted())
+		})
+	}
+
+	// счётчик переживает перезапуск файлового хранилища
+	links, err := newFileStorage(t, cfg).SearchLinks(context.Background(), LinkFilter{Destination: "invite.com"})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, 3, links[0].Clicks)
+}
+
+func TestFileStorage_compactOnLoad(t *testing.T) {
+	cfg := &config.Config{
+		ResultAddr:      "http://localhost:8080",
+		FileStoragePath: filepath.Join(t.TempDir(), "storage.json"),
+	}
+	records := func() int {
+		file, err := os.Open(cfg.FileStoragePath)
+		require.NoError(t, err)
+		defer file.Close()
+		n := 0
+		for decoder := json.NewDecoder(file); decoder.More(); n++ {
+			var u url
+			require.NoError(t, decoder.Decode(&u))
+		}
+		return n
+	}
+
+	strg := newFileStorage(t, cfg)
+	ctx := context.Background()
+	code, err := strg.AddNewURLWithOptions(ctx, "http://invite.com/", LinkOptions{MaxClicks: 10})
+	require.NoError(t, err)
+	_, err = strg.AddNewURL(ctx, "http://plain.com/")
+	require.NoError(t, err)
+	link, err := strg.GetLink(ctx, code)
+	require.NoError(t, err)
+	for range 5 {
+		require.NoError(t, strg.ConsumeClick(ctx, link.ID))
+	}
+	assert.Equal(t, 7, records())
+
+	// при загрузке от переходов остаётся одна запись на ссылку
+	reloaded := newFileStorage(t, cfg)
+	assert.Equal(t, 2, records())
+	link, err = reloaded.GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, 5, link.Clicks)
+	require.NoError(t, reloaded.ConsumeClick(ctx, link.ID))
+
+	link, err = newFileStorage(t, cfg).GetLink(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, 6, link.Clicks)
+	assert.NoFileExists(t, cfg.FileStoragePath+".tmp")
+}
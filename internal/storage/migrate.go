@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"io/fs"
+	"sort"
+)
+
+// migrationsFS зашит в бинарник, поэтому миграции не зависят от рабочей директории
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// Migration — миграция из бинарника и её состояние в базе
+type Migration struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// MigrationState — текущая версия схемы. Dirty значит, что миграция Version
+// упала на середине и перед следующим запуском её нужно поправить и вызвать Force
+type MigrationState struct {
+	Version uint
+	Dirty   bool
+	// Migrations — все известные миграции по возрастанию версии
+	Migrations []Migration
+}
+
+// Migrator управляет схемой базы (см. команду shortener migrate)
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+func NewMigrator(dsn string) (*Migrator, error) {
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrate.NewWithSourceInstance("iofs", src, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return &Migrator{m: m}, nil
+}
+
+// Up применяет все непримененные миграции
+func (m *Migrator) Up() error {
+	return ignoreNoChange(m.m.Up())
+}
+
+// Down откатывает n последних миграций
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return errors.New("number of migrations to roll back must be positive")
+	}
+	return ignoreNoChange(m.m.Steps(-n))
+}
+
+// Force записывает версию без выполнения миграций и снимает признак dirty
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// State возвращает версию схемы и список миграций с отметкой о применении
+func (m *Migrator) State() (MigrationState, error) {
+	var st MigrationState
+	version, dirty, err := m.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return st, err
+	}
+	st.Version, st.Dirty = version, dirty
+
+	st.Migrations, err = embeddedMigrations()
+	if err != nil {
+		return st, err
+	}
+	for i := range st.Migrations {
+		mg := &st.Migrations[i]
+		// грязная миграция применена не полностью
+		mg.Applied = mg.Version < version || (mg.Version == version && !dirty)
+	}
+	return st, nil
+}
+
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+func embeddedMigrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationsFS, "migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Migration, 0, len(names))
+	for _, name := range names {
+		parsed, err := source.Parse(name[len("migrations/"):])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", name, err)
+		}
+		list = append(list, Migration{Version: parsed.Version, Name: parsed.Identifier})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// MigrateUp применяет миграции при старте сервера (см. config.AutoMigrate)
+func MigrateUp(dsn string) error {
+	m, err := NewMigrator(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	return m.Up()
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"testing"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	list, err := embeddedMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+	assert.Equal(t, Migration{Version: 1, Name: "create_urls_table"}, list[0])
+	// версии идут подряд, у каждой up-миграции есть down
+	for i, m := range list {
+		assert.Equal(t, uint(i+1), m.Version)
+		_, err := fs.Stat(migrationsFS, fmt.Sprintf("migrations/%06d_%s.down.sql", m.Version, m.Name))
+		assert.NoError(t, err)
+	}
+}
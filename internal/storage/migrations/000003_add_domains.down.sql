@@ -0,0 +1,17 @@
+BEGIN;
+    -- ссылкам брендовых доменов в прежней схеме места нет, а удалять чужие ссылки
+    -- откат не вправе: такие ссылки нужно сначала разобрать вручную
+    DO $$
+    BEGIN
+        IF EXISTS (SELECT 1 FROM urls WHERE domain <> '') THEN
+            RAISE EXCEPTION 'urls contains links of custom domains, cannot revert to a single domain';
+        END IF;
+    END
+    $$;
+    ALTER TABLE urls DROP CONSTRAINT urls_domain_short_url_key;
+    ALTER TABLE urls DROP CONSTRAINT urls_domain_full_url_key;
+    ALTER TABLE urls ADD CONSTRAINT urls_short_url_key UNIQUE (short_url);
+    ALTER TABLE urls ADD CONSTRAINT urls_full_url_key UNIQUE (full_url);
+    ALTER TABLE urls DROP COLUMN domain;
+    DROP TABLE IF EXISTS domains;
+COMMIT;
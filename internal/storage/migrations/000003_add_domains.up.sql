@@ -0,0 +1,11 @@
+BEGIN;
+    CREATE TABLE IF NOT EXISTS domains(
+        name varchar(255) PRIMARY KEY,
+        base_url varchar(500) NOT NULL
+    );
+    ALTER TABLE urls ADD COLUMN domain varchar(255) NOT NULL DEFAULT '';
+    ALTER TABLE urls DROP CONSTRAINT urls_full_url_key;
+    ALTER TABLE urls DROP CONSTRAINT urls_short_url_key;
+    ALTER TABLE urls ADD CONSTRAINT urls_domain_full_url_key UNIQUE (domain, full_url);
+    ALTER TABLE urls ADD CONSTRAINT urls_domain_short_url_key UNIQUE (domain, short_url);
+COMMIT;
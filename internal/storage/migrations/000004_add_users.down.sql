@@ -0,0 +1,6 @@
+BEGIN;
+    DROP INDEX IF EXISTS urls_user_id_idx;
+    DROP TABLE IF EXISTS refresh_tokens;
+    DROP TABLE IF EXISTS api_keys;
+    DROP TABLE IF EXISTS users;
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+    CREATE TABLE IF NOT EXISTS users(
+        id varchar(255) PRIMARY KEY,
+        login varchar(255) UNIQUE NOT NULL,
+        password_hash varchar(255) NOT NULL,
+        created_at timestamptz NOT NULL DEFAULT now()
+    );
+    CREATE TABLE IF NOT EXISTS api_keys(
+        id varchar(255) PRIMARY KEY,
+        user_id varchar(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        name varchar(255) NOT NULL DEFAULT '',
+        key_hash varchar(64) UNIQUE NOT NULL,
+        created_at timestamptz NOT NULL DEFAULT now()
+    );
+    CREATE TABLE IF NOT EXISTS refresh_tokens(
+        token_hash varchar(64) PRIMARY KEY,
+        user_id varchar(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        expires_at timestamptz NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN;
+    ALTER TABLE urls DROP COLUMN IF EXISTS disabled_reason;
+    ALTER TABLE urls DROP COLUMN IF EXISTS disabled_status;
+COMMIT;
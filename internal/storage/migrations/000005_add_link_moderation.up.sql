@@ -0,0 +1,4 @@
+BEGIN;
+    ALTER TABLE urls ADD COLUMN disabled_status integer NOT NULL DEFAULT 0;
+    ALTER TABLE urls ADD COLUMN disabled_reason text NOT NULL DEFAULT '';
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+    CREATE TABLE IF NOT EXISTS audit_events(
+        id varchar(255) PRIMARY KEY,
+        time timestamptz NOT NULL,
+        action varchar(64) NOT NULL,
+        user_id varchar(255) NOT NULL DEFAULT '',
+        role varchar(32) NOT NULL DEFAULT '',
+        request_id varchar(255) NOT NULL DEFAULT '',
+        client_ip varchar(64) NOT NULL DEFAULT '',
+        target text NOT NULL DEFAULT '',
+        details jsonb
+    );
+    CREATE INDEX IF NOT EXISTS audit_events_time_idx ON audit_events (time);
+    CREATE INDEX IF NOT EXISTS audit_events_user_id_idx ON audit_events (user_id);
+    CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target);
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+    -- без options ссылки с настройками потеряют пароль и ограничения,
+    -- поэтому откат отказывается, пока такие ссылки не разобраны вручную
+    DO $$
+    BEGIN
+        IF EXISTS (SELECT 1 FROM urls WHERE options IS NOT NULL) THEN
+            RAISE EXCEPTION 'urls contains links with options, cannot drop the options column';
+        END IF;
+    END
+    $$;
+    DROP INDEX IF EXISTS urls_domain_full_url_key;
+    ALTER TABLE urls ADD CONSTRAINT urls_domain_full_url_key UNIQUE (domain, full_url);
+    ALTER TABLE urls DROP COLUMN options;
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+    ALTER TABLE urls ADD COLUMN options jsonb;
+    -- ссылки с настройками (пароль и т.п.) всегда создаются заново,
+    -- поэтому адрес уникален только среди обычных ссылок
+    ALTER TABLE urls DROP CONSTRAINT urls_domain_full_url_key;
+    CREATE UNIQUE INDEX urls_domain_full_url_key ON urls (domain, full_url) WHERE options IS NULL;
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+    ALTER TABLE urls ADD COLUMN clicks integer NOT NULL DEFAULT 0;
+COMMIT;
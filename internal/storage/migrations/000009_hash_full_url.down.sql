@@ -0,0 +1,15 @@
+BEGIN;
+    -- длинные адреса в прежнюю схему не помещаются, а удалять чужие ссылки откат не вправе:
+    -- такие ссылки нужно сначала разобрать вручную
+    DO $$
+    BEGIN
+        IF EXISTS (SELECT 1 FROM urls WHERE length(full_url) > 500) THEN
+            RAISE EXCEPTION 'urls contains full_url longer than 500 characters, cannot revert to varchar(500)';
+        END IF;
+    END
+    $$;
+    DROP INDEX IF EXISTS urls_domain_full_url_hash_key;
+    ALTER TABLE urls DROP COLUMN full_url_hash;
+    ALTER TABLE urls ALTER COLUMN full_url TYPE varchar(500);
+    CREATE UNIQUE INDEX urls_domain_full_url_key ON urls (domain, full_url) WHERE options IS NULL;
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+    -- адрес хранится без ограничения длины, а уникальность проверяется по его sha256:
+    -- в btree-индекс по самому адресу длинные строки не помещаются
+    ALTER TABLE urls ALTER COLUMN full_url TYPE text;
+    ALTER TABLE urls ADD COLUMN full_url_hash bytea;
+    UPDATE urls SET full_url_hash = sha256(convert_to(full_url, 'UTF8'));
+    ALTER TABLE urls ALTER COLUMN full_url_hash SET NOT NULL;
+    ALTER TABLE urls ADD CONSTRAINT urls_full_url_hash_size CHECK (length(full_url_hash) = 32);
+    DROP INDEX urls_domain_full_url_key;
+    CREATE UNIQUE INDEX urls_domain_full_url_hash_key ON urls (domain, full_url_hash) WHERE options IS NULL;
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+    DROP INDEX urls_user_id_created_at_idx;
+    ALTER TABLE urls DROP COLUMN metadata;
+    ALTER TABLE urls DROP COLUMN created_at;
+COMMIT;
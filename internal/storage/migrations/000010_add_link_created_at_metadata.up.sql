@@ -0,0 +1,6 @@
+BEGIN;
+    -- у существующих ссылок время создания неизвестно, им достаётся время миграции
+    ALTER TABLE urls ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
+    ALTER TABLE urls ADD COLUMN metadata jsonb;
+    CREATE INDEX urls_user_id_created_at_idx ON urls (user_id, created_at);
+COMMIT;
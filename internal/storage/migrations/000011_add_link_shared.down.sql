@@ -0,0 +1,3 @@
+BEGIN;
+    ALTER TABLE urls DROP COLUMN shared;
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+    -- shared отмечает обычные ссылки, которые дедупликация выдала другим пользователям:
+    -- их адрес, настройки и метаданные владелец менять не может. Кому выдавались
+    -- уже существующие ссылки, неизвестно, поэтому они начинают с false, как и записи
+    -- файлового хранилища
+    ALTER TABLE urls ADD COLUMN shared boolean NOT NULL DEFAULT false;
+COMMIT;
@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
 	storage "github.com/morozoffnor/go-url-shortener/internal/storage"
 )
 
@@ -50,6 +51,20 @@ func (mr *MockStorageMockRecorder) AddBatch(ctx, urls interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBatch", reflect.TypeOf((*MockStorage)(nil).AddBatch), ctx, urls)
 }
 
+// AddDomain mocks base method.
+func (m *MockStorage) AddDomain(ctx context.Context, d storage.Domain) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddDomain", ctx, d)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddDomain indicates an expected call of AddDomain.
+func (mr *MockStorageMockRecorder) AddDomain(ctx, d interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddDomain", reflect.TypeOf((*MockStorage)(nil).AddDomain), ctx, d)
+}
+
 // AddNewURL mocks base method.
 func (m *MockStorage) AddNewURL(ctx context.Context, full string) (string, error) {
 	m.ctrl.T.Helper()
@@ -65,23 +80,630 @@ func (mr *MockStorageMockRecorder) AddNewURL(ctx, full interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddNewURL", reflect.TypeOf((*MockStorage)(nil).AddNewURL), ctx, full)
 }
 
-// GetFullURL mocks base method.
-func (m *MockStorage) GetFullURL(ctx context.Context, shortURL string) (string, error) {
+// AddNewURLWithOptions mocks base method.
+func (m *MockStorage) AddNewURLWithOptions(ctx context.Context, full string, opts storage.LinkOptions) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetFullURL", ctx, shortURL)
+	ret := m.ctrl.Call(m, "AddNewURLWithOptions", ctx, full, opts)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetFullURL indicates an expected call of GetFullURL.
-func (mr *MockStorageMockRecorder) GetFullURL(ctx, shortURL interface{}) *gomock.Call {
+// AddNewURLWithOptions indicates an expected call of AddNewURLWithOptions.
+func (mr *MockStorageMockRecorder) AddNewURLWithOptions(ctx, full, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddNewURLWithOptions", reflect.TypeOf((*MockStorage)(nil).AddNewURLWithOptions), ctx, full, opts)
+}
+
+// ConsumeClick mocks base method.
+func (m *MockStorage) ConsumeClick(ctx context.Context, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeClick", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConsumeClick indicates an expected call of ConsumeClick.
+func (mr *MockStorageMockRecorder) ConsumeClick(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeClick", reflect.TypeOf((*MockStorage)(nil).ConsumeClick), ctx, id)
+}
+
+// ConsumeRefreshToken mocks base method.
+func (m *MockStorage) ConsumeRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeRefreshToken", ctx, tokenHash)
+	ret0, _ := ret[0].(uuid.UUID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeRefreshToken indicates an expected call of ConsumeRefreshToken.
+func (mr *MockStorageMockRecorder) ConsumeRefreshToken(ctx, tokenHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeRefreshToken", reflect.TypeOf((*MockStorage)(nil).ConsumeRefreshToken), ctx, tokenHash)
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockStorage) CreateAPIKey(ctx context.Context, k storage.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", ctx, k)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockStorageMockRecorder) CreateAPIKey(ctx, k interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockStorage)(nil).CreateAPIKey), ctx, k)
+}
+
+// CreateUser mocks base method.
+func (m *MockStorage) CreateUser(ctx context.Context, u storage.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUser", ctx, u)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUser indicates an expected call of CreateUser.
+func (mr *MockStorageMockRecorder) CreateUser(ctx, u interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockStorage)(nil).CreateUser), ctx, u)
+}
+
+// DeleteAPIKey mocks base method.
+func (m *MockStorage) DeleteAPIKey(ctx context.Context, userID uuid.UUID, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAPIKey", ctx, userID, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAPIKey indicates an expected call of DeleteAPIKey.
+func (mr *MockStorageMockRecorder) DeleteAPIKey(ctx, userID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockStorage)(nil).DeleteAPIKey), ctx, userID, id)
+}
+
+// DeleteDomain mocks base method.
+func (m *MockStorage) DeleteDomain(ctx context.Context, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteDomain", ctx, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteDomain indicates an expected call of DeleteDomain.
+func (mr *MockStorageMockRecorder) DeleteDomain(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDomain", reflect.TypeOf((*MockStorage)(nil).DeleteDomain), ctx, name)
+}
+
+// DeleteDomainLinks mocks base method.
+func (m *MockStorage) DeleteDomainLinks(ctx context.Context, domain string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteDomainLinks", ctx, domain)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteDomainLinks indicates an expected call of DeleteDomainLinks.
+func (mr *MockStorageMockRecorder) DeleteDomainLinks(ctx, domain interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDomainLinks", reflect.TypeOf((*MockStorage)(nil).DeleteDomainLinks), ctx, domain)
+}
+
+// DeleteURLs mocks base method.
+func (m *MockStorage) DeleteURLs(ctx context.Context, userID uuid.UUID, urls storage.URLsForDeletion) storage.URLsForDeletion {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteURLs", ctx, userID, urls)
+	ret0, _ := ret[0].(storage.URLsForDeletion)
+	return ret0
+}
+
+// DeleteURLs indicates an expected call of DeleteURLs.
+func (mr *MockStorageMockRecorder) DeleteURLs(ctx, userID, urls interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteURLs", reflect.TypeOf((*MockStorage)(nil).DeleteURLs), ctx, userID, urls)
+}
+
+// DisableLink mocks base method.
+func (m *MockStorage) DisableLink(ctx context.Context, id string, status int, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableLink", ctx, id, status, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableLink indicates an expected call of DisableLink.
+func (mr *MockStorageMockRecorder) DisableLink(ctx, id, status, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableLink", reflect.TypeOf((*MockStorage)(nil).DisableLink), ctx, id, status, reason)
+}
+
+// GetAPIKey mocks base method.
+func (m *MockStorage) GetAPIKey(ctx context.Context, keyHash string) (storage.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKey", ctx, keyHash)
+	ret0, _ := ret[0].(storage.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKey indicates an expected call of GetAPIKey.
+func (mr *MockStorageMockRecorder) GetAPIKey(ctx, keyHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKey", reflect.TypeOf((*MockStorage)(nil).GetAPIKey), ctx, keyHash)
+}
+
+// GetDomain mocks base method.
+func (m *MockStorage) GetDomain(ctx context.Context, name string) (storage.Domain, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDomain", ctx, name)
+	ret0, _ := ret[0].(storage.Domain)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDomain indicates an expected call of GetDomain.
+func (mr *MockStorageMockRecorder) GetDomain(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDomain", reflect.TypeOf((*MockStorage)(nil).GetDomain), ctx, name)
+}
+
+// GetLink mocks base method.
+func (m *MockStorage) GetLink(ctx context.Context, code string) (storage.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLink", ctx, code)
+	ret0, _ := ret[0].(storage.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLink indicates an expected call of GetLink.
+func (mr *MockStorageMockRecorder) GetLink(ctx, code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLink", reflect.TypeOf((*MockStorage)(nil).GetLink), ctx, code)
+}
+
+// GetUser mocks base method.
+func (m *MockStorage) GetUser(ctx context.Context, id uuid.UUID) (storage.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUser", ctx, id)
+	ret0, _ := ret[0].(storage.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUser indicates an expected call of GetUser.
+func (mr *MockStorageMockRecorder) GetUser(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockStorage)(nil).GetUser), ctx, id)
+}
+
+// GetUserByLogin mocks base method.
+func (m *MockStorage) GetUserByLogin(ctx context.Context, login string) (storage.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByLogin", ctx, login)
+	ret0, _ := ret[0].(storage.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByLogin indicates an expected call of GetUserByLogin.
+func (mr *MockStorageMockRecorder) GetUserByLogin(ctx, login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByLogin", reflect.TypeOf((*MockStorage)(nil).GetUserByLogin), ctx, login)
+}
+
+// GetUserURLs mocks base method.
+func (m *MockStorage) GetUserURLs(ctx context.Context, userID uuid.UUID) ([]storage.UserURLs, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserURLs", ctx, userID)
+	ret0, _ := ret[0].([]storage.UserURLs)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserURLs indicates an expected call of GetUserURLs.
+func (mr *MockStorageMockRecorder) GetUserURLs(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLs", reflect.TypeOf((*MockStorage)(nil).GetUserURLs), ctx, userID)
+}
+
+// ListAPIKeys mocks base method.
+func (m *MockStorage) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]storage.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAPIKeys", ctx, userID)
+	ret0, _ := ret[0].([]storage.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAPIKeys indicates an expected call of ListAPIKeys.
+func (mr *MockStorageMockRecorder) ListAPIKeys(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAPIKeys", reflect.TypeOf((*MockStorage)(nil).ListAPIKeys), ctx, userID)
+}
+
+// ListDomains mocks base method.
+func (m *MockStorage) ListDomains(ctx context.Context) ([]storage.Domain, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDomains", ctx)
+	ret0, _ := ret[0].([]storage.Domain)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDomains indicates an expected call of ListDomains.
+func (mr *MockStorageMockRecorder) ListDomains(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDomains", reflect.TypeOf((*MockStorage)(nil).ListDomains), ctx)
+}
+
+// SaveRefreshToken mocks base method.
+func (m *MockStorage) SaveRefreshToken(ctx context.Context, t storage.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRefreshToken", ctx, t)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRefreshToken indicates an expected call of SaveRefreshToken.
+func (mr *MockStorageMockRecorder) SaveRefreshToken(ctx, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRefreshToken", reflect.TypeOf((*MockStorage)(nil).SaveRefreshToken), ctx, t)
+}
+
+// SearchLinks mocks base method.
+func (m *MockStorage) SearchLinks(ctx context.Context, f storage.LinkFilter) ([]storage.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchLinks", ctx, f)
+	ret0, _ := ret[0].([]storage.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchLinks indicates an expected call of SearchLinks.
+func (mr *MockStorageMockRecorder) SearchLinks(ctx, f interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchLinks", reflect.TypeOf((*MockStorage)(nil).SearchLinks), ctx, f)
+}
+
+// SetLinkOptions mocks base method.
+func (m *MockStorage) SetLinkOptions(ctx context.Context, id string, opts storage.LinkOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLinkOptions", ctx, id, opts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLinkOptions indicates an expected call of SetLinkOptions.
+func (mr *MockStorageMockRecorder) SetLinkOptions(ctx, id, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLinkOptions", reflect.TypeOf((*MockStorage)(nil).SetLinkOptions), ctx, id, opts)
+}
+
+// TransferLink mocks base method.
+func (m *MockStorage) TransferLink(ctx context.Context, id string, to uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferLink", ctx, id, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TransferLink indicates an expected call of TransferLink.
+func (mr *MockStorageMockRecorder) TransferLink(ctx, id, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferLink", reflect.TypeOf((*MockStorage)(nil).TransferLink), ctx, id, to)
+}
+
+// TransferURLs mocks base method.
+func (m *MockStorage) TransferURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferURLs", ctx, from, to)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransferURLs indicates an expected call of TransferURLs.
+func (mr *MockStorageMockRecorder) TransferURLs(ctx, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferURLs", reflect.TypeOf((*MockStorage)(nil).TransferURLs), ctx, from, to)
+}
+
+// UpdateLink mocks base method.
+func (m *MockStorage) UpdateLink(ctx context.Context, id string, upd storage.LinkUpdate) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateLink", ctx, id, upd)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateLink indicates an expected call of UpdateLink.
+func (mr *MockStorageMockRecorder) UpdateLink(ctx, id, upd interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFullURL", reflect.TypeOf((*MockStorage)(nil).GetFullURL), ctx, shortURL)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLink", reflect.TypeOf((*MockStorage)(nil).UpdateLink), ctx, id, upd)
+}
+
+// MockUsers is a mock of Users interface.
+type MockUsers struct {
+	ctrl     *gomock.Controller
+	recorder *MockUsersMockRecorder
+}
+
+// MockUsersMockRecorder is the mock recorder for MockUsers.
+type MockUsersMockRecorder struct {
+	mock *MockUsers
+}
+
+// NewMockUsers creates a new mock instance.
+func NewMockUsers(ctrl *gomock.Controller) *MockUsers {
+	mock := &MockUsers{ctrl: ctrl}
+	mock.recorder = &MockUsersMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUsers) EXPECT() *MockUsersMockRecorder {
+	return m.recorder
+}
+
+// ConsumeRefreshToken mocks base method.
+func (m *MockUsers) ConsumeRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeRefreshToken", ctx, tokenHash)
+	ret0, _ := ret[0].(uuid.UUID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeRefreshToken indicates an expected call of ConsumeRefreshToken.
+func (mr *MockUsersMockRecorder) ConsumeRefreshToken(ctx, tokenHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeRefreshToken", reflect.TypeOf((*MockUsers)(nil).ConsumeRefreshToken), ctx, tokenHash)
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockUsers) CreateAPIKey(ctx context.Context, k storage.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", ctx, k)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockUsersMockRecorder) CreateAPIKey(ctx, k interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockUsers)(nil).CreateAPIKey), ctx, k)
+}
+
+// CreateUser mocks base method.
+func (m *MockUsers) CreateUser(ctx context.Context, u storage.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUser", ctx, u)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUser indicates an expected call of CreateUser.
+func (mr *MockUsersMockRecorder) CreateUser(ctx, u interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockUsers)(nil).CreateUser), ctx, u)
+}
+
+// DeleteAPIKey mocks base method.
+func (m *MockUsers) DeleteAPIKey(ctx context.Context, userID uuid.UUID, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAPIKey", ctx, userID, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAPIKey indicates an expected call of DeleteAPIKey.
+func (mr *MockUsersMockRecorder) DeleteAPIKey(ctx, userID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockUsers)(nil).DeleteAPIKey), ctx, userID, id)
+}
+
+// GetAPIKey mocks base method.
+func (m *MockUsers) GetAPIKey(ctx context.Context, keyHash string) (storage.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKey", ctx, keyHash)
+	ret0, _ := ret[0].(storage.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKey indicates an expected call of GetAPIKey.
+func (mr *MockUsersMockRecorder) GetAPIKey(ctx, keyHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKey", reflect.TypeOf((*MockUsers)(nil).GetAPIKey), ctx, keyHash)
+}
+
+// GetUser mocks base method.
+func (m *MockUsers) GetUser(ctx context.Context, id uuid.UUID) (storage.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUser", ctx, id)
+	ret0, _ := ret[0].(storage.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUser indicates an expected call of GetUser.
+func (mr *MockUsersMockRecorder) GetUser(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockUsers)(nil).GetUser), ctx, id)
+}
+
+// GetUserByLogin mocks base method.
+func (m *MockUsers) GetUserByLogin(ctx context.Context, login string) (storage.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByLogin", ctx, login)
+	ret0, _ := ret[0].(storage.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByLogin indicates an expected call of GetUserByLogin.
+func (mr *MockUsersMockRecorder) GetUserByLogin(ctx, login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByLogin", reflect.TypeOf((*MockUsers)(nil).GetUserByLogin), ctx, login)
+}
+
+// ListAPIKeys mocks base method.
+func (m *MockUsers) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]storage.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAPIKeys", ctx, userID)
+	ret0, _ := ret[0].([]storage.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAPIKeys indicates an expected call of ListAPIKeys.
+func (mr *MockUsersMockRecorder) ListAPIKeys(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAPIKeys", reflect.TypeOf((*MockUsers)(nil).ListAPIKeys), ctx, userID)
+}
+
+// SaveRefreshToken mocks base method.
+func (m *MockUsers) SaveRefreshToken(ctx context.Context, t storage.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRefreshToken", ctx, t)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRefreshToken indicates an expected call of SaveRefreshToken.
+func (mr *MockUsersMockRecorder) SaveRefreshToken(ctx, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRefreshToken", reflect.TypeOf((*MockUsers)(nil).SaveRefreshToken), ctx, t)
+}
+
+// TransferURLs mocks base method.
+func (m *MockUsers) TransferURLs(ctx context.Context, from, to uuid.UUID) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferURLs", ctx, from, to)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransferURLs indicates an expected call of TransferURLs.
+func (mr *MockUsersMockRecorder) TransferURLs(ctx, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferURLs", reflect.TypeOf((*MockUsers)(nil).TransferURLs), ctx, from, to)
+}
+
+// MockModeration is a mock of Moderation interface.
+type MockModeration struct {
+	ctrl     *gomock.Controller
+	recorder *MockModerationMockRecorder
+}
+
+// MockModerationMockRecorder is the mock recorder for MockModeration.
+type MockModerationMockRecorder struct {
+	mock *MockModeration
+}
+
+// NewMockModeration creates a new mock instance.
+func NewMockModeration(ctrl *gomock.Controller) *MockModeration {
+	mock := &MockModeration{ctrl: ctrl}
+	mock.recorder = &MockModerationMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockModeration) EXPECT() *MockModerationMockRecorder {
+	return m.recorder
+}
+
+// DeleteDomainLinks mocks base method.
+func (m *MockModeration) DeleteDomainLinks(ctx context.Context, domain string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteDomainLinks", ctx, domain)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteDomainLinks indicates an expected call of DeleteDomainLinks.
+func (mr *MockModerationMockRecorder) DeleteDomainLinks(ctx, domain interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDomainLinks", reflect.TypeOf((*MockModeration)(nil).DeleteDomainLinks), ctx, domain)
+}
+
+// DisableLink mocks base method.
+func (m *MockModeration) DisableLink(ctx context.Context, id string, status int, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableLink", ctx, id, status, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableLink indicates an expected call of DisableLink.
+func (mr *MockModerationMockRecorder) DisableLink(ctx, id, status, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableLink", reflect.TypeOf((*MockModeration)(nil).DisableLink), ctx, id, status, reason)
+}
+
+// SearchLinks mocks base method.
+func (m *MockModeration) SearchLinks(ctx context.Context, f storage.LinkFilter) ([]storage.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchLinks", ctx, f)
+	ret0, _ := ret[0].([]storage.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchLinks indicates an expected call of SearchLinks.
+func (mr *MockModerationMockRecorder) SearchLinks(ctx, f interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchLinks", reflect.TypeOf((*MockModeration)(nil).SearchLinks), ctx, f)
+}
+
+// TransferLink mocks base method.
+func (m *MockModeration) TransferLink(ctx context.Context, id string, to uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferLink", ctx, id, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TransferLink indicates an expected call of TransferLink.
+func (mr *MockModerationMockRecorder) TransferLink(ctx, id, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferLink", reflect.TypeOf((*MockModeration)(nil).TransferLink), ctx, id, to)
+}
+
+// MockPingable is a mock of Pingable interface.
+type MockPingable struct {
+	ctrl     *gomock.Controller
+	recorder *MockPingableMockRecorder
+}
+
+// MockPingableMockRecorder is the mock recorder for MockPingable.
+type MockPingableMockRecorder struct {
+	mock *MockPingable
+}
+
+// NewMockPingable creates a new mock instance.
+func NewMockPingable(ctrl *gomock.Controller) *MockPingable {
+	mock := &MockPingable{ctrl: ctrl}
+	mock.recorder = &MockPingableMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPingable) EXPECT() *MockPingableMockRecorder {
+	return m.recorder
 }
 
 // Ping mocks base method.
-func (m *MockStorage) Ping(ctx context.Context) bool {
+func (m *MockPingable) Ping(ctx context.Context) bool {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Ping", ctx)
 	ret0, _ := ret[0].(bool)
@@ -89,7 +711,7 @@ func (m *MockStorage) Ping(ctx context.Context) bool {
 }
 
 // Ping indicates an expected call of Ping.
-func (mr *MockStorageMockRecorder) Ping(ctx interface{}) *gomock.Call {
+func (mr *MockPingableMockRecorder) Ping(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockStorage)(nil).Ping), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockPingable)(nil).Ping), ctx)
 }
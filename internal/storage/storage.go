@@ -2,24 +2,48 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/morozoffnor/go-url-shortener/internal/config"
 	"log"
+	"time"
 )
 
 //go:generate mockgen -source=storage.go -destination=mock/storage.go -package=mock
 
 type url struct {
+	Domain      string `json:"domain,omitempty" db:"domain"`
 	UserID      string `json:"user_id" db:"user_id"`
 	UUID        string `json:"uuid" db:"id"`
 	ShortURL    string `json:"short_url" db:"short_url"`
 	OriginalURL string `json:"original_url" db:"full_url"`
 	IsDeleted   bool   `json:"is_deleted" db:"is_deleted"`
+	// Shared — дедупликация выдала обычную ссылку другому пользователю (см. ErrLinkShared)
+	Shared bool `json:"shared,omitempty" db:"shared"`
+
+	DisabledStatus int    `json:"disabled_status,omitempty" db:"disabled_status"`
+	DisabledReason string `json:"disabled_reason,omitempty" db:"disabled_reason"`
+
+	Options *LinkOptions `json:"options,omitempty" db:"options"`
+	Clicks  int          `json:"clicks,omitempty" db:"clicks"`
+
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+	Metadata  map[string]string `json:"metadata,omitempty" db:"metadata"`
+}
+
+// urlHash — ключ дедупликации адреса. Адрес может быть любой длины,
+// поэтому уникальность проверяется по его sha256, а не по самой строке
+func urlHash(full string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(full))
 }
 
 type UserURLs struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
 }
 
 type BatchInput struct {
@@ -30,6 +54,63 @@ type BatchInput struct {
 type BatchOutput struct {
 	ShortURL      string `json:"short_url"`
 	CorrelationID string `json:"correlation_id"`
+
+	// ID и Created в ответ не попадают: по ним журнал аудита отличает
+	// новые ссылки от уже сокращённых адресов
+	ID      string `json:"-"`
+	Created bool   `json:"-"`
+}
+
+// Domain — короткий домен бренда. Ссылки без домена (Name == "")
+// принадлежат домену по умолчанию, т.е. config.BaseURL()
+type Domain struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+}
+
+type ContextDomainKey string
+
+// ContextDomain — домен, в рамках которого выполняется запрос (см. middlewares.Domain)
+var ContextDomain ContextDomainKey = "domain"
+
+var (
+	ErrDomainNotFound = errors.New("domain not found")
+	ErrDomainExists   = errors.New("domain already exists")
+	ErrDomainInUse    = errors.New("domain still has links")
+)
+
+func WithDomain(ctx context.Context, d Domain) context.Context {
+	return context.WithValue(ctx, ContextDomain, d)
+}
+
+func DomainFromContext(ctx context.Context) Domain {
+	d, _ := ctx.Value(ContextDomain).(Domain)
+	return d
+}
+
+type ContextPrimaryKey string
+
+// ContextPrimary отмечает чтение перед записью (см. WithPrimary)
+var ContextPrimary ContextPrimaryKey = "primary"
+
+// WithPrimary направляет чтения на основную базу. Так читают ссылку перед её
+// изменением и сразу после создания: реплика может отставать и отдать прежнюю
+// версию строки. Переходы по ссылкам по-прежнему читают с реплик
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ContextPrimary, true)
+}
+
+func primaryFromContext(ctx context.Context) bool {
+	p, _ := ctx.Value(ContextPrimary).(bool)
+	return p
+}
+
+// BaseURL возвращает адрес, к которому приклеивается код ссылки домена d
+func BaseURL(cfg *config.Config, d Domain) string {
+	if d.Name == "" || d.BaseURL == "" {
+		return cfg.BaseURL()
+	}
+	return d.BaseURL
 }
 
 type URLsForDeletion []string
@@ -37,21 +118,80 @@ type URLsForDeletion []string
 type DeleteURLItem struct {
 	ShortURL string
 	UserID   uuid.UUID
+	// Domain — домен из контекста запроса: коды уникальны только в рамках домена
+	Domain string
 }
 
 type Storage interface {
+	// AddNewURL для уже сокращённого адреса возвращает код существующей ссылки и ErrURLExists
 	AddNewURL(ctx context.Context, full string) (string, error)
-	GetFullURL(ctx context.Context, shortURL string) (string, bool, error)
+	// AddNewURLWithOptions всегда создаёт новую ссылку, даже если адрес уже сокращали
+	AddNewURLWithOptions(ctx context.Context, full string, opts LinkOptions) (string, error)
+	// GetLink ищет ссылку по коду в домене из контекста
+	GetLink(ctx context.Context, code string) (Link, error)
+	// SetLinkOptions заменяет настройки ссылки целиком. Обычную ссылку,
+	// выданную другим пользователям, менять нельзя — ErrLinkShared
+	SetLinkOptions(ctx context.Context, id string, opts LinkOptions) error
+	// UpdateLink меняет адрес назначения и метаданные ссылки. Если новый адрес
+	// обычной ссылки уже сокращён в том же домене — ErrURLExists. Обычную ссылку,
+	// выданную другим пользователям, менять нельзя — ErrLinkShared
+	UpdateLink(ctx context.Context, id string, upd LinkUpdate) error
+	// ConsumeClick засчитывает переход по ссылке с лимитом переходов.
+	// Проверка и увеличение счётчика атомарны, после исчерпания лимита — ErrLinkExhausted
+	ConsumeClick(ctx context.Context, id string) error
 	AddBatch(ctx context.Context, urls []BatchInput) ([]BatchOutput, error)
 	GetUserURLs(ctx context.Context, userID uuid.UUID) ([]UserURLs, error)
-	DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion)
+	// DeleteURLs помечает удалёнными ссылки пользователя userID в домене из контекста
+	// и возвращает коды, которые действительно были помечены: чужие, несуществующие
+	// и уже удалённые коды пропускаются
+	DeleteURLs(ctx context.Context, userID uuid.UUID, urls URLsForDeletion) URLsForDeletion
+	AddDomain(ctx context.Context, d Domain) error
+	GetDomain(ctx context.Context, name string) (Domain, error)
+	ListDomains(ctx context.Context) ([]Domain, error)
+	DeleteDomain(ctx context.Context, name string) error
+	Users
+	Moderation
+}
+
+// Users — учётные записи, refresh-токены и API-ключи
+type Users interface {
+	CreateUser(ctx context.Context, u User) error
+	GetUserByLogin(ctx context.Context, login string) (User, error)
+	GetUser(ctx context.Context, id uuid.UUID) (User, error)
+	SaveRefreshToken(ctx context.Context, t RefreshToken) error
+	// ConsumeRefreshToken удаляет токен и возвращает владельца: каждый токен одноразовый
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error)
+	CreateAPIKey(ctx context.Context, k APIKey) error
+	GetAPIKey(ctx context.Context, keyHash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID uuid.UUID, id string) error
+	// TransferURLs переносит все ссылки from на to и возвращает их количество
+	TransferURLs(ctx context.Context, from, to uuid.UUID) (int, error)
+}
+
+// Moderation — операции над чужими ссылками для /api/admin
+type Moderation interface {
+	SearchLinks(ctx context.Context, f LinkFilter) ([]Link, error)
+	// DisableLink отключает ссылку, status 0 включает её обратно
+	DisableLink(ctx context.Context, id string, status int, reason string) error
+	TransferLink(ctx context.Context, id string, to uuid.UUID) error
+	// DeleteDomainLinks помечает удалёнными все ссылки домена и возвращает их количество
+	DeleteDomainLinks(ctx context.Context, domain string) (int, error)
 }
 
 type Pingable interface {
 	Ping(ctx context.Context) bool
 }
 
-func NewStorage(cfg *config.Config, ctx context.Context) Storage {
+// Connector — хранилище, которое подключается в фоне (см. config.DegradedStart)
+type Connector interface {
+	// Connected закрывается, когда подключение завершилось, успешно или нет
+	Connected() <-chan struct{}
+	// Err — ошибка, с которой хранилище так и не заработало
+	Err() error
+}
+
+func NewStorage(cfg *config.Config, ctx context.Context) (Storage, error) {
 	if cfg.DatabaseDSN != "" {
 		log.Print("Using database storage")
 		return NewDatabase(cfg, ctx)
@@ -61,5 +201,5 @@ func NewStorage(cfg *config.Config, ctx context.Context) Storage {
 		return NewFileStorage(cfg)
 	}
 	log.Print("Using memory storage")
-	return NewMemoryStorage(cfg)
+	return NewMemoryStorage(cfg), nil
 }
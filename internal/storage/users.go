@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+type User struct {
+	ID           uuid.UUID `json:"id"`
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// APIKey хранит только хэш ключа, сам ключ показывается пользователю один раз
+type APIKey struct {
+	ID        string    `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Name      string    `json:"name"`
+	KeyHash   string    `json:"key_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RefreshToken struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrTokenInvalid = errors.New("refresh token is invalid or expired")
+)
@@ -0,0 +1,118 @@
+// Package compress кодирует и декодирует тела http-запросов и ответов:
+// gzip, deflate, br и zstd с выбором кодирования по Accept-Encoding
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+	"sync"
+)
+
+// encoder — общее у писателей всех кодирований, Reset позволяет переиспользовать их через пул
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+type coding struct {
+	name      string
+	newWriter func() encoder
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	writers   sync.Pool
+}
+
+func (c *coding) getWriter(w io.Writer) encoder {
+	enc, ok := c.writers.Get().(encoder)
+	if !ok {
+		enc = c.newWriter()
+	}
+	enc.Reset(w)
+	return enc
+}
+
+func (c *coding) putWriter(enc encoder) {
+	// писатель не должен держать ссылку на чужой ResponseWriter
+	enc.Reset(io.Discard)
+	c.writers.Put(enc)
+}
+
+// maxDecoderMemory ограничивает память декодера zstd
+const maxDecoderMemory = 64 << 20
+
+// codings перечислены в порядке предпочтения сервера при равном q
+var codings = []*coding{
+	{
+		name: "br",
+		newWriter: func() encoder {
+			return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	},
+	{
+		name: "zstd",
+		newWriter: func() encoder {
+			enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return enc
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecoderMemory))
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+	{
+		name: "gzip",
+		newWriter: func() encoder {
+			return gzip.NewWriter(nil)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		name: "deflate",
+		newWriter: func() encoder {
+			fw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+			return fw
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	},
+}
+
+func lookup(name string) *coding {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "x-gzip" {
+		name = "gzip"
+	}
+	for _, c := range codings {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Supported сообщает, что кодирование name поддерживается
+func Supported(name string) bool {
+	return lookup(name) != nil
+}
+
+// Encodings возвращает поддерживаемые кодирования в порядке предпочтения
+func Encodings() []string {
+	names := make([]string, 0, len(codings))
+	for _, c := range codings {
+		names = append(names, c.name)
+	}
+	return names
+}
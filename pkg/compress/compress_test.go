@@ -0,0 +1,231 @@
+package compress
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "GZIP", want: "gzip"},
+		{header: "x-gzip", want: "gzip"},
+		{header: "gzip, deflate, br, zstd", want: "br"},
+		{header: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		{header: "br;q=0, gzip;q=0.1", want: "gzip"},
+		{header: "zstd;q=0.9, deflate;q=0.9", want: "zstd"},
+		{header: "*", want: "br"},
+		{header: "*;q=0.5, br;q=0", want: "zstd"},
+		{header: "identity", want: ""},
+		{header: "gzip;q=0.5, identity", want: ""},
+		{header: "compress, sdch", want: ""},
+		{header: "gzip;q=0", want: ""},
+		{header: "gzip;q=2, deflate", want: "deflate"},
+		{header: "gzip;level=1;q=0.3", want: "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			assert.Equal(t, test.want, Negotiate(test.header))
+		})
+	}
+}
+
+// serve прогоняет handler через ResponseWriter с кодированием name
+func serve(t *testing.T, name string, handler http.HandlerFunc) *http.Response {
+	rec := httptest.NewRecorder()
+	cw := NewResponseWriter(rec, name)
+	require.NotNil(t, cw)
+	handler(cw, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, cw.Close())
+	return rec.Result()
+}
+
+func decode(t *testing.T, res *http.Response) string {
+	body, err := NewReader(res.Body, res.Header.Get("Content-Encoding"))
+	require.NoError(t, err)
+	defer body.Close()
+	b, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestResponseWriter(t *testing.T) {
+	large := strings.Repeat(`{"short_url": "http://localhost:8080/abc"}`, 100)
+
+	for _, name := range Encodings() {
+		t.Run(name, func(t *testing.T) {
+			// писатели берутся из пула, второй ответ не должен зависеть от первого
+			for i := 0; i < 2; i++ {
+				res := serve(t, name, func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set("Content-Length", "4300")
+					w.WriteHeader(http.StatusCreated)
+					// по кусочкам, чтобы порог сработал посередине
+					for _, chunk := range []string{large[:10], large[10:2000], large[2000:]} {
+						io.WriteString(w, chunk)
+					}
+				})
+				assert.Equal(t, http.StatusCreated, res.StatusCode)
+				assert.Equal(t, name, res.Header.Get("Content-Encoding"))
+				assert.Empty(t, res.Header.Get("Content-Length"))
+				assert.Equal(t, large, decode(t, res))
+			}
+		})
+	}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		wantType string
+	}{
+		{
+			name: "small",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "http://localhost:8080/abc")
+			},
+			wantType: "text/plain; charset=utf-8",
+		},
+		{
+			name: "error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, large, http.StatusBadRequest)
+			},
+			wantType: "text/plain; charset=utf-8",
+		},
+		{
+			name: "already compressed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				io.WriteString(w, large)
+			},
+			wantType: "image/png",
+		},
+		{
+			name: "encoded by handler",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "identity")
+				io.WriteString(w, large)
+			},
+			wantType: "text/plain",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := serve(t, "gzip", test.handler)
+			assert.NotEqual(t, "gzip", res.Header.Get("Content-Encoding"))
+			assert.Equal(t, test.wantType, res.Header.Get("Content-Type"))
+			b, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.Contains(t, string(b), "localhost:8080")
+		})
+	}
+
+	t.Run("no body", func(t *testing.T) {
+		res := serve(t, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Content-Encoding"))
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		res := serve(t, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "data: 2\n\n")
+		})
+		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+		assert.Equal(t, "data: 1\n\ndata: 2\n\n", decode(t, res))
+	})
+}
+
+func encode(t *testing.T, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	c := lookup(name)
+	enc := c.getWriter(&buf)
+	_, err := enc.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+	c.putWriter(enc)
+	return buf.Bytes()
+}
+
+func TestNewReader(t *testing.T) {
+	data := []byte(strings.Repeat("payload ", 100))
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{encoding: "identity", body: data},
+		{encoding: "x-gzip", body: encode(t, "gzip", data)},
+		{encoding: "deflate", body: encode(t, "deflate", data)},
+		{encoding: "br", body: encode(t, "br", data)},
+		{encoding: "zstd", body: encode(t, "zstd", data)},
+		// кодирования снимаются в обратном порядке
+		{encoding: "gzip, br", body: encode(t, "br", encode(t, "gzip", data))},
+	}
+	for _, test := range tests {
+		t.Run(test.encoding, func(t *testing.T) {
+			body, err := NewReader(io.NopCloser(bytes.NewReader(test.body)), test.encoding)
+			require.NoError(t, err)
+			got, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+			assert.NoError(t, body.Close())
+		})
+	}
+
+	_, err := NewReader(io.NopCloser(strings.NewReader("x")), "compress")
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = NewReader(io.NopCloser(strings.NewReader("not gzip")), "gzip")
+	assert.Error(t, err)
+	// длинная цепочка отклоняется до того, как создан хоть один декодер
+	_, err = NewReader(io.NopCloser(strings.NewReader("x")), strings.Repeat("zstd, ", 1000)+"identity")
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = NewReader(io.NopCloser(strings.NewReader("x")), "gzip, gzip, gzip")
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestNewLimitedReader(t *testing.T) {
+	// 10 МБ нулей сжимаются примерно в тысячу раз
+	bomb := encode(t, "gzip", make([]byte, 10<<20))
+	text := []byte(strings.Repeat("payload ", 100))
+	tests := []struct {
+		name    string
+		body    []byte
+		limits  Limits
+		wantErr error
+	}{
+		{name: "no limits", body: bomb},
+		{name: "within limits", body: encode(t, "gzip", text), limits: Limits{MaxDecoded: 1 << 20, MaxRatio: 100}},
+		{name: "too large", body: bomb, limits: Limits{MaxDecoded: 1 << 20}, wantErr: ErrTooLarge},
+		{name: "bomb", body: bomb, limits: Limits{MaxDecoded: 100 << 20, MaxRatio: 100}, wantErr: ErrBomb},
+		// маленькое тело может сжиматься сколько угодно
+		{name: "small body is not a bomb", body: encode(t, "gzip", make([]byte, 32<<10)), limits: Limits{MaxRatio: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := NewLimitedReader(io.NopCloser(bytes.NewReader(test.body)), "gzip", test.limits)
+			require.NoError(t, err)
+			defer body.Close()
+			_, err = io.Copy(io.Discard, body)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package compress
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	// ErrTooLarge — декодированное тело больше допустимого
+	ErrTooLarge = errors.New("decoded body is too large")
+	// ErrBomb — тело разжимается подозрительно сильно
+	ErrBomb = errors.New("decoded body expands too much, looks like a decompression bomb")
+)
+
+// minRatioCheck — до этого размера степень сжатия не проверяется:
+// маленькие однообразные тела честно сжимаются в сотни раз
+const minRatioCheck = 64 << 10
+
+// Limits ограничивают декодирование тела, нулевые значения отключают проверки
+type Limits struct {
+	MaxDecoded int64
+	MaxRatio   int64
+}
+
+// NewLimitedReader работает как NewReader, но прерывает чтение с ErrTooLarge
+// или ErrBomb, как только декодированное тело выходит за limits
+func NewLimitedReader(body io.ReadCloser, contentEncoding string, limits Limits) (io.ReadCloser, error) {
+	encoded := &countingReader{r: body}
+	decoded, err := NewReader(struct {
+		io.Reader
+		io.Closer
+	}{encoded, body}, contentEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{ReadCloser: decoded, encoded: encoded, limits: limits}, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	encoded *countingReader
+	limits  Limits
+	n       int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.n += int64(n)
+	if l.limits.MaxDecoded > 0 && l.n > l.limits.MaxDecoded {
+		return n, ErrTooLarge
+	}
+	if l.limits.MaxRatio > 0 && l.n > minRatioCheck && l.n > l.limits.MaxRatio*max(l.encoded.n, 1) {
+		return n, ErrBomb
+	}
+	return n, err
+}
@@ -0,0 +1,75 @@
+package compress
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Negotiate выбирает кодирование ответа по заголовку Accept-Encoding (RFC 9110, 12.5.3).
+// Побеждает наибольший q, при равенстве — порядок codings. Пустая строка значит
+// ответ без сжатия: заголовка нет, ничего не подходит или identity предпочтительнее
+func Negotiate(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, q, ok := parseWeight(part)
+		if !ok {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		weights[name] = q
+	}
+	weight := func(name string) float64 {
+		if q, ok := weights[name]; ok {
+			return q
+		}
+		if q, ok := weights["*"]; ok {
+			return q
+		}
+		if name == "identity" {
+			return 1
+		}
+		return 0
+	}
+
+	best, bestQ := "", 0.0
+	for _, c := range codings {
+		if q := weight(c.name); q > bestQ {
+			best, bestQ = c.name, q
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	// "gzip;q=0.5, identity" — клиент предпочитает несжатый ответ
+	if _, explicit := weights["identity"]; explicit && weights["identity"] > bestQ {
+		return ""
+	}
+	return best
+}
+
+// parseWeight разбирает элемент вида "gzip;q=0.8"
+func parseWeight(part string) (string, float64, bool) {
+	name, params, _ := strings.Cut(part, ";")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", 0, false
+	}
+	q := 1.0
+	for _, p := range strings.Split(params, ";") {
+		k, v, found := strings.Cut(strings.TrimSpace(p), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(k), "q") {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0, false
+		}
+		q = parsed
+	}
+	return name, q, true
+}
@@ -0,0 +1,58 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUnsupported — тело закодировано тем, что сервер не умеет декодировать
+var ErrUnsupported = errors.New("unsupported content encoding")
+
+// MaxEncodings ограничивает цепочку кодирований тела: на каждое создаётся свой
+// декодер со своими буферами, а клиенту незачем сжимать тело больше двух раз
+const MaxEncodings = 2
+
+// NewReader декодирует тело по заголовку Content-Encoding. Кодирования
+// перечисляются в порядке применения, поэтому снимаются с конца
+func NewReader(body io.ReadCloser, contentEncoding string) (io.ReadCloser, error) {
+	var names []string
+	for _, name := range strings.Split(contentEncoding, ",") {
+		if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "identity") {
+			names = append(names, name)
+		}
+	}
+	if len(names) > MaxEncodings {
+		return nil, fmt.Errorf("%w: more than %d codings", ErrUnsupported, MaxEncodings)
+	}
+	r := &reader{closers: []io.Closer{body}}
+	var src io.Reader = body
+	for i := len(names) - 1; i >= 0; i-- {
+		c := lookup(names[i])
+		if c == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, names[i])
+		}
+		dec, err := c.newReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.name, err)
+		}
+		r.closers = append(r.closers, dec)
+		src = dec
+	}
+	r.Reader = src
+	return r, nil
+}
+
+type reader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *reader) Close() error {
+	var errs []error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		errs = append(errs, r.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,172 @@
+package compress
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// MinSize — ответы меньше этого размера не сжимаются: заголовки и служебные
+// байты кодирования съедят почти всю выгоду
+const MinSize = 1024
+
+// ResponseWriter сжимает ответ выбранным кодированием. Первые MinSize байт
+// копятся в буфере, и решение принимается, когда станут известны размер,
+// статус и Content-Type. После обработчика нужно вызвать Close
+type ResponseWriter struct {
+	http.ResponseWriter
+	coding *coding
+	enc    encoder
+
+	status  int
+	buf     []byte
+	decided bool
+}
+
+// NewResponseWriter возвращает nil, если кодирование name не поддерживается
+func NewResponseWriter(w http.ResponseWriter, name string) *ResponseWriter {
+	c := lookup(name)
+	if c == nil {
+		return nil
+	}
+	return &ResponseWriter{ResponseWriter: w, coding: c}
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.status != 0 || w.decided {
+		return
+	}
+	// 1xx уходят сразу и не влияют на основной ответ
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	if !compressibleStatus(status) {
+		w.decide(false)
+	}
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide отправляет заголовки и накопленный буфер. Сжатие включается,
+// только если его допускают заголовки ответа
+func (w *ResponseWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// после сжатия net/http определил бы тип по сжатым байтам
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" && compressibleType(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", w.coding.name)
+		h.Del("Content-Length")
+		w.enc = w.coding.getWriter(w.ResponseWriter)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Close дописывает ответ и возвращает писатель в пул
+func (w *ResponseWriter) Close() error {
+	if !w.decided {
+		// обработчик ничего не написал — ответ без тела и без сжатия
+		if w.status == 0 && len(w.buf) == 0 {
+			return nil
+		}
+		return w.decide(false)
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	w.coding.putWriter(w.enc)
+	w.enc = nil
+	return err
+}
+
+// Flush отправляет накопленное, не дожидаясь MinSize: потоковый ответ сжимается
+// сразу, если это позволяет Content-Type
+func (w *ResponseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		_ = w.decide(compressibleStatus(w.status))
+	}
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("compress: underlying ResponseWriter does not support hijacking")
+}
+
+// Unwrap нужен http.ResponseController
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressibleStatus не пускает в сжатие ответы без тела, частичные ответы и ошибки
+func compressibleStatus(status int) bool {
+	return status >= 200 && status < 300 && status != http.StatusNoContent && status != http.StatusPartialContent
+}
+
+// compressibleType отсеивает форматы, которые уже сжаты
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	major, _, _ := strings.Cut(mediaType, "/")
+	switch major {
+	case "image":
+		return mediaType == "image/svg+xml" || mediaType == "image/bmp" || mediaType == "image/x-icon"
+	case "video", "audio":
+		return false
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd", "application/x-bzip2",
+		"application/x-xz", "application/x-7z-compressed", "application/x-rar-compressed", "application/pdf",
+		"font/woff", "font/woff2":
+		return false
+	}
+	return true
+}
@@ -2,13 +2,22 @@ package logger
 
 import "go.uber.org/zap"
 
+// Level можно менять во время работы, например при перечитывании конфига
+var Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+
 var Logger = NewLogger()
 
 func NewLogger() *zap.SugaredLogger {
-	logger, err := zap.NewDevelopment()
+	cfg := zap.NewDevelopmentConfig()
+	cfg.Level = Level
+	logger, err := cfg.Build()
 	if err != nil {
 		panic(err)
 	}
 	sugar := logger.Sugar()
 	return sugar
 }
+
+func SetLevel(level string) error {
+	return Level.UnmarshalText([]byte(level))
+}
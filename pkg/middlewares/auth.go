@@ -3,46 +3,124 @@ package middlewares
 import (
 	"context"
 	"errors"
-	"github.com/google/uuid"
 	"github.com/morozoffnor/go-url-shortener/internal/auth"
-	"github.com/morozoffnor/go-url-shortener/internal/config"
-	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
 	"net/http"
 )
 
-func Auth(cfg *config.Config) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+type APIKeyResolver interface {
+	GetAPIKey(ctx context.Context, keyHash string) (storage.APIKey, error)
+}
 
-			authHandler := auth.New(cfg)
+// AuthPolicy определяет, что делать с запросом без пользователя
+type AuthPolicy int
 
-			cookie, err := r.Cookie("Authorization")
-			if err != nil && !errors.Is(err, http.ErrNoCookie) {
-				logger.Logger.Error(err)
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-				return
-			}
+const (
+	// AllowAnonymous пропускает запрос как есть, пользователь кладётся в контекст, если он есть
+	AllowAnonymous AuthPolicy = iota
+	// ProvisionIdentity выдаёт новую анонимную личность в куке, если пользователя нет
+	ProvisionIdentity
+	// RequireIdentity отвечает 401, если пользователя нет
+	RequireIdentity
+	// RequireRegistered отвечает 401 и анонимным личностям
+	RequireRegistered
+	// RequireAdmin пускает только пользователей с ролью admin, остальным 403
+	RequireAdmin
+)
 
-			if err != nil {
-				next.ServeHTTP(w, r)
+// ErrBadCredentials — в заголовке Authorization есть ключ или токен, но они неверные
+var ErrBadCredentials = errors.New("bad credentials")
+
+// Auth — единая точка авторизации. Пользователь ищется по API-ключу или
+// Bearer-токену из заголовка Authorization, затем по куке. Неверные данные
+// в заголовке клиент прислал явно, поэтому это 401 (кроме AllowAnonymous:
+// войти или обновить токен можно и с протухшим access-токеном),
+// а протухшая кука просто считается отсутствующей
+func Auth(jwt *auth.JWT, keys APIKeyResolver, policy AuthPolicy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok, err := identify(r, jwt, keys)
+			if errors.Is(err, ErrBadCredentials) && policy == AllowAnonymous {
+				ok, err = false, nil
+			}
+			if errors.Is(err, ErrBadCredentials) {
+				problem.Error(w, r, problem.InvalidToken, "invalid token or API key in Authorization header")
 				return
 			}
-
-			claims, err := authHandler.ParseToken(cookie.Value)
 			if err != nil {
-				next.ServeHTTP(w, r)
+				problem.InternalError(w, r, err)
 				return
 			}
 
-			if claims.UserID == uuid.Nil {
+			switch {
+			case !ok && policy == ProvisionIdentity:
+				var token string
+				id, token, err = jwt.NewIdentity()
+				if err != nil {
+					problem.InternalError(w, r, err)
+					return
+				}
+				jwt.SetTokenCookie(w, token, auth.AnonymousTokenTTL)
+			case !ok && policy != AllowAnonymous:
+				problem.Error(w, r, problem.Unauthorized, "")
+				return
+			case ok && policy == RequireRegistered && !id.Registered:
+				problem.Error(w, r, problem.Unauthorized, "a registered account is required")
+				return
+			case ok && policy == RequireAdmin && !id.IsAdmin():
+				problem.Error(w, r, problem.Forbidden, "admin role is required")
+				return
+			case !ok:
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), auth.ContextUserID, claims.UserID)
-			r = r.WithContext(ctx)
-
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
 		})
 	}
 }
+
+func identify(r *http.Request, jwt *auth.JWT, keys APIKeyResolver) (auth.Identity, bool, error) {
+	id, ok, err := IdentifyHeader(r.Context(), r.Header.Get("Authorization"), jwt, keys)
+	if ok || err != nil {
+		return id, ok, err
+	}
+
+	cookie, err := r.Cookie("Authorization")
+	if errors.Is(err, http.ErrNoCookie) {
+		return auth.Identity{}, false, nil
+	}
+	if err != nil {
+		return auth.Identity{}, false, err
+	}
+	id, err = jwt.Identity(cookie.Value)
+	if err != nil {
+		return auth.Identity{}, false, nil
+	}
+	return id, true, nil
+}
+
+// IdentifyHeader ищет пользователя по значению Authorization: API-ключу или Bearer-токену.
+// Тем же путём проходят и метаданные gRPC, поэтому оба API принимают одни и те же данные
+func IdentifyHeader(ctx context.Context, header string, jwt *auth.JWT, keys APIKeyResolver) (auth.Identity, bool, error) {
+	if key, ok := auth.APIKeyFromHeader(header); ok {
+		k, err := keys.GetAPIKey(ctx, auth.HashSecret(key))
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return auth.Identity{}, false, ErrBadCredentials
+		}
+		if err != nil {
+			return auth.Identity{}, false, err
+		}
+		return auth.Identity{UserID: k.UserID, Registered: true}, true, nil
+	}
+
+	if token, ok := auth.BearerToken(header); ok {
+		id, err := jwt.Identity(token)
+		if err != nil {
+			return auth.Identity{}, false, ErrBadCredentials
+		}
+		return id, true, nil
+	}
+	return auth.Identity{}, false, nil
+}
@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/morozoffnor/go-url-shortener/pkg/compress"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BodyLimits ограничивают тело запроса, нулевые значения отключают проверки
+type BodyLimits struct {
+	// MaxBytes — размер тела, как оно пришло по сети
+	MaxBytes int64
+	// MaxDecodedBytes — размер после снятия Content-Encoding
+	MaxDecodedBytes int64
+	// MaxRatio — во сколько раз тело может разжаться, прежде чем его сочтут бомбой
+	MaxRatio int64
+}
+
+// ReadBody читает тело запроса целиком, декодирует его по Content-Encoding
+// и передаёт дальше уже в памяти, поэтому обработчики всегда читают тело как есть.
+// Слишком большое тело отклоняется с 413, битое — с 400
+func ReadBody(limits BodyLimits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if limits.MaxBytes > 0 && r.ContentLength > limits.MaxBytes {
+				problem.Write(w, r, tooLarge(limits.MaxBytes))
+				return
+			}
+			encoding := r.Header.Get("Content-Encoding")
+			raw := r.Body
+			if limits.MaxBytes > 0 {
+				raw = http.MaxBytesReader(w, r.Body, limits.MaxBytes)
+			}
+			body, err := compress.NewLimitedReader(raw, encoding, compress.Limits{
+				MaxDecoded: limits.MaxDecodedBytes,
+				MaxRatio:   limits.MaxRatio,
+			})
+			if errors.Is(err, compress.ErrUnsupported) {
+				w.Header().Set("Accept-Encoding", strings.Join(compress.Encodings(), ", "))
+				problem.Error(w, r, problem.UnsupportedEncoding, err.Error())
+				return
+			}
+			if err != nil {
+				problem.Error(w, r, problem.InvalidBody, "failed decoding body")
+				return
+			}
+			data, err := io.ReadAll(body)
+			body.Close()
+			var maxBytes *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytes):
+				problem.Write(w, r, tooLarge(limits.MaxBytes))
+				return
+			case errors.Is(err, compress.ErrTooLarge):
+				problem.Error(w, r, problem.BodyTooLarge, fmt.Sprintf("decoded body exceeds %d bytes", limits.MaxDecodedBytes))
+				return
+			case errors.Is(err, compress.ErrBomb):
+				problem.Error(w, r, problem.BodyTooLarge, err.Error())
+				return
+			case err != nil && encoding != "":
+				problem.Error(w, r, problem.InvalidBody, "failed decoding body")
+				return
+			case err != nil:
+				problem.Error(w, r, problem.InvalidBody, "failed reading body")
+				return
+			}
+
+			// исходные заголовки остаются у внешних middleware, например у Log
+			read := new(http.Request)
+			*read = *r
+			read.Header = r.Header.Clone()
+			read.Header.Del("Content-Encoding")
+			read.Header.Set("Content-Length", strconv.Itoa(len(data)))
+			read.ContentLength = int64(len(data))
+			read.Body = io.NopCloser(bytes.NewReader(data))
+			next.ServeHTTP(w, read)
+		})
+	}
+}
+
+func tooLarge(limit int64) *problem.Problem {
+	return problem.Newf(problem.BodyTooLarge, "body exceeds %d bytes", limit)
+}
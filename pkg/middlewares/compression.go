@@ -1,22 +1,22 @@
 package middlewares
 
 import (
-	"github.com/morozoffnor/go-url-shortener/pkg/gzip"
+	"github.com/morozoffnor/go-url-shortener/pkg/compress"
 	"net/http"
-	"strings"
 )
 
-func Compress(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		nw := w
-		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			gzipWriter := gzip.NewWriter(w)
-			nw = gzipWriter
-			defer gzipWriter.Close()
+// Compress сжимает ответ кодированием, выбранным по Accept-Encoding.
+// Маленькие, уже сжатые и неуспешные ответы уходят как есть (см. compress.ResponseWriter)
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ответ зависит от Accept-Encoding, даже если в этот раз он не сжат
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := compress.NewResponseWriter(w, compress.Negotiate(r.Header.Get("Accept-Encoding")))
+		if cw == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
 		}
-
-		next.ServeHTTP(nw, r)
-
-	}
-
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
 }
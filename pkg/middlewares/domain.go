@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"github.com/morozoffnor/go-url-shortener/internal/config"
+	"github.com/morozoffnor/go-url-shortener/internal/storage"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"net"
+	"net/http"
+	urlLib "net/url"
+	"strings"
+)
+
+type DomainResolver interface {
+	GetDomain(ctx context.Context, name string) (storage.Domain, error)
+}
+
+// Domain определяет по заголовку Host, к какому домену относится запрос.
+// Неизвестные хосты и хост из BASE_URL обслуживаются доменом по умолчанию.
+// Порт не учитывается: домены регистрируются по имени, а за прокси Host приходит с портом
+func Domain(cfg *config.Config, resolver DomainResolver) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithHostDomain(r.Context(), cfg, resolver, r.Host)))
+		})
+	}
+}
+
+// WithHostDomain кладёт в контекст домен, которому принадлежит host.
+// Так же домен определяет и gRPC-сервер, по :authority
+func WithHostDomain(ctx context.Context, cfg *config.Config, resolver DomainResolver, host string) context.Context {
+	host = hostname(host)
+	if host == "" || host == defaultHost(cfg) {
+		return ctx
+	}
+	d, err := resolver.GetDomain(ctx, host)
+	if err != nil {
+		if !errors.Is(err, storage.ErrDomainNotFound) {
+			logger.Logger.Error(err)
+		}
+		return ctx
+	}
+	return storage.WithDomain(ctx, d)
+}
+
+func defaultHost(cfg *config.Config) string {
+	u, err := urlLib.Parse(cfg.BaseURL())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// hostname убирает из Host порт, если он есть
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
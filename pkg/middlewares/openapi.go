@@ -0,0 +1,171 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAPIOptions выбирают, что сверять со спецификацией
+type OpenAPIOptions struct {
+	// Requests отклоняет запросы, не подходящие под спецификацию, с 400
+	Requests bool
+	// Responses заменяет ответ, не подходящий под спецификацию, на 500.
+	// Ответ буферизуется целиком, поэтому это режим для тестов и стендов
+	Responses bool
+}
+
+// OpenAPI сверяет запросы и ответы со спецификацией. Запросы, для которых
+// в спецификации нет операции, проходят без проверки: на них ответит сам роутер
+func OpenAPI(router routers.Router, opts OpenAPIOptions) func(http.Handler) http.Handler {
+	filterOpts := &openapi3filter.Options{
+		// аутентификацию проверяет Auth, спецификация только описывает схемы
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+		MultiError:            true,
+		// значения по умолчанию подставляют обработчики, запрос не меняется
+		SkipSettingDefaults: true,
+	}
+	return func(next http.Handler) http.Handler {
+		if !opts.Requests && !opts.Responses {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, params, err := router.FindRoute(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: params,
+				Route:      route,
+				Options:    filterOpts,
+			}
+			if opts.Requests {
+				if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+					problem.Write(w, r, requestProblem(err))
+					return
+				}
+			}
+			if !opts.Responses {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			before := w.Header().Clone()
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 rec.status,
+				Header:                 w.Header(),
+				Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+				Options:                filterOpts,
+			})
+			if err != nil {
+				// заголовки обработчика, например Location, к ошибке не относятся
+				for k := range w.Header() {
+					delete(w.Header(), k)
+				}
+				for k, v := range before {
+					w.Header()[k] = v
+				}
+				problem.InternalError(w, r, fmt.Errorf("response %d to %s %s does not match openapi spec: %w",
+					rec.status, r.Method, r.URL.Path, err))
+				return
+			}
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+		})
+	}
+}
+
+// responseRecorder придерживает статус и тело ответа до проверки.
+// Заголовки пишутся сразу в исходный ResponseWriter: до WriteHeader они никуда не уходят
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// requestProblem переводит ошибки проверки запроса в ошибку валидации
+// с перечнем полей. Неразборчивое тело — invalid-body, как и в обработчиках
+func requestProblem(err error) *problem.Problem {
+	var fields []problem.FieldError
+	// ошибки вложены друг в друга, имя поля берётся с ближайшего уровня, где оно известно
+	var walk func(err error, field string)
+	walk = func(err error, field string) {
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, err := range e {
+				walk(err, field)
+			}
+		case *openapi3filter.RequestError:
+			name := field
+			if e.Parameter != nil {
+				name = e.Parameter.Name
+			} else if e.RequestBody != nil {
+				name = "body"
+			}
+			if e.Err == nil {
+				fields = append(fields, problem.Field(name, e.Reason))
+				return
+			}
+			walk(e.Err, name)
+		case *openapi3.SchemaError:
+			name := field
+			if path := e.JSONPointer(); len(path) > 0 {
+				name = strings.Join(path, ".")
+				if field != "body" {
+					name = field + "." + name
+				}
+			}
+			fields = append(fields, problem.Field(name, e.Reason))
+		case *openapi3filter.ParseError:
+			// в тексте самой ошибки есть значение из запроса, наружу идёт только причина
+			reason := e.Reason
+			if reason == "" {
+				reason = "invalid format"
+			}
+			fields = append(fields, problem.Field(field, reason))
+		default:
+			if inner := errors.Unwrap(err); inner != nil {
+				walk(inner, field)
+				return
+			}
+			fields = append(fields, problem.Field(field, err.Error()))
+		}
+	}
+
+	var parseErr *openapi3filter.ParseError
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &parseErr) && errors.As(err, &reqErr) && reqErr.RequestBody != nil {
+		return problem.New(problem.InvalidBody, "")
+	}
+	walk(err, "request")
+	return problem.Invalid(fields...)
+}
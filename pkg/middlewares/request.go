@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"github.com/google/uuid"
+	"github.com/morozoffnor/go-url-shortener/pkg/requestid"
+	"net"
+	"net/http"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID берёт идентификатор запроса из X-Request-ID или создаёт новый,
+// возвращает его в ответе и вместе с адресом клиента кладёт в контекст (см. requestid)
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ctx := requestid.WithInfo(r.Context(), requestid.Info{ID: id, ClientIP: ip})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"github.com/morozoffnor/go-url-shortener/pkg/problem"
+	"net/http"
+	"time"
+)
+
+// Timeout отвечает 503, если обработчик не уложился в d, и отменяет контекст
+// запроса. При d <= 0 ограничения нет
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// тело ошибки у http.TimeoutHandler постоянное, поэтому собирается на каждый запрос
+			p := problem.New(problem.Timeout, "handler did not finish in "+d.String())
+			body, contentType := problem.Render(r, p)
+			th := http.TimeoutHandler(next, d, string(body))
+			th.ServeHTTP(&timeoutWriter{ResponseWriter: w, contentType: contentType}, r)
+		})
+	}
+}
+
+// timeoutWriter проставляет тип тела ошибки, которое пишет http.TimeoutHandler
+type timeoutWriter struct {
+	http.ResponseWriter
+	contentType string
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", w.contentType)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
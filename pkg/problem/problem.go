@@ -0,0 +1,161 @@
+// Package problem описывает ошибки API в формате RFC 7807 (application/problem+json)
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/morozoffnor/go-url-shortener/pkg/logger"
+	"github.com/morozoffnor/go-url-shortener/pkg/requestid"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const ContentType = "application/problem+json"
+
+// Type — стабильный вид ошибки, по которому клиенты различают ответы.
+// Status и Title — значения по умолчанию для этого вида
+type Type struct {
+	URI    string
+	Status int
+	Title  string
+}
+
+func newType(slug string, status int, title string) Type {
+	return Type{URI: "/problems/" + slug, Status: status, Title: title}
+}
+
+var (
+	InvalidBody         = newType("invalid-body", http.StatusBadRequest, "Request body is malformed")
+	ValidationFailed    = newType("validation-failed", http.StatusBadRequest, "Request is invalid")
+	Unauthorized        = newType("unauthorized", http.StatusUnauthorized, "Authentication is required")
+	InvalidCredentials  = newType("invalid-credentials", http.StatusUnauthorized, "Invalid login or password")
+	InvalidToken        = newType("invalid-token", http.StatusUnauthorized, "Token is invalid or expired")
+	Forbidden           = newType("forbidden", http.StatusForbidden, "Access is denied")
+	NotFound            = newType("not-found", http.StatusNotFound, "Resource not found")
+	LinkNotFound        = newType("link-not-found", http.StatusNotFound, "Link not found")
+	DomainNotFound      = newType("domain-not-found", http.StatusNotFound, "Domain not found")
+	APIKeyNotFound      = newType("api-key-not-found", http.StatusNotFound, "API key not found")
+	MethodNotAllowed    = newType("method-not-allowed", http.StatusMethodNotAllowed, "Method not allowed")
+	Conflict            = newType("conflict", http.StatusConflict, "Resource already exists")
+	LinkGone            = newType("link-gone", http.StatusGone, "Link is no longer available")
+	LinkShared          = newType("link-shared", http.StatusConflict, "Link is shared with other users")
+	BodyTooLarge        = newType("body-too-large", http.StatusRequestEntityTooLarge, "Request body is too large")
+	UnsupportedEncoding = newType("unsupported-encoding", http.StatusUnsupportedMediaType, "Content encoding is not supported")
+	Internal            = newType("internal", http.StatusInternalServerError, "Unexpected internal error")
+	NotImplemented      = newType("not-implemented", http.StatusNotImplemented, "Not implemented")
+	Unavailable         = newType("unavailable", http.StatusServiceUnavailable, "Service is temporarily unavailable")
+	Timeout             = newType("timeout", http.StatusServiceUnavailable, "Request timed out")
+)
+
+// FieldError — ошибка в одном поле запроса. Field — имя поля в json
+// или параметра запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem — тело ответа с ошибкой
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+// New создаёт ошибку вида t, detail поясняет, что именно не так
+func New(t Type, detail string) *Problem {
+	return &Problem{Type: t.URI, Title: t.Title, Status: t.Status, Detail: detail}
+}
+
+// Newf — New с форматированием detail
+func Newf(t Type, format string, args ...any) *Problem {
+	return New(t, fmt.Sprintf(format, args...))
+}
+
+// Invalid — ошибка валидации с перечнем неверных полей
+func Invalid(fields ...FieldError) *Problem {
+	p := New(ValidationFailed, "")
+	p.Errors = fields
+	if len(fields) == 1 {
+		p.Detail = fields[0].Field + ": " + fields[0].Message
+	}
+	return p
+}
+
+// Field — короткая запись FieldError для Invalid
+func Field(name, message string) FieldError {
+	return FieldError{Field: name, Message: message}
+}
+
+// WithStatus меняет статус ответа, вид ошибки остаётся прежним
+func (p *Problem) WithStatus(status int) *Problem {
+	p.Status = status
+	return p
+}
+
+// Write отвечает ошибкой p. Клиентам, которые явно принимают только text/plain,
+// ошибка уходит текстом, остальным — application/problem+json
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	body, contentType := Render(r, p)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+// Render дополняет p путём и ID запроса, кодирует в формат, подходящий клиенту,
+// и возвращает тело и Content-Type
+func Render(r *http.Request, p *Problem) ([]byte, string) {
+	p.Instance = r.URL.Path
+	p.RequestID = requestid.FromContext(r.Context()).ID
+	if prefersText(r.Header.Get("Accept")) {
+		return []byte(p.Error() + "\n"), "text/plain; charset=utf-8"
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		logger.Logger.Error(err)
+		return []byte(p.Error() + "\n"), "text/plain; charset=utf-8"
+	}
+	return body, ContentType
+}
+
+// Error отвечает ошибкой вида t, detail можно не указывать
+func Error(w http.ResponseWriter, r *http.Request, t Type, detail string) {
+	Write(w, r, New(t, detail))
+}
+
+// InternalError пишет err в лог и отвечает 500, не раскрывая подробностей клиенту
+func InternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logger.Logger.Errorw("internal error", "error", err, "request_id", requestid.FromContext(r.Context()).ID)
+	Write(w, r, New(Internal, ""))
+}
+
+// prefersText — в Accept есть text/plain и нет ни json, ни */*
+func prefersText(accept string) bool {
+	text := false
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch {
+		case mt == "text/plain":
+			text = true
+		case mt == "*/*", mt == "application/*", strings.HasSuffix(mt, "json"):
+			return false
+		}
+	}
+	return text
+}
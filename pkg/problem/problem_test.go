@@ -0,0 +1,54 @@
+package problem
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrefersText(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "text/plain", want: true},
+		{accept: "text/plain; charset=utf-8", want: true},
+		{accept: "text/plain, application/json", want: false},
+		{accept: "text/plain, */*;q=0.1", want: false},
+		{accept: "application/problem+json", want: false},
+		{accept: "text/plain;q=0, text/html", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			assert.Equal(t, test.want, prefersText(test.accept))
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/shorten?x=1", nil)
+	w := httptest.NewRecorder()
+	Write(w, r, Invalid(Field("url", "is required")))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	var p Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, Problem{
+		Type:     ValidationFailed.URI,
+		Title:    ValidationFailed.Title,
+		Status:   http.StatusBadRequest,
+		Detail:   "url: is required",
+		Instance: "/api/shorten",
+		Errors:   []FieldError{{Field: "url", Message: "is required"}},
+	}, p)
+
+	w = httptest.NewRecorder()
+	Write(w, r, New(LinkNotFound, "").WithStatus(http.StatusGone))
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), `"type":"/problems/link-not-found"`)
+}
@@ -0,0 +1,23 @@
+// Package requestid переносит в контексте идентификатор запроса и адрес клиента.
+// Их кладут middleware и интерцепторы, а читают журнал аудита, ошибки API и логи
+package requestid
+
+import "context"
+
+// Info — данные запроса, по которым его можно найти в логах и журнале аудита
+type Info struct {
+	ID       string
+	ClientIP string
+}
+
+type infoKey struct{}
+
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+// FromContext возвращает пустой Info, если запрос его не нёс
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(infoKey{}).(Info)
+	return info
+}